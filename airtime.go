@@ -0,0 +1,185 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LoRaParameters are the parameters that determine the time-on-air of a
+// LoRa transmission. The bandwidth is given in kHz and the coding rate
+// as the denominator of 4/x, between [5, 8]. The preamble length is the
+// number of programmed preamble symbols.
+type LoRaParameters struct {
+	SpreadingFactor     uint8
+	BandWidth           uint16
+	CodingRate          uint8
+	PreambleLength      uint16
+	ImplicitHeader      bool
+	CRC                 bool
+	LowDataRateOptimize bool
+}
+
+// FSKParameters are the parameters that determine the time-on-air of an
+// FSK transmission. The bit rate is given in bit/s, the preamble and sync
+// word lengths in bytes. A variable length packet has a length byte.
+type FSKParameters struct {
+	BitRate          uint32
+	PreambleLength   uint16
+	SyncWordLength   uint8
+	VariableLength   bool
+	AddressFiltering bool
+	CRC              bool
+}
+
+// LoRaTimeOnAir returns the time it takes to transmit a payload of the
+// given length with the LoRa parameters.
+func LoRaTimeOnAir(p LoRaParameters, payloadLength int) (time.Duration, error) {
+	if p.SpreadingFactor < 7 || p.SpreadingFactor > 12 {
+		return 0, errors.Errorf("invalid spreading factor %v", p.SpreadingFactor)
+	}
+
+	if _, ok := BWs[p.BandWidth]; !ok {
+		return 0, errors.Errorf("invalid bandwidth %v", p.BandWidth)
+	}
+
+	if p.CodingRate < 5 || p.CodingRate > 8 {
+		return 0, errors.Errorf("invalid coding rate %v", p.CodingRate)
+	}
+
+	if payloadLength < 0 || payloadLength > 255 {
+		return 0, errors.Errorf("invalid payload length %v", payloadLength)
+	}
+
+	sf := float64(p.SpreadingFactor)
+	symbol := math.Exp2(sf) / (float64(p.BandWidth) * 1000)
+
+	var crc, ih, de float64
+	if p.CRC {
+		crc = 1
+	}
+	if p.ImplicitHeader {
+		ih = 1
+	}
+	if p.LowDataRateOptimize {
+		de = 1
+	}
+
+	preamble := (float64(p.PreambleLength) + 4.25) * symbol
+
+	n := math.Ceil((8*float64(payloadLength)-4*sf+28+16*crc-20*ih)/(4*(sf-2*de))) * float64(p.CodingRate)
+	payload := (8 + math.Max(n, 0)) * symbol
+
+	return time.Duration((preamble + payload) * float64(time.Second)), nil
+}
+
+// FSKTimeOnAir returns the time it takes to transmit a payload of the
+// given length with the FSK parameters.
+func FSKTimeOnAir(p FSKParameters, payloadLength int) (time.Duration, error) {
+	if p.BitRate == 0 {
+		return 0, errors.New("invalid bit rate 0")
+	}
+
+	if payloadLength < 0 || payloadLength > 255 {
+		return 0, errors.Errorf("invalid payload length %v", payloadLength)
+	}
+
+	bytes := int(p.PreambleLength) + int(p.SyncWordLength) + payloadLength
+	if p.VariableLength {
+		bytes++
+	}
+	if p.AddressFiltering {
+		bytes++
+	}
+	if p.CRC {
+		bytes += 2
+	}
+
+	return time.Duration(float64(bytes*8) / float64(p.BitRate) * float64(time.Second)), nil
+}
+
+// RadioTimeOnAir returns the time it takes to transmit a payload of the given
+// length with the current radio settings of the module.
+func RadioTimeOnAir(payloadLength int) (time.Duration, error) {
+	mod := RadioGetModulation()
+	if mod != LoRa && mod != FSK {
+		return 0, errors.Errorf("time on air not supported for modulation %q", mod)
+	}
+
+	prlen, err := RadioGetPreambleLength()
+	if err != nil {
+		return 0, err
+	}
+
+	if mod == FSK {
+		return fskRadioTimeOnAir(prlen, payloadLength)
+	}
+
+	p := LoRaParameters{
+		SpreadingFactor: RadioGetSpreadingFactor(),
+		BandWidth:       RadioGetBandWidth(),
+		CodingRate:      RadioGetCodingRate(),
+		PreambleLength:  prlen,
+		CRC:             RadioGetCrc(),
+	}
+	p.LowDataRateOptimize = lowDataRateOptimize(p.SpreadingFactor, p.BandWidth)
+
+	return LoRaTimeOnAir(p, payloadLength)
+}
+
+// fskRadioTimeOnAir returns the time on air with the current FSK settings.
+// The module always sends variable length packets without address filtering.
+func fskRadioTimeOnAir(prlen uint16, payloadLength int) (time.Duration, error) {
+	rate, err := RadioGetBitRate()
+	if err != nil {
+		return 0, err
+	}
+
+	sync, err := RadioGetSyncWordHex()
+	if err != nil {
+		return 0, err
+	}
+
+	p := FSKParameters{
+		BitRate:        rate,
+		PreambleLength: prlen,
+		SyncWordLength: uint8(len(sync) / 2),
+		VariableLength: true,
+		CRC:            RadioGetCrc(),
+	}
+
+	return FSKTimeOnAir(p, payloadLength)
+}
+
+// lowDataRateOptimize returns whether the module enables the low data rate
+// optimization, which it does when a symbol takes 16 ms or more.
+func lowDataRateOptimize(sf uint8, bw uint16) bool {
+	if bw == 0 {
+		return false
+	}
+
+	return (1<<sf)/uint32(bw) >= 16
+}
@@ -0,0 +1,158 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoRaTimeOnAir(t *testing.T) {
+	tests := []struct {
+		p        LoRaParameters
+		length   int
+		expected time.Duration
+	}{
+		{LoRaParameters{7, 125, 5, 8, false, true, false}, 10, 41216 * time.Microsecond},
+		{LoRaParameters{12, 125, 5, 8, false, true, true}, 51, 2465792 * time.Microsecond},
+		{LoRaParameters{7, 500, 8, 8, true, false, false}, 0, 5184 * time.Microsecond},
+	}
+
+	for _, test := range tests {
+		toa, err := LoRaTimeOnAir(test.p, test.length)
+		if err != nil {
+			t.Errorf("LoRaTimeOnAir(%+v, %v) returned an error: %v", test.p, test.length, err)
+			continue
+		}
+
+		if diff := toa - test.expected; diff < -time.Microsecond || diff > time.Microsecond {
+			t.Errorf("LoRaTimeOnAir(%+v, %v) = %v; should be %v", test.p, test.length, toa, test.expected)
+		}
+	}
+}
+
+func TestLoRaTimeOnAirInvalid(t *testing.T) {
+	invalid := []LoRaParameters{
+		{6, 125, 5, 8, false, true, false},
+		{7, 100, 5, 8, false, true, false},
+		{7, 125, 4, 8, false, true, false},
+	}
+
+	for _, p := range invalid {
+		if _, err := LoRaTimeOnAir(p, 10); err == nil {
+			t.Errorf("LoRaTimeOnAir(%+v, 10) returned no error", p)
+		}
+	}
+
+	if _, err := LoRaTimeOnAir(LoRaParameters{7, 125, 5, 8, false, true, false}, 256); err == nil {
+		t.Error("LoRaTimeOnAir() returned no error with a payload of 256 bytes")
+	}
+}
+
+func TestFSKTimeOnAir(t *testing.T) {
+	p := FSKParameters{BitRate: 50000, PreambleLength: 5, SyncWordLength: 3, VariableLength: true, CRC: true}
+
+	toa, err := FSKTimeOnAir(p, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if toa != 3360*time.Microsecond {
+		t.Errorf("FSKTimeOnAir(%+v, 10) = %v; should be 3.36ms", p, toa)
+	}
+
+	if _, err := FSKTimeOnAir(FSKParameters{}, 10); err == nil {
+		t.Error("FSKTimeOnAir() returned no error with bit rate 0")
+	}
+}
+
+func TestRadioTimeOnAir(t *testing.T) {
+	mockSerial(t, map[string]string{
+		"radio get mod":   "lora",
+		"radio get sf":    "sf12",
+		"radio get bw":    "125",
+		"radio get cr":    "4/5",
+		"radio get crc":   "on",
+		"radio get prlen": "8",
+	})
+	defer resetOriginals()
+
+	toa, err := RadioTimeOnAir(51)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if toa != 2465792*time.Microsecond {
+		t.Errorf("RadioTimeOnAir(51) = %v; should be 2.465792s", toa)
+	}
+}
+
+func TestRadioTimeOnAirFSK(t *testing.T) {
+	mockSerial(t, map[string]string{
+		"radio get mod":     "fsk",
+		"radio get prlen":   "5",
+		"radio get bitrate": "50000",
+		"radio get sync":    "c194c1",
+		"radio get crc":     "on",
+	})
+	defer resetOriginals()
+
+	// 5 preamble + 3 sync + 1 length + 10 payload + 2 crc bytes
+	toa, err := RadioTimeOnAir(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if toa != 3360*time.Microsecond {
+		t.Errorf("RadioTimeOnAir(10) = %v; should be 3.36ms", toa)
+	}
+}
+
+func TestRadioTimeOnAirPreamble(t *testing.T) {
+	mockSerial(t, map[string]string{
+		"radio get mod":   "lora",
+		"radio get sf":    "sf7",
+		"radio get bw":    "125",
+		"radio get cr":    "4/5",
+		"radio get crc":   "on",
+		"radio get prlen": "16",
+	})
+	defer resetOriginals()
+
+	toa, err := RadioTimeOnAir(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := LoRaTimeOnAir(LoRaParameters{
+		SpreadingFactor: 7,
+		BandWidth:       125,
+		CodingRate:      5,
+		PreambleLength:  16,
+		CRC:             true,
+	}, 10)
+
+	if toa != want {
+		t.Errorf("RadioTimeOnAir(10) = %v; should be %v", toa, want)
+	}
+}
@@ -0,0 +1,218 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DischargePoint is a point of a discharge curve: the charge of the
+// battery in percent at the given supply voltage in millivolts.
+type DischargePoint struct {
+	Millivolts uint16
+	Percent    float64
+}
+
+// DefaultDischargeCurve is the discharge curve of two alkaline AA cells.
+var DefaultDischargeCurve = []DischargePoint{
+	{3200, 100},
+	{3000, 90},
+	{2800, 70},
+	{2600, 45},
+	{2400, 20},
+	{2200, 5},
+	{2000, 0},
+}
+
+// BatteryLevel is a reading of the battery monitor.
+type BatteryLevel struct {
+	Time time.Time
+	// Millivolts is the smoothed supply voltage
+	Millivolts float64
+	Percent    float64
+	Low        bool
+}
+
+// BatteryMonitor samples the supply voltage, smooths it with an exponential
+// moving average and maps it to a charge with the discharge curve.
+type BatteryMonitor struct {
+	// Curve is the discharge curve, sorted by decreasing voltage
+	Curve []DischargePoint
+	// Smoothing is the weight of a new sample in (0, 1], 1 disables smoothing
+	Smoothing float64
+	// LowPercent is the charge below which the battery is low, the battery
+	// is no longer low once it is Hysteresis percent above it
+	LowPercent float64
+	Hysteresis float64
+	// ReportToNetwork sets the level with MacSetBattery after every sample
+	ReportToNetwork bool
+	// OnLow is called when the battery becomes low, OnRecovered when it
+	// is no longer low
+	OnLow       func(l BatteryLevel)
+	OnRecovered func(l BatteryLevel)
+
+	interval time.Duration
+	mu       sync.Mutex
+	level    BatteryLevel
+	sampled  bool
+}
+
+// NewBatteryMonitor returns a monitor sampling at the interval, with the
+// default discharge curve and a low battery below 20%.
+func NewBatteryMonitor(interval time.Duration) *BatteryMonitor {
+	return &BatteryMonitor{
+		Curve:           DefaultDischargeCurve,
+		Smoothing:       0.25,
+		LowPercent:      20,
+		Hysteresis:      5,
+		ReportToNetwork: true,
+		interval:        interval,
+	}
+}
+
+// Level returns the last reading, false when there is none yet.
+func (b *BatteryMonitor) Level() (BatteryLevel, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.level, b.sampled
+}
+
+// Sample reads the supply voltage and updates the battery level.
+func (b *BatteryMonitor) Sample() (BatteryLevel, error) {
+	millivolts, err := Voltage()
+	if err != nil {
+		return BatteryLevel{}, errors.Wrap(err, "could not sample battery")
+	}
+
+	b.mu.Lock()
+
+	level := b.level
+	level.Time = time.Now()
+
+	if b.sampled && b.Smoothing > 0 && b.Smoothing < 1 {
+		level.Millivolts += b.Smoothing * (float64(millivolts) - level.Millivolts)
+	} else {
+		level.Millivolts = float64(millivolts)
+	}
+
+	level.Percent = b.percent(level.Millivolts)
+
+	var event func(l BatteryLevel)
+	switch {
+	case !level.Low && level.Percent < b.LowPercent:
+		level.Low = true
+		event = b.OnLow
+	case level.Low && level.Percent >= b.LowPercent+b.Hysteresis:
+		level.Low = false
+		event = b.OnRecovered
+	}
+
+	b.level = level
+	b.sampled = true
+	report := b.ReportToNetwork
+
+	b.mu.Unlock()
+
+	// the event fires even when the report fails, as the next sample won't
+	// see the change again
+	if event != nil {
+		event(level)
+	}
+
+	if report {
+		if err := MacSetBattery(BatteryMacLevel(level.Percent)); err != nil {
+			return level, err
+		}
+	}
+
+	return level, nil
+}
+
+// percent maps the voltage on the discharge curve, interpolating between
+// its points.
+func (b *BatteryMonitor) percent(millivolts float64) float64 {
+	curve := b.Curve
+	if len(curve) == 0 {
+		return 0
+	}
+
+	if millivolts >= float64(curve[0].Millivolts) {
+		return curve[0].Percent
+	}
+
+	for i := 1; i < len(curve); i++ {
+		high, low := curve[i-1], curve[i]
+
+		if millivolts >= float64(low.Millivolts) {
+			span := float64(high.Millivolts) - float64(low.Millivolts)
+			if span <= 0 {
+				return low.Percent
+			}
+
+			return low.Percent + (millivolts-float64(low.Millivolts))/span*(high.Percent-low.Percent)
+		}
+	}
+
+	return curve[len(curve)-1].Percent
+}
+
+// BatteryMacLevel converts a charge in percent to the [1-254] battery level
+// of MacSetBattery.
+func BatteryMacLevel(percent float64) uint8 {
+	if percent < 0 {
+		percent = 0
+	}
+
+	if percent > 100 {
+		percent = 100
+	}
+
+	return uint8(1 + round(percent*253/100))
+}
+
+// Run samples the battery until the stop channel is closed. It fails when
+// the monitor has no positive interval.
+func (b *BatteryMonitor) Run(stop <-chan struct{}) error {
+	if b.interval <= 0 {
+		return errors.Errorf("invalid battery monitor interval %v", b.interval)
+	}
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := b.Sample(); err != nil {
+			WARN.Println("battery monitor error:", err)
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBatteryPercent(t *testing.T) {
+	b := NewBatteryMonitor(0)
+
+	tests := map[float64]float64{
+		3300: 100,
+		3200: 100,
+		3100: 95,
+		2500: 32.5,
+		2000: 0,
+		1800: 0,
+	}
+
+	for millivolts, percent := range tests {
+		if got := b.percent(millivolts); math.Abs(got-percent) > 1e-9 {
+			t.Errorf("percent(%v) = %v; should be %v", millivolts, got, percent)
+		}
+	}
+}
+
+func TestBatteryMacLevel(t *testing.T) {
+	tests := map[float64]uint8{-5: 1, 0: 1, 50: 128, 100: 254, 120: 254}
+
+	for percent, level := range tests {
+		if got := BatteryMacLevel(percent); got != level {
+			t.Errorf("BatteryMacLevel(%v) = %v; should be %v", percent, got, level)
+		}
+	}
+}
+
+func TestBatteryMonitor(t *testing.T) {
+	defer resetOriginals()
+	answers := map[string]string{"sys get vdd": "3000"}
+	written := mockSerial(t, answers)
+
+	var low, recovered int
+	b := NewBatteryMonitor(0)
+	b.Smoothing = 0.5
+	b.OnLow = func(l BatteryLevel) { low++ }
+	b.OnRecovered = func(l BatteryLevel) { recovered++ }
+
+	if _, ok := b.Level(); ok {
+		t.Error("Level() returned a reading before the first sample")
+	}
+
+	l, err := b.Sample()
+	if err != nil || l.Millivolts != 3000 || l.Percent != 90 {
+		t.Fatalf("Sample() = %+v, %v; should be 3000 mV and 90%%", l, err)
+	}
+	if (*written)[1] != "mac set bat 229" {
+		t.Errorf("command = %v; should be mac set bat 229", (*written)[1])
+	}
+
+	// the smoothing halves the drop to 2000 mV
+	answers["sys get vdd"] = "2000"
+	if l, _ = b.Sample(); l.Millivolts != 2500 || l.Low {
+		t.Errorf("Sample() = %+v; should be 2500 mV and not low", l)
+	}
+
+	if l, _ = b.Sample(); l.Millivolts != 2250 || !l.Low || low != 1 {
+		t.Errorf("Sample() = %+v with %v events; should be low at 2250 mV", l, low)
+	}
+
+	// the battery stays low until it is 5% above the low level
+	answers["sys get vdd"] = "2550"
+	if l, _ = b.Sample(); !l.Low || recovered != 0 {
+		t.Errorf("Sample() = %+v; should still be low", l)
+	}
+	answers["sys get vdd"] = "2900"
+	if l, _ = b.Sample(); l.Low || recovered != 1 || low != 1 {
+		t.Errorf("Sample() = %+v with %v, %v events; should have recovered", l, low, recovered)
+	}
+
+	if last, ok := b.Level(); !ok || last != l {
+		t.Errorf("Level() = %+v; should be %+v", last, l)
+	}
+}
+
+func TestBatteryMonitorReportFails(t *testing.T) {
+	defer resetOriginals()
+	mockSerial(t, map[string]string{"sys get vdd": "2000", "mac set bat": "invalid_param"})
+
+	var low int
+	b := NewBatteryMonitor(0)
+	b.OnLow = func(l BatteryLevel) { low++ }
+
+	if l, err := b.Sample(); err == nil || !l.Low || low != 1 {
+		t.Errorf("Sample() = %+v, %v with %v events; should be low and fail", l, err, low)
+	}
+
+	if err := b.Run(make(chan struct{})); err == nil {
+		t.Error("Run() accepted a zero interval")
+	}
+}
@@ -0,0 +1,253 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The commands of the bootloader. Every command starts with a header of the
+// command, the data length (LE), the unlock sequence 0x55 0xAA and the
+// address (LE), which the bootloader echoes before its answer.
+const (
+	bootCmdVersion = 0x00
+	bootCmdRead    = 0x01
+	bootCmdWrite   = 0x02
+	bootCmdErase   = 0x03
+	bootCmdReset   = 0x09
+)
+
+const (
+	bootHeaderLength  = 9
+	bootVersionLength = 16
+	bootSuccess       = 0x01
+	// bootAutoBaud is sent first, the bootloader measures the baud rate on it
+	bootAutoBaud = 0x55
+	// bootMaxEmptyReads is the number of reads without data before a timeout
+	bootMaxEmptyReads = 50
+)
+
+// The stages of a firmware update, reported to the progress function
+const (
+	StageErase  = "erase"
+	StageWrite  = "write"
+	StageVerify = "verify"
+)
+
+// Bootloader talks to the bootloader of the module over the serial port,
+// after sys eraseFW dropped the module into it.
+type Bootloader struct {
+	port io.ReadWriter
+	// EraseSize and WriteSize are the sizes of an erase row and a write block
+	EraseSize int
+	WriteSize int
+	// Start and End limit the flash addresses that are updated
+	Start uint32
+	End   uint32
+	// Progress is called during an update, when set
+	Progress func(stage string, done, total int)
+
+	// release gives the serial port back to the commands of the module
+	release func()
+}
+
+// NewBootloader returns a bootloader on the port, with the flash geometry of
+// the PIC18 in the RN modules.
+func NewBootloader(port io.ReadWriter) *Bootloader {
+	return &Bootloader{
+		port:      port,
+		EraseSize: 64,
+		WriteSize: 64,
+		Start:     0x000000,
+		End:       0x010000,
+	}
+}
+
+// Sync sends the auto baud byte and checks the bootloader answers.
+// It returns the version information of the bootloader.
+func (b *Bootloader) Sync() ([]byte, error) {
+	if _, err := b.port.Write([]byte{bootAutoBaud}); err != nil {
+		return nil, errors.Wrap(err, "could not sync with bootloader")
+	}
+
+	version, err := b.command(bootCmdVersion, 0, 0, nil, bootVersionLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sync with bootloader")
+	}
+
+	return version, nil
+}
+
+// Erase erases the flash rows starting at the address.
+func (b *Bootloader) Erase(address uint32, rows int) error {
+	return b.status(bootCmdErase, uint16(rows), address, nil)
+}
+
+// Write writes a block of data to the flash at the address.
+func (b *Bootloader) Write(address uint32, data []byte) error {
+	return b.status(bootCmdWrite, uint16(len(data)), address, data)
+}
+
+// Read reads length bytes of flash at the address.
+func (b *Bootloader) Read(address uint32, length int) ([]byte, error) {
+	return b.command(bootCmdRead, uint16(length), address, nil, length)
+}
+
+// Reset leaves the bootloader and starts the firmware.
+func (b *Bootloader) Reset() error {
+	return b.status(bootCmdReset, 0, 0, nil)
+}
+
+// Update erases the flash used by the image, writes and verifies the image
+// and resets the module into the new firmware. The bootloader is closed
+// after a successful update.
+func (b *Bootloader) Update(img *FirmwareImage) error {
+	if _, err := b.Sync(); err != nil {
+		return err
+	}
+
+	rows := img.Blocks(b.EraseSize, b.Start, b.End)
+	blocks := img.Blocks(b.WriteSize, b.Start, b.End)
+
+	if len(blocks) == 0 {
+		return errors.New("firmware image has no data to write")
+	}
+
+	for i, row := range rows {
+		if err := b.Erase(row.Address, 1); err != nil {
+			return errors.Wrapf(err, "could not erase 0x%06X", row.Address)
+		}
+		b.progress(StageErase, i+1, len(rows))
+	}
+
+	for i, block := range blocks {
+		if err := b.Write(block.Address, block.Data); err != nil {
+			return errors.Wrapf(err, "could not write 0x%06X", block.Address)
+		}
+		b.progress(StageWrite, i+1, len(blocks))
+	}
+
+	for i, block := range blocks {
+		data, err := b.Read(block.Address, len(block.Data))
+		if err != nil {
+			return errors.Wrapf(err, "could not read 0x%06X", block.Address)
+		}
+
+		if !bytes.Equal(data, block.Data) {
+			return errors.Errorf("verification failed at 0x%06X", block.Address)
+		}
+		b.progress(StageVerify, i+1, len(blocks))
+	}
+
+	if err := b.Reset(); err != nil {
+		return err
+	}
+
+	b.Close()
+	return nil
+}
+
+// Close gives the serial port back to the commands of the module. A
+// bootloader of EraseFirmware holds the port until it is closed or an
+// update succeeds, so no other reader takes its answers.
+func (b *Bootloader) Close() {
+	if b.release != nil {
+		b.release()
+		b.release = nil
+	}
+}
+
+func (b *Bootloader) progress(stage string, done, total int) {
+	if b.Progress != nil {
+		b.Progress(stage, done, total)
+	}
+}
+
+// status sends a command that is answered with a status byte.
+func (b *Bootloader) status(cmd byte, length uint16, address uint32, data []byte) error {
+	answer, err := b.command(cmd, length, address, data, 1)
+	if err != nil {
+		return err
+	}
+
+	if answer[0] != bootSuccess {
+		return errors.Errorf("bootloader error 0x%02X", answer[0])
+	}
+
+	return nil
+}
+
+// command sends a command and returns the answer of the given length,
+// after checking the echoed header.
+func (b *Bootloader) command(cmd byte, length uint16, address uint32, data []byte, answerLength int) ([]byte, error) {
+	header := make([]byte, bootHeaderLength)
+	header[0] = cmd
+	binary.LittleEndian.PutUint16(header[1:], length)
+	header[3] = 0x55
+	header[4] = 0xAA
+	binary.LittleEndian.PutUint32(header[5:], address)
+
+	if _, err := b.port.Write(append(header, data...)); err != nil {
+		return nil, errors.Wrap(err, "could not write to bootloader")
+	}
+
+	echo, err := b.read(bootHeaderLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(echo, header) {
+		return nil, errors.Errorf("bootloader answered to the wrong command: % X", echo)
+	}
+
+	return b.read(answerLength)
+}
+
+// read reads exactly n bytes, allowing a number of reads without data
+// as the serial port returns when its read timeout expires.
+func (b *Bootloader) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	done, empty := 0, 0
+
+	for done < n {
+		m, err := b.port.Read(buf[done:])
+		done += m
+
+		if m == 0 {
+			if err != nil && err != io.EOF {
+				return nil, errors.Wrap(err, "could not read from bootloader")
+			}
+
+			empty++
+			if empty >= bootMaxEmptyReads {
+				return nil, errors.New("bootloader timeout")
+			}
+		}
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,243 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// bootloaderEmulator emulates the bootloader of the module on a 64 KB flash.
+type bootloaderEmulator struct {
+	flash  []byte
+	in     []byte
+	out    bytes.Buffer
+	synced bool
+	reset  bool
+	// failWrite makes the write at this address fail, when not 0
+	failWrite uint32
+}
+
+func newBootloaderEmulator() *bootloaderEmulator {
+	e := &bootloaderEmulator{flash: make([]byte, 0x10000)}
+	for i := range e.flash {
+		e.flash[i] = 0xA5
+	}
+
+	return e
+}
+
+func (e *bootloaderEmulator) Write(p []byte) (int, error) {
+	e.in = append(e.in, p...)
+
+	if !e.synced && len(e.in) > 0 && e.in[0] == bootAutoBaud {
+		e.synced = true
+		e.in = e.in[1:]
+	}
+
+	for len(e.in) >= bootHeaderLength {
+		header := e.in[:bootHeaderLength]
+		length := int(binary.LittleEndian.Uint16(header[1:]))
+		address := int(binary.LittleEndian.Uint32(header[5:]))
+
+		data := 0
+		if header[0] == bootCmdWrite {
+			data = length
+		}
+		if len(e.in) < bootHeaderLength+data {
+			break
+		}
+
+		e.out.Write(header)
+
+		switch {
+		case header[3] != 0x55 || header[4] != 0xAA:
+			e.out.WriteByte(0xFE)
+		case header[0] == bootCmdVersion:
+			e.out.Write([]byte("RN bootloader 01"))
+		case header[0] == bootCmdRead:
+			e.out.Write(e.flash[address : address+length])
+		case header[0] == bootCmdWrite && uint32(address) == e.failWrite:
+			e.out.WriteByte(0xFF)
+		case header[0] == bootCmdWrite:
+			copy(e.flash[address:], e.in[bootHeaderLength:bootHeaderLength+length])
+			e.out.WriteByte(bootSuccess)
+		case header[0] == bootCmdErase:
+			for i := address; i < address+length*64; i++ {
+				e.flash[i] = 0xFF
+			}
+			e.out.WriteByte(bootSuccess)
+		case header[0] == bootCmdReset:
+			e.reset = true
+			e.out.WriteByte(bootSuccess)
+		default:
+			e.out.WriteByte(0xFE)
+		}
+
+		e.in = e.in[bootHeaderLength+data:]
+	}
+
+	return len(p), nil
+}
+
+func (e *bootloaderEmulator) Read(p []byte) (int, error) {
+	return e.out.Read(p)
+}
+
+// testHexImage writes 100 bytes at 0x0800, 8 bytes at 0x1000 and EEPROM data.
+func testHexImage() string {
+	var lines []string
+
+	record := func(address uint16, typ byte, data []byte) {
+		r := append([]byte{byte(len(data)), byte(address >> 8), byte(address), typ}, data...)
+		var sum byte
+		for _, b := range r {
+			sum += b
+		}
+		lines = append(lines, fmt.Sprintf(":%X", append(r, -sum)))
+	}
+
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	record(0x0800, hexData, data[:16])
+	record(0x0810, hexData, data[16:])
+	record(0x1000, hexData, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	record(0, hexExtendedLinearAddress, []byte{0x00, 0xF0})
+	record(0, hexData, []byte{0xAB})
+	record(0, hexEndOfFile, nil)
+
+	return strings.Join(lines, "\n")
+}
+
+func TestParseIntelHex(t *testing.T) {
+	img, err := ParseIntelHex(strings.NewReader(testHexImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(img.Segments) != 3 || img.Size() != 109 {
+		t.Fatalf("image = %v segments, %v bytes; should be 3 segments, 109 bytes", len(img.Segments), img.Size())
+	}
+
+	if img.Segments[0].Address != 0x0800 || len(img.Segments[0].Data) != 100 || img.Segments[0].Data[99] != 99 {
+		t.Errorf("segment 0 = 0x%X, %v bytes", img.Segments[0].Address, len(img.Segments[0].Data))
+	}
+	if img.Segments[2].Address != 0xF00000 {
+		t.Errorf("segment 2 address = 0x%X; should be 0xF00000", img.Segments[2].Address)
+	}
+
+	blocks := img.Blocks(64, 0, 0x10000)
+	if len(blocks) != 3 || blocks[1].Address != 0x0840 || blocks[1].Data[36] != 0xFF || blocks[2].Address != 0x1000 {
+		t.Errorf("blocks = %+v", blocks)
+	}
+}
+
+func TestParseIntelHexErrors(t *testing.T) {
+	tests := []string{
+		":0100000001FF\n:00000001FF",
+		"0100000001FE\n:00000001FF",
+		":0200000001FE\n:00000001FF",
+		":0100000001FE",
+		":00000001FF\n:0100000001FE",
+		":0100000601F8\n:00000001FF",
+	}
+
+	for _, test := range tests {
+		if _, err := ParseIntelHex(strings.NewReader(test)); err == nil {
+			t.Errorf("ParseIntelHex(%q) succeeded", test)
+		}
+	}
+}
+
+func TestBootloaderUpdate(t *testing.T) {
+	img, err := ParseIntelHex(strings.NewReader(testHexImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newBootloaderEmulator()
+	b := NewBootloader(e)
+
+	progress := make(map[string]int)
+	b.Progress = func(stage string, done, total int) {
+		if done != progress[stage]+1 {
+			t.Errorf("%v progress %v after %v", stage, done, progress[stage])
+		}
+		progress[stage] = done
+	}
+
+	if err := b.Update(img); err != nil {
+		t.Fatal(err)
+	}
+
+	if !e.reset {
+		t.Error("bootloader was not reset")
+	}
+
+	if progress[StageErase] != 3 || progress[StageWrite] != 3 || progress[StageVerify] != 3 {
+		t.Errorf("progress = %v", progress)
+	}
+
+	if e.flash[0x0800] != 0 || e.flash[0x0863] != 99 || e.flash[0x0864] != 0xFF || e.flash[0x1007] != 8 {
+		t.Error("flash doesn't hold the image")
+	}
+	if e.flash[0x07FF] != 0xA5 || e.flash[0x1040] != 0xA5 {
+		t.Error("flash outside the image was erased")
+	}
+}
+
+func TestBootloaderWriteError(t *testing.T) {
+	img, err := ParseIntelHex(strings.NewReader(testHexImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newBootloaderEmulator()
+	e.failWrite = 0x0840
+
+	if err := NewBootloader(e).Update(img); err == nil || !strings.Contains(err.Error(), "0x000840") {
+		t.Errorf("Update() = %v; should fail at 0x000840", err)
+	}
+	if e.reset {
+		t.Error("bootloader was reset after a failed update")
+	}
+}
+
+func TestBootloaderTimeout(t *testing.T) {
+	silent := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(""), ioutil.Discard}
+
+	if _, err := NewBootloader(silent).Sync(); err == nil || err.Error() != "could not sync with bootloader: bootloader timeout" {
+		t.Error("Sync() succeeded without bootloader")
+	}
+}
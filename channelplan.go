@@ -0,0 +1,344 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Channel is the configuration of one LoRaWAN channel.
+// The duty cycle is a percentage, 0 leaves the duty cycle unchanged.
+type Channel struct {
+	ID          uint8   `json:"id"`
+	Frequency   uint32  `json:"frequency"`
+	MinDataRate uint8   `json:"min_dr"`
+	MaxDataRate uint8   `json:"max_dr"`
+	DutyCycle   float32 `json:"duty_cycle,omitempty"`
+	Enabled     bool    `json:"enabled"`
+}
+
+// ChannelPlan is the configuration of the channels of the LoRaWAN stack.
+// Channels that are not in the plan are left unchanged when it is applied.
+type ChannelPlan struct {
+	Channels []Channel `json:"channels"`
+}
+
+// channelPlanFile is a channel plan as stored in a JSON file. Besides the
+// channels of a ChannelPlan, it accepts the extra_channels list exported by
+// network servers.
+type channelPlanFile struct {
+	Channels      []Channel `json:"channels"`
+	ExtraChannels []struct {
+		Frequency uint32 `json:"frequency"`
+		MinDR     uint8  `json:"min_dr"`
+		MaxDR     uint8  `json:"max_dr"`
+	} `json:"extra_channels"`
+}
+
+// ReadChannelPlan reads the configuration of all channels of the current
+// region from the module.
+func ReadChannelPlan() (*ChannelPlan, error) {
+	plan := &ChannelPlan{}
+
+	for id := uint8(0); id < region.Channels; id++ {
+		ch, _, err := readChannel(id)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Channels = append(plan.Channels, ch)
+	}
+
+	return plan, nil
+}
+
+// LoadChannelPlan decodes a channel plan from JSON. The plan either lists
+// the channels, or the extra_channels of a network server export. Extra
+// channels are enabled on the channels after the default channels, the
+// remaining channels are disabled.
+func LoadChannelPlan(r io.Reader) (*ChannelPlan, error) {
+	var f channelPlanFile
+
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, errors.Wrap(err, "could not decode channel plan")
+	}
+
+	plan := &ChannelPlan{Channels: f.Channels}
+	if len(f.ExtraChannels) == 0 {
+		return plan, nil
+	}
+
+	if len(f.Channels) != 0 {
+		return nil, errors.New("channel plan has both channels and extra_channels")
+	}
+
+	first := len(region.DefaultChannels)
+	if first+len(f.ExtraChannels) > int(region.Channels) {
+		return nil, errors.Errorf("too many extra channels for %v", region.Name)
+	}
+
+	for i := first; i < int(region.Channels); i++ {
+		ch := Channel{ID: uint8(i)}
+
+		if extra := i - first; extra < len(f.ExtraChannels) {
+			ch.Frequency = f.ExtraChannels[extra].Frequency
+			ch.MinDataRate = f.ExtraChannels[extra].MinDR
+			ch.MaxDataRate = f.ExtraChannels[extra].MaxDR
+			ch.Enabled = true
+		}
+
+		plan.Channels = append(plan.Channels, ch)
+	}
+
+	return plan, nil
+}
+
+// LoadChannelPlanFile reads a channel plan from a JSON file, see LoadChannelPlan.
+func LoadChannelPlanFile(name string) (*ChannelPlan, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open channel plan")
+	}
+	defer f.Close()
+
+	return LoadChannelPlan(f)
+}
+
+// Validate checks the channel plan against the current region.
+// A channel without frequency keeps its current frequency, a disabled channel
+// without frequency keeps all its parameters and is only disabled.
+func (p *ChannelPlan) Validate() error {
+	seen := make(map[uint8]bool)
+
+	for _, ch := range p.Channels {
+		if !region.ValidChannel(ch.ID) {
+			return errors.Errorf("channel %v: invalid channel id", ch.ID)
+		}
+
+		if seen[ch.ID] {
+			return errors.Errorf("channel %v: duplicate channel id", ch.ID)
+		}
+		seen[ch.ID] = true
+
+		if ch.Frequency != 0 && !region.ValidFrequency(ch.Frequency) {
+			return errors.Errorf("channel %v: invalid frequency %v", ch.ID, ch.Frequency)
+		}
+
+		if !region.ValidDataRate(ch.MinDataRate) || !region.ValidDataRate(ch.MaxDataRate) || ch.MinDataRate > ch.MaxDataRate {
+			return errors.Errorf("channel %v: invalid data rate range %v-%v", ch.ID, ch.MinDataRate, ch.MaxDataRate)
+		}
+
+		if ch.DutyCycle < 0 || ch.DutyCycle > 100 {
+			return errors.Errorf("channel %v: invalid duty cycle %v", ch.ID, ch.DutyCycle)
+		}
+
+		if ch.DutyCycle != 0 && len(region.SubBands) == 0 {
+			return errors.Errorf("channel %v: no duty cycle in %v", ch.ID, region.Name)
+		}
+	}
+
+	return nil
+}
+
+// channelChange is a single command of a channel plan, with the command
+// that reverts it.
+type channelChange struct {
+	apply  func() error
+	revert func() error
+}
+
+// Apply configures the module with the channel plan. Only the values that
+// differ from the module are changed. When a change fails, the changes that
+// were already made are reverted.
+func (p *ChannelPlan) Apply() error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	var applied []channelChange
+
+	rollback := func(err error) error {
+		for i := len(applied) - 1; i >= 0; i-- {
+			if rerr := applied[i].revert(); rerr != nil {
+				WARN.Println("channel plan rollback error:", rerr)
+			}
+		}
+
+		return err
+	}
+
+	for _, ch := range p.Channels {
+		current, dcycle, err := readChannel(ch.ID)
+		if err != nil {
+			return rollback(err)
+		}
+
+		changes, err := channelChanges(current, dcycle, ch)
+		if err != nil {
+			return rollback(err)
+		}
+
+		for _, c := range changes {
+			if err := c.apply(); err != nil {
+				return rollback(errors.Wrapf(err, "channel %v", ch.ID))
+			}
+
+			applied = append(applied, c)
+		}
+	}
+
+	return nil
+}
+
+// channelChanges returns the changes needed to go from the current channel
+// configuration to the wanted one. A channel is disabled before and enabled
+// after its other parameters change.
+func channelChanges(current Channel, dcycle uint16, want Channel) ([]channelChange, error) {
+	var changes []channelChange
+	id := want.ID
+
+	if current.Enabled && !want.Enabled {
+		changes = append(changes, channelChange{
+			apply:  func() error { return MacSetChannelStatus(id, false) },
+			revert: func() error { return MacSetChannelStatus(id, true) },
+		})
+	}
+
+	if !want.Enabled && want.Frequency == 0 {
+		return changes, nil
+	}
+
+	if want.Frequency != 0 && want.Frequency != current.Frequency {
+		if region.IsDefaultChannel(id) {
+			return nil, errors.Errorf("channel %v: default channel frequency cannot be modified", id)
+		}
+
+		changes = append(changes, channelChange{
+			apply: func() error { return MacSetChannelFrequency(id, want.Frequency) },
+			revert: func() error {
+				// an unused channel has no frequency to go back to
+				if current.Frequency == 0 {
+					return nil
+				}
+				return MacSetChannelFrequency(id, current.Frequency)
+			},
+		})
+	}
+
+	if want.MinDataRate != current.MinDataRate || want.MaxDataRate != current.MaxDataRate {
+		changes = append(changes, channelChange{
+			apply:  func() error { return MacSetChannelDataRateRange(id, want.MinDataRate, want.MaxDataRate) },
+			revert: func() error { return MacSetChannelDataRateRange(id, current.MinDataRate, current.MaxDataRate) },
+		})
+	}
+
+	if want.DutyCycle != 0 && dutyCycleValue(want.DutyCycle) != dcycle {
+		changes = append(changes, channelChange{
+			apply:  func() error { return MacSetChannelDutyCycle(id, want.DutyCycle) },
+			revert: func() error { return setChannelDutyCycleValue(id, dcycle) },
+		})
+	}
+
+	if !current.Enabled && want.Enabled {
+		changes = append(changes, channelChange{
+			apply:  func() error { return MacSetChannelStatus(id, true) },
+			revert: func() error { return MacSetChannelStatus(id, false) },
+		})
+	}
+
+	return changes, nil
+}
+
+// readChannel reads the configuration of a channel from the module, together
+// with the duty cycle value as used by the module.
+func readChannel(id uint8) (Channel, uint16, error) {
+	ch := Channel{ID: id}
+
+	answer, err := macGetChannel("freq", id)
+	if err != nil {
+		return ch, 0, err
+	}
+
+	frequency, err := strconv.ParseUint(answer, 10, 32)
+	if err != nil {
+		return ch, 0, errors.Wrapf(err, "could not get channel %v frequency", id)
+	}
+	ch.Frequency = uint32(frequency)
+
+	ch.MinDataRate, ch.MaxDataRate, err = MacGetChannelDataRateRange(id)
+	if err != nil {
+		return ch, 0, errors.Wrapf(err, "channel %v", id)
+	}
+
+	var dcycle uint64
+	if len(region.SubBands) != 0 {
+		answer, err = macGetChannel("dcycle", id)
+		if err != nil {
+			return ch, 0, err
+		}
+
+		dcycle, err = strconv.ParseUint(answer, 10, 16)
+		if err != nil {
+			return ch, 0, errors.Wrapf(err, "could not get channel %v duty cycle", id)
+		}
+		ch.DutyCycle = 100 / float32(dcycle+1)
+	}
+
+	answer, err = macGetChannel("status", id)
+	if err != nil {
+		return ch, 0, err
+	}
+	ch.Enabled = answer == "on"
+
+	return ch, uint16(dcycle), nil
+}
+
+// macGetChannel returns the answer of mac get ch for the parameter and channel.
+func macGetChannel(param string, id uint8) (string, error) {
+	answer, err := query(fmt.Sprintf("mac get ch %s %v", param, id))
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get channel %v %s", id, param)
+	}
+
+	return answer, nil
+}
+
+// setChannelDutyCycleValue restores a duty cycle value as read from the module.
+func setChannelDutyCycleValue(id uint8, value uint16) error {
+	n, answer, err := exchange(fmt.Sprintf("mac set ch dcycle %v %v", id, value))
+	if err != nil {
+		return errors.Wrap(err, "could not set channel duty cycle")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set channel duty cycle: invalid parameter")
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeChannels emulates the channel commands of the module.
+type fakeChannels struct {
+	channels [16]struct {
+		frequency uint64
+		min, max  uint64
+		dcycle    uint64
+		status    string
+	}
+	fail    string
+	written []string
+}
+
+func newFakeChannels(t *testing.T) *fakeChannels {
+	f := &fakeChannels{}
+	for i, frequency := range []uint64{868100000, 868300000, 868500000} {
+		f.channels[i].frequency = frequency
+		f.channels[i].max = 5
+		f.channels[i].dcycle = 99
+		f.channels[i].status = "on"
+	}
+	for i := 3; i < 16; i++ {
+		f.channels[i].status = "off"
+	}
+
+	var answer string
+
+	serialWrite = func(s string) error {
+		t.Logf("String written to serial: %v", s)
+		answer = f.handle(s)
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		b := []byte(answer + "\r\n")
+		return len(b), b
+	}
+
+	return f
+}
+
+func (f *fakeChannels) handle(s string) string {
+	args := strings.Fields(s)
+	if len(args) < 5 || args[1] != "get" && args[1] != "set" {
+		return invalidParameter
+	}
+
+	id, _ := strconv.Atoi(args[4])
+	ch := &f.channels[id]
+
+	if args[1] == "get" {
+		switch args[3] {
+		case "freq":
+			return fmt.Sprint(ch.frequency)
+		case "drrange":
+			return fmt.Sprintf("%v %v", ch.min, ch.max)
+		case "dcycle":
+			return fmt.Sprint(ch.dcycle)
+		case "status":
+			return ch.status
+		}
+		return invalidParameter
+	}
+
+	if f.fail != "" && strings.HasPrefix(s, f.fail) {
+		return invalidParameter
+	}
+	f.written = append(f.written, s)
+
+	switch args[3] {
+	case "freq":
+		ch.frequency, _ = strconv.ParseUint(args[5], 10, 32)
+	case "drrange":
+		ch.min, _ = strconv.ParseUint(args[5], 10, 8)
+		ch.max, _ = strconv.ParseUint(args[6], 10, 8)
+	case "dcycle":
+		ch.dcycle, _ = strconv.ParseUint(args[5], 10, 16)
+	case "status":
+		ch.status = args[5]
+	}
+
+	return "ok"
+}
+
+func TestReadChannelPlan(t *testing.T) {
+	defer resetOriginals()
+	newFakeChannels(t)
+
+	plan, err := ReadChannelPlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Channels) != 16 {
+		t.Fatalf("%v channels read; should be 16", len(plan.Channels))
+	}
+
+	expected := Channel{ID: 1, Frequency: 868300000, MaxDataRate: 5, DutyCycle: 1, Enabled: true}
+	if plan.Channels[1] != expected {
+		t.Errorf("channel 1 = %+v; should be %+v", plan.Channels[1], expected)
+	}
+	if plan.Channels[3].Enabled {
+		t.Error("channel 3 should be disabled")
+	}
+}
+
+func TestChannelPlanApplyOnlyChanges(t *testing.T) {
+	defer resetOriginals()
+	f := newFakeChannels(t)
+
+	plan := &ChannelPlan{Channels: []Channel{
+		{ID: 0, Frequency: 868100000, MaxDataRate: 5, DutyCycle: 1, Enabled: true},
+		{ID: 3, Frequency: 867100000, MaxDataRate: 5, DutyCycle: 1, Enabled: true},
+	}}
+
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"mac set ch freq 3 867100000",
+		"mac set ch drrange 3 0 5",
+		"mac set ch dcycle 3 99",
+		"mac set ch status 3 on",
+	}
+	if fmt.Sprint(f.written) != fmt.Sprint(expected) {
+		t.Errorf("commands = %v; should be %v", f.written, expected)
+	}
+
+	f.written = nil
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.written) != 0 {
+		t.Errorf("commands = %v; should be none for an applied plan", f.written)
+	}
+}
+
+func TestChannelPlanApplyRollback(t *testing.T) {
+	defer resetOriginals()
+	f := newFakeChannels(t)
+	f.fail = "mac set ch status 4"
+
+	plan := &ChannelPlan{Channels: []Channel{
+		{ID: 3, Frequency: 867100000, MaxDataRate: 5, Enabled: true},
+		{ID: 4, Frequency: 867300000, MaxDataRate: 5, Enabled: true},
+	}}
+
+	if err := plan.Apply(); err == nil {
+		t.Fatal("Apply() succeeded while a command failed")
+	}
+
+	for _, id := range []int{3, 4} {
+		ch := f.channels[id]
+		if ch.max != 0 || ch.status != "off" {
+			t.Errorf("channel %v = %+v; should be rolled back", id, ch)
+		}
+	}
+}
+
+func TestChannelPlanValidate(t *testing.T) {
+	tests := []Channel{
+		{ID: 16, Frequency: 867100000, MaxDataRate: 5},
+		{ID: 3, Frequency: 915000000, MaxDataRate: 5},
+		{ID: 3, Frequency: 867100000, MinDataRate: 5, MaxDataRate: 0},
+		{ID: 3, Frequency: 867100000, MaxDataRate: 9},
+		{ID: 3, Frequency: 867100000, MaxDataRate: 5, DutyCycle: 120},
+	}
+
+	for _, ch := range tests {
+		plan := &ChannelPlan{Channels: []Channel{ch}}
+		if err := plan.Validate(); err == nil {
+			t.Errorf("Validate() accepted %+v", ch)
+		}
+	}
+
+	plan := &ChannelPlan{Channels: []Channel{{ID: 3}, {ID: 3}}}
+	if err := plan.Validate(); err == nil {
+		t.Error("Validate() accepted duplicate channels")
+	}
+}
+
+func TestChannelPlanDefaultChannel(t *testing.T) {
+	defer resetOriginals()
+	f := newFakeChannels(t)
+
+	plan := &ChannelPlan{Channels: []Channel{{ID: 1, Frequency: 867100000, MaxDataRate: 5, Enabled: true}}}
+	if err := plan.Apply(); err == nil {
+		t.Error("Apply() changed the frequency of a default channel")
+	}
+	if len(f.written) != 0 {
+		t.Errorf("commands = %v; should be none", f.written)
+	}
+}
+
+func TestLoadChannelPlanExtraChannels(t *testing.T) {
+	data := `{"extra_channels": [
+		{"frequency": 867100000, "min_dr": 0, "max_dr": 5},
+		{"frequency": 867300000, "min_dr": 0, "max_dr": 5}
+	]}`
+
+	plan, err := LoadChannelPlan(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Channels) != 13 {
+		t.Fatalf("%v channels; should be 13", len(plan.Channels))
+	}
+
+	expected := Channel{ID: 4, Frequency: 867300000, MaxDataRate: 5, Enabled: true}
+	if plan.Channels[1] != expected {
+		t.Errorf("channel = %+v; should be %+v", plan.Channels[1], expected)
+	}
+	if plan.Channels[2].Enabled || plan.Channels[2].ID != 5 {
+		t.Errorf("channel = %+v; should be channel 5 disabled", plan.Channels[2])
+	}
+
+	if err := plan.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadChannelPlan(t *testing.T) {
+	data := `{"channels": [{"id": 3, "frequency": 867100000, "min_dr": 0, "max_dr": 5, "duty_cycle": 1, "enabled": true}]}`
+
+	plan, err := LoadChannelPlan(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Channel{ID: 3, Frequency: 867100000, MaxDataRate: 5, DutyCycle: 1, Enabled: true}
+	if len(plan.Channels) != 1 || plan.Channels[0] != expected {
+		t.Errorf("plan = %+v; should hold %+v", plan, expected)
+	}
+
+	if _, err := LoadChannelPlan(strings.NewReader("{")); err == nil {
+		t.Error("LoadChannelPlan accepted invalid JSON")
+	}
+}
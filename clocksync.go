@@ -0,0 +1,289 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ClockSyncPort is the FPort of the LoRaWAN Application Layer Clock
+// Synchronization package (TS003).
+const ClockSyncPort = uint8(202)
+
+// DefaultClockSyncPeriod is the time between two synchronizations, until the
+// network server configures a periodicity.
+const DefaultClockSyncPeriod = 24 * time.Hour
+
+// The clock synchronization commands
+const (
+	clockSyncPackageVersion = byte(0x00)
+	clockSyncAppTime        = byte(0x01)
+	clockSyncPeriodicity    = byte(0x02)
+	clockSyncForceResync    = byte(0x03)
+)
+
+// The clock synchronization package identifier and version
+const (
+	clockSyncPackageID       = byte(1)
+	clockSyncPackageVersion1 = byte(1)
+)
+
+// The time between two requests of a forced resynchronization
+var clockSyncResyncInterval = time.Minute
+
+// gpsEpoch is the start of GPS time, used by the LoRaWAN time commands.
+// GPS time does not count leap seconds, which puts it 18 seconds ahead of UTC.
+var (
+	gpsEpoch       = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+	gpsLeapSeconds = 18 * time.Second
+)
+
+// ClockSync keeps a corrected wall clock using the LoRaWAN Application Layer
+// Clock Synchronization package. The RN2483 doesn't support DeviceTimeReq,
+// so the time is requested from the application server on port 202.
+type ClockSync struct {
+	mu       sync.Mutex
+	offset   time.Duration
+	synced   bool
+	lastSync time.Time
+	token    uint8
+	period   time.Duration
+	resync   int
+	wake     chan struct{}
+
+	now  func() time.Time
+	send func(port uint8, data []byte) error
+}
+
+// NewClockSync returns a clock synchronizer that sends its requests as
+// unconfirmed uplinks and receives its answers through the router.
+func NewClockSync(router *Router) *ClockSync {
+	c := newClockSync(func(port uint8, data []byte) error {
+		if !MacTxRouted(false, port, data, router) {
+			return errors.New("could not transmit clock sync uplink")
+		}
+		return nil
+	})
+
+	if router != nil {
+		router.Handle(ClockSyncPort, c.HandleDownlink)
+	}
+
+	return c
+}
+
+func newClockSync(send func(port uint8, data []byte) error) *ClockSync {
+	return &ClockSync{
+		period: DefaultClockSyncPeriod,
+		wake:   make(chan struct{}, 1),
+		now:    time.Now,
+		send:   send,
+	}
+}
+
+// Now returns the corrected wall clock time.
+func (c *ClockSync) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now().Add(c.offset)
+}
+
+// Synced returns whether the clock has been corrected by the server and
+// when that last happened.
+func (c *ClockSync) Synced() (bool, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.synced, c.lastSync
+}
+
+// Period returns the time between two periodic synchronizations.
+func (c *ClockSync) Period() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.period
+}
+
+// Request sends an AppTimeReq with the current device time. If ansRequired
+// is set, the server has to answer even if no correction is needed.
+func (c *ClockSync) Request(ansRequired bool) error {
+	c.mu.Lock()
+	param := c.token & 0x0F
+	if ansRequired {
+		param |= 0x10
+	}
+	req := append([]byte{clockSyncAppTime}, c.gpsTimeLocked()...)
+	req = append(req, param)
+	c.mu.Unlock()
+
+	return c.send(ClockSyncPort, req)
+}
+
+// HandleDownlink processes the clock synchronization commands in the downlink.
+// It can be registered on a router for port 202.
+func (c *ClockSync) HandleDownlink(d Downlink) {
+	var answers []byte
+	var err error
+
+	data := d.Data
+	for len(data) > 0 && err == nil {
+		cmd := data[0]
+		data = data[1:]
+
+		switch cmd {
+		case clockSyncPackageVersion:
+			answers = append(answers, clockSyncPackageVersion, clockSyncPackageID, clockSyncPackageVersion1)
+		case clockSyncAppTime:
+			if len(data) < 5 {
+				err = errors.New("AppTimeAns too short")
+				break
+			}
+			c.handleAppTimeAns(int32(binary.LittleEndian.Uint32(data[:4])), data[4]&0x0F)
+			data = data[5:]
+		case clockSyncPeriodicity:
+			if len(data) < 1 {
+				err = errors.New("DeviceAppTimePeriodicityReq too short")
+				break
+			}
+			c.mu.Lock()
+			c.period = time.Duration(128<<(data[0]&0x0F)) * time.Second
+			answers = append(answers, clockSyncPeriodicity, 0x00)
+			answers = append(answers, c.gpsTimeLocked()...)
+			c.mu.Unlock()
+			c.notify()
+			data = data[1:]
+		case clockSyncForceResync:
+			if len(data) < 1 {
+				err = errors.New("ForceDeviceResyncReq too short")
+				break
+			}
+			c.mu.Lock()
+			c.resync = int(data[0] & 0x07)
+			c.mu.Unlock()
+			c.notify()
+			data = data[1:]
+		default:
+			err = errors.Errorf("unknown command 0x%02X", cmd)
+		}
+	}
+
+	if err != nil {
+		WARN.Println("clock sync error:", err)
+	}
+
+	if len(answers) > 0 {
+		if err := c.send(ClockSyncPort, answers); err != nil {
+			WARN.Println("clock sync error:", err)
+		}
+	}
+}
+
+func (c *ClockSync) handleAppTimeAns(correction int32, token uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token != c.token {
+		WARN.Printf("clock sync: ignoring AppTimeAns with token %v (expected %v)", token, c.token)
+		return
+	}
+
+	c.offset += time.Duration(correction) * time.Second
+	c.synced = true
+	c.lastSync = c.now()
+	c.token = (c.token + 1) % 16
+	c.resync = 0
+
+	DEBUG.Printf("clock sync: corrected clock by %vs", correction)
+}
+
+// Run synchronizes the clock periodically and on request of the server,
+// until the stop channel is closed. A clock that isn't synced yet is
+// synchronized right away.
+func (c *ClockSync) Run(stop <-chan struct{}) {
+	next := time.Duration(0)
+
+	c.mu.Lock()
+	if c.synced {
+		next = c.period - c.now().Sub(c.lastSync)
+		if next < 0 {
+			next = 0
+		}
+	}
+	c.mu.Unlock()
+
+	for {
+		woke := false
+
+		select {
+		case <-stop:
+			return
+		case <-c.wake:
+			woke = true
+		case <-time.After(next):
+			synced, _ := c.Synced()
+			if err := c.Request(!synced); err != nil {
+				WARN.Println("clock sync error:", err)
+			}
+
+			c.mu.Lock()
+			if c.resync > 0 {
+				c.resync--
+			}
+			c.mu.Unlock()
+		}
+
+		c.mu.Lock()
+		switch {
+		case c.resync > 0 && woke:
+			next = 0
+		case c.resync > 0:
+			next = clockSyncResyncInterval
+		default:
+			next = c.period
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *ClockSync) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// gpsTimeLocked returns the corrected time as little endian GPS seconds.
+func (c *ClockSync) gpsTimeLocked() []byte {
+	t := c.now().Add(c.offset).Sub(gpsEpoch) + gpsLeapSeconds
+
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(t/time.Second))
+
+	return b
+}
@@ -0,0 +1,180 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClockServer answers AppTimeReq uplinks like an application server
+// whose clock is ahead of the device by the given skew. A silent server
+// doesn't answer.
+type fakeClockServer struct {
+	mu       sync.Mutex
+	t        *testing.T
+	c        *ClockSync
+	skew     time.Duration
+	silent   bool
+	requests int
+	uplinks  [][]byte
+}
+
+func (s *fakeClockServer) send(port uint8, data []byte) error {
+	if port != ClockSyncPort {
+		s.t.Errorf("Clock sync uplink on port %v; should be %v", port, ClockSyncPort)
+	}
+
+	s.mu.Lock()
+	s.uplinks = append(s.uplinks, data)
+	if data[0] == clockSyncAppTime {
+		s.requests++
+	}
+	silent := s.silent
+	s.mu.Unlock()
+
+	if data[0] != clockSyncAppTime || silent {
+		return nil
+	}
+
+	deviceTime := int64(binary.LittleEndian.Uint32(data[1:5]))
+	serverTime := int64((time.Now().Add(s.skew).Sub(gpsEpoch) + gpsLeapSeconds) / time.Second)
+
+	ans := make([]byte, 6)
+	ans[0] = clockSyncAppTime
+	binary.LittleEndian.PutUint32(ans[1:5], uint32(int32(serverTime-deviceTime)))
+	ans[5] = data[5] & 0x0F
+
+	s.c.HandleDownlink(Downlink{Port: ClockSyncPort, Data: ans})
+
+	return nil
+}
+
+func newTestClockSync(t *testing.T, skew time.Duration) (*ClockSync, *fakeClockServer) {
+	server := &fakeClockServer{t: t, skew: skew}
+	server.c = newClockSync(server.send)
+	return server.c, server
+}
+
+func TestClockSyncRequest(t *testing.T) {
+	c, _ := newTestClockSync(t, time.Hour)
+
+	if synced, _ := c.Synced(); synced {
+		t.Error("Synced() returned true before any synchronization")
+	}
+
+	if err := c.Request(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if synced, _ := c.Synced(); !synced {
+		t.Error("Synced() returned false after AppTimeAns")
+	}
+
+	diff := c.Now().Sub(time.Now().Add(time.Hour))
+	if diff < -time.Second || diff > time.Second {
+		t.Errorf("Now() is off by %v after synchronization", diff)
+	}
+
+	if c.token != 1 {
+		t.Errorf("token = %v after one synchronization; should be 1", c.token)
+	}
+}
+
+func TestClockSyncWrongToken(t *testing.T) {
+	c, _ := newTestClockSync(t, 0)
+
+	c.HandleDownlink(Downlink{Port: ClockSyncPort, Data: []byte{clockSyncAppTime, 10, 0, 0, 0, 5}})
+
+	if synced, _ := c.Synced(); synced {
+		t.Error("Synced() returned true after AppTimeAns with the wrong token")
+	}
+}
+
+func TestClockSyncPackageVersionAndPeriodicity(t *testing.T) {
+	c, server := newTestClockSync(t, 0)
+
+	c.HandleDownlink(Downlink{Port: ClockSyncPort, Data: []byte{clockSyncPackageVersion, clockSyncPeriodicity, 0x02}})
+
+	if c.Period() != 512*time.Second {
+		t.Errorf("Period() = %v; should be %v", c.Period(), 512*time.Second)
+	}
+
+	if len(server.uplinks) != 1 {
+		t.Fatalf("HandleDownlink() sent %v uplinks; should be 1", len(server.uplinks))
+	}
+
+	ans := server.uplinks[0]
+	if len(ans) != 9 || ans[0] != clockSyncPackageVersion || ans[1] != 1 || ans[2] != 1 || ans[3] != clockSyncPeriodicity || ans[4] != 0 {
+		t.Errorf("HandleDownlink() answered % X", ans)
+	}
+}
+
+func TestClockSyncForceResync(t *testing.T) {
+	c, server := newTestClockSync(t, 0)
+	server.silent = true
+
+	// a synced clock waits for the period before its next request
+	c.synced = true
+	c.lastSync = c.now()
+
+	clockSyncResyncInterval = time.Millisecond
+	defer func() { clockSyncResyncInterval = time.Minute }()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		c.Run(stop)
+		close(done)
+	}()
+
+	c.HandleDownlink(Downlink{Port: ClockSyncPort, Data: []byte{clockSyncForceResync, 0x03}})
+
+	timeout := time.After(time.Second)
+	for server.requestCount() < 3 {
+		select {
+		case <-timeout:
+			t.Fatalf("%v AppTimeReq sent after the forced resynchronization; should be 3", server.requestCount())
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// give the clock sync the time for more requests, there shouldn't be any
+	time.Sleep(20 * clockSyncResyncInterval)
+
+	close(stop)
+	<-done
+
+	if n := server.requestCount(); n != 3 {
+		t.Errorf("%v AppTimeReq sent after the forced resynchronization; should be 3", n)
+	}
+}
+
+func (s *fakeClockServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
@@ -0,0 +1,90 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Command rn2483-cw transmits an unmodulated carrier with the module for RF
+// certification. The carrier is switched off after the duration, or earlier
+// on an interrupt (Ctrl-C) or termination signal.
+//
+//	rn2483-cw -port /dev/ttyUSB0 -freq 868100000 -power 14 -duration 5m
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bullettime/rn2483"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	port := flag.String("port", "/dev/ttyUSB0", "serial device of the module")
+	baud := flag.Int("baud", 57600, "baud rate of the serial device")
+	freq := flag.Uint("freq", 868100000, "carrier frequency in Hz")
+	power := flag.Int("power", 14, "output power in dBm")
+	duration := flag.Duration("duration", time.Minute, "time the carrier stays on")
+	verbose := flag.Bool("v", false, "log the serial communication")
+	flag.Parse()
+
+	if *freq > 1<<32-1 || *power < -128 || *power > 127 {
+		fmt.Fprintln(os.Stderr, "frequency or power out of range")
+		os.Exit(2)
+	}
+
+	logger := log.New(os.Stderr, "rn2483: ", log.LstdFlags)
+	rn2483.ERROR = logger
+	rn2483.WARN = logger
+	if *verbose {
+		rn2483.DEBUG = logger
+	}
+
+	rn2483.SetName(*port)
+	rn2483.SetBaud(*baud)
+	rn2483.SetTimeout(100 * time.Millisecond)
+	rn2483.Connect()
+
+	model, err := rn2483.DetectModule()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not detect module:", err)
+		rn2483.Disconnect()
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v: carrier on at %v Hz, %v dBm for %v\n", model, *freq, *power, *duration)
+
+	// RadioCW switches the carrier off on a signal before it raises it again
+	err = rn2483.RadioCW(context.Background(), uint32(*freq), int8(*power), *duration)
+	rn2483.Disconnect()
+
+	if err != nil && errors.Cause(err) != rn2483.ErrCWInterrupted {
+		fmt.Fprintln(os.Stderr, "cw error:", err)
+	} else {
+		fmt.Println("carrier off")
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,545 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The struct tag used by MarshalPayload and UnmarshalPayload. Its value is a
+// comma separated list of options:
+//
+//	bits=N   the width of the field in bits (defaults to the size of the type,
+//	         1 for booleans, required for floats)
+//	scale=F  the resolution of a float field: raw = value / F
+//	signed   store the value as two's complement (default for int and float)
+//	unsigned store the value without sign (default for uint)
+//	little   store the value little endian (only for multiples of 8 bits)
+//	optional the field is a pointer, preceded by a 1 bit presence flag
+//
+// A tag value of "-" skips the field. Nested structs and arrays are packed
+// in place. Fields are packed most significant bit first without padding,
+// only the last byte is padded with zeros.
+const payloadTag = "payload"
+
+type payloadField struct {
+	name     string
+	bits     uint
+	scale    float64
+	signed   bool
+	little   bool
+	optional bool
+}
+
+// MarshalPayload packs the given struct (or pointer to struct) into a compact
+// binary payload, ready to be passed to MacTx or RadioTx.
+func MarshalPayload(v interface{}) ([]byte, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("payload: can only marshal structs")
+	}
+
+	w := &bitWriter{}
+	if err := encodeStruct(w, rv); err != nil {
+		return nil, err
+	}
+
+	return w.buf, nil
+}
+
+// UnmarshalPayload unpacks the binary payload, for example received in a
+// downlink, into the struct pointed to by v.
+func UnmarshalPayload(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("payload: can only unmarshal into a pointer to a struct")
+	}
+
+	r := &bitReader{buf: data}
+
+	return decodeStruct(r, rv.Elem())
+}
+
+// PayloadFormatter generates a JavaScript decodeUplink function for the
+// network server, which decodes payloads packed from the given struct type.
+func PayloadFormatter(v interface{}) (string, error) {
+	rt := reflect.TypeOf(v)
+	if rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return "", errors.New("payload: can only generate a formatter for structs")
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString("function decodeUplink(input) {\n")
+	b.WriteString("  var bytes = input.bytes;\n")
+	b.WriteString("  var pos = 0;\n")
+	b.WriteString("  function read(bits, signed, little) {\n")
+	b.WriteString("    var v = 0;\n")
+	b.WriteString("    for (var i = 0; i < bits; i++, pos++) {\n")
+	b.WriteString("      v = v * 2 + ((bytes[pos >> 3] >> (7 - (pos & 7))) & 1);\n")
+	b.WriteString("    }\n")
+	b.WriteString("    if (little) {\n")
+	b.WriteString("      var r = 0;\n")
+	b.WriteString("      for (var j = 0; j < bits / 8; j++) {\n")
+	b.WriteString("        r = r * 256 + (Math.floor(v / Math.pow(2, 8 * j)) % 256);\n")
+	b.WriteString("      }\n")
+	b.WriteString("      v = r;\n")
+	b.WriteString("    }\n")
+	b.WriteString("    if (signed && v >= Math.pow(2, bits - 1)) {\n")
+	b.WriteString("      v -= Math.pow(2, bits);\n")
+	b.WriteString("    }\n")
+	b.WriteString("    return v;\n")
+	b.WriteString("  }\n")
+	b.WriteString("  var data = {};\n")
+
+	if err := formatStruct(&b, rt, "data", "  "); err != nil {
+		return "", err
+	}
+
+	b.WriteString("  return { data: data };\n")
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+func parsePayloadTag(f reflect.StructField) (payloadField, bool, error) {
+	pf := payloadField{name: f.Name, scale: 1}
+
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		pf.name = name
+	}
+
+	tag := f.Tag.Get(payloadTag)
+	if tag == "-" {
+		return pf, false, nil
+	}
+
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		pf.bits = 1
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		pf.bits = uint(t.Bits())
+		pf.signed = true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		pf.bits = uint(t.Bits())
+	case reflect.Float32, reflect.Float64:
+		pf.signed = true
+	case reflect.Struct:
+	default:
+		return pf, false, errors.Errorf("payload: unsupported type %v for field %s", f.Type, f.Name)
+	}
+
+	for _, opt := range strings.Split(tag, ",") {
+		opt = strings.TrimSpace(opt)
+
+		switch {
+		case opt == "":
+		case opt == "signed":
+			pf.signed = true
+		case opt == "unsigned":
+			pf.signed = false
+		case opt == "little":
+			pf.little = true
+		case opt == "big":
+			pf.little = false
+		case opt == "optional":
+			pf.optional = true
+		case strings.HasPrefix(opt, "bits="):
+			bits, err := strconv.ParseUint(opt[5:], 10, 8)
+			if err != nil || bits == 0 || bits > 64 {
+				return pf, false, errors.Errorf("payload: invalid bits for field %s", f.Name)
+			}
+			pf.bits = uint(bits)
+		case strings.HasPrefix(opt, "scale="):
+			scale, err := strconv.ParseFloat(opt[6:], 64)
+			if err != nil || scale <= 0 {
+				return pf, false, errors.Errorf("payload: invalid scale for field %s", f.Name)
+			}
+			pf.scale = scale
+		default:
+			return pf, false, errors.Errorf("payload: unknown option %q for field %s", opt, f.Name)
+		}
+	}
+
+	if pf.optional && f.Type.Kind() != reflect.Ptr {
+		return pf, false, errors.Errorf("payload: optional field %s has to be a pointer", f.Name)
+	}
+
+	if !pf.optional && f.Type.Kind() == reflect.Ptr {
+		return pf, false, errors.Errorf("payload: pointer field %s has to be optional", f.Name)
+	}
+
+	if t.Kind() != reflect.Struct && pf.bits == 0 {
+		return pf, false, errors.Errorf("payload: field %s needs a bit width", f.Name)
+	}
+
+	if pf.little && pf.bits%8 != 0 {
+		return pf, false, errors.Errorf("payload: little endian field %s needs a multiple of 8 bits", f.Name)
+	}
+
+	return pf, true, nil
+}
+
+func encodeStruct(w *bitWriter, v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+
+		pf, ok, err := parsePayloadTag(t.Field(i))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err := encodeValue(w, v.Field(i), pf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func encodeValue(w *bitWriter, v reflect.Value, pf payloadField) error {
+	if pf.optional {
+		if v.IsNil() {
+			w.write(0, 1)
+			return nil
+		}
+
+		w.write(1, 1)
+		v = v.Elem()
+		pf.optional = false
+	}
+
+	var raw int64
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return encodeStruct(w, v)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(w, v.Index(i), pf); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Bool:
+		if v.Bool() {
+			raw = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		raw = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := v.Uint()
+		if !pf.signed {
+			if pf.bits < 64 && u >= 1<<pf.bits {
+				return errors.Errorf("payload: field %s value %v does not fit in %v bits", pf.name, u, pf.bits)
+			}
+			w.writeField(u, pf)
+			return nil
+		}
+		if u > uint64(maxInt64) {
+			return errors.Errorf("payload: field %s value %v out of range", pf.name, u)
+		}
+		raw = int64(u)
+	case reflect.Float32, reflect.Float64:
+		f := round(v.Float() / pf.scale)
+		if f < -9.2e18 || f > 9.2e18 {
+			return errors.Errorf("payload: field %s value %v out of range", pf.name, v.Float())
+		}
+		raw = int64(f)
+	}
+
+	if !fitsBits(raw, pf.bits, pf.signed) {
+		return errors.Errorf("payload: field %s value %v does not fit in %v bits", pf.name, v.Interface(), pf.bits)
+	}
+
+	w.writeField(uint64(raw), pf)
+
+	return nil
+}
+
+func decodeStruct(r *bitReader, v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+
+		pf, ok, err := parsePayloadTag(t.Field(i))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err := decodeValue(r, v.Field(i), pf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeValue(r *bitReader, v reflect.Value, pf payloadField) error {
+	if pf.optional {
+		present, err := r.read(1)
+		if err != nil {
+			return errors.Wrapf(err, "payload: field %s", pf.name)
+		}
+
+		if present == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+		pf.optional = false
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return decodeStruct(r, v)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := decodeValue(r, v.Index(i), pf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	u, err := r.readField(pf)
+	if err != nil {
+		return errors.Wrapf(err, "payload: field %s", pf.name)
+	}
+
+	raw := int64(u)
+	if pf.signed && pf.bits < 64 && u&(1<<(pf.bits-1)) != 0 {
+		raw -= 1 << pf.bits
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(u != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(raw) {
+			return errors.Errorf("payload: field %s value %v overflows %v", pf.name, raw, v.Type())
+		}
+		v.SetInt(raw)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if pf.signed {
+			if raw < 0 {
+				return errors.Errorf("payload: field %s value %v overflows %v", pf.name, raw, v.Type())
+			}
+			u = uint64(raw)
+		}
+		if v.OverflowUint(u) {
+			return errors.Errorf("payload: field %s value %v overflows %v", pf.name, u, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		if pf.signed {
+			v.SetFloat(float64(raw) * pf.scale)
+		} else {
+			v.SetFloat(float64(u) * pf.scale)
+		}
+	}
+
+	return nil
+}
+
+func formatStruct(b *bytes.Buffer, t reflect.Type, path string, indent string) error {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+
+		pf, ok, err := parsePayloadTag(t.Field(i))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		ft := t.Field(i).Type
+		target := fmt.Sprintf("%s[%q]", path, pf.name)
+
+		if pf.optional {
+			fmt.Fprintf(b, "%sif (read(1, false, false)) {\n", indent)
+			if err := formatValue(b, ft.Elem(), target, pf, indent+"  ", 0); err != nil {
+				return err
+			}
+			fmt.Fprintf(b, "%s}\n", indent)
+			continue
+		}
+
+		if err := formatValue(b, ft, target, pf, indent, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatValue(b *bytes.Buffer, t reflect.Type, target string, pf payloadField, indent string, depth int) error {
+	switch t.Kind() {
+	case reflect.Struct:
+		fmt.Fprintf(b, "%s%s = {};\n", indent, target)
+		return formatStruct(b, t, target, indent)
+	case reflect.Array:
+		i := fmt.Sprintf("i%d", depth)
+		fmt.Fprintf(b, "%s%s = [];\n", indent, target)
+		fmt.Fprintf(b, "%sfor (var %s = 0; %s < %d; %s++) {\n", indent, i, i, t.Len(), i)
+		if err := formatValue(b, t.Elem(), fmt.Sprintf("%s[%s]", target, i), pf, indent+"  ", depth+1); err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s}\n", indent)
+		return nil
+	}
+
+	expr := fmt.Sprintf("read(%d, %t, %t)", pf.bits, pf.signed, pf.little)
+
+	switch t.Kind() {
+	case reflect.Bool:
+		expr += " === 1"
+	case reflect.Float32, reflect.Float64:
+		expr += fmt.Sprintf(" * %s", strconv.FormatFloat(pf.scale, 'g', -1, 64))
+	}
+
+	fmt.Fprintf(b, "%s%s = %s;\n", indent, target, expr)
+
+	return nil
+}
+
+func fitsBits(raw int64, bits uint, signed bool) bool {
+	if bits >= 64 {
+		return signed || raw >= 0
+	}
+
+	if signed {
+		return raw >= -(1<<(bits-1)) && raw < 1<<(bits-1)
+	}
+
+	return raw >= 0 && raw < 1<<bits
+}
+
+// bitWriter packs values most significant bit first.
+type bitWriter struct {
+	buf []byte
+	pos uint
+}
+
+func (w *bitWriter) write(value uint64, bits uint) {
+	for i := bits; i > 0; i-- {
+		if w.pos%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+
+		if value&(1<<(i-1)) != 0 {
+			w.buf[len(w.buf)-1] |= 1 << (7 - w.pos%8)
+		}
+
+		w.pos++
+	}
+}
+
+func (w *bitWriter) writeField(value uint64, pf payloadField) {
+	if pf.little {
+		value = swapBytes(value, pf.bits/8)
+	}
+
+	w.write(value, pf.bits)
+}
+
+// bitReader unpacks values most significant bit first.
+type bitReader struct {
+	buf []byte
+	pos uint
+}
+
+func (r *bitReader) read(bits uint) (uint64, error) {
+	if r.pos+bits > uint(len(r.buf))*8 {
+		return 0, errors.New("payload too short")
+	}
+
+	var value uint64
+	for i := uint(0); i < bits; i++ {
+		bit := (r.buf[r.pos/8] >> (7 - r.pos%8)) & 1
+		value = value<<1 | uint64(bit)
+		r.pos++
+	}
+
+	return value, nil
+}
+
+func (r *bitReader) readField(pf payloadField) (uint64, error) {
+	value, err := r.read(pf.bits)
+	if err != nil {
+		return 0, err
+	}
+
+	if pf.little {
+		value = swapBytes(value, pf.bits/8)
+	}
+
+	return value, nil
+}
+
+func swapBytes(value uint64, n uint) uint64 {
+	var swapped uint64
+	for i := uint(0); i < n; i++ {
+		swapped = swapped<<8 | (value>>(8*i))&0xFF
+	}
+	return swapped
+}
@@ -0,0 +1,183 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+type testPosition struct {
+	X int8
+	Y int8
+}
+
+type testReading struct {
+	Temperature float32 `payload:"bits=12,scale=0.1" json:"temperature"`
+	Humidity    uint8   `payload:"bits=7"`
+	Alarm       bool
+	Counter     uint16 `payload:"little"`
+	Battery     *uint8 `payload:"optional,bits=4"`
+	Position    testPosition
+	Flags       [2]bool
+	Skipped     int `payload:"-"`
+	ignored     int
+}
+
+func TestMarshalPayloadBits(t *testing.T) {
+	v := struct {
+		A uint8 `payload:"bits=4"`
+		B bool
+		C int8   `payload:"bits=3"`
+		D uint16 `payload:"little"`
+	}{0xA, true, -1, 0x1234}
+
+	b, err := MarshalPayload(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{0xAF, 0x34, 0x12}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("MarshalPayload() = %X; should be %X", b, expected)
+	}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	battery := uint8(9)
+	in := testReading{
+		Temperature: -12.3,
+		Humidity:    85,
+		Alarm:       true,
+		Counter:     513,
+		Battery:     &battery,
+		Position:    testPosition{X: -5, Y: 100},
+		Flags:       [2]bool{false, true},
+		Skipped:     42,
+	}
+
+	b, err := MarshalPayload(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 12 + 7 + 1 + 16 + 1 + 4 + 16 + 2 bits
+	if len(b) != 8 {
+		t.Errorf("MarshalPayload() returned %v bytes; should be 8", len(b))
+	}
+
+	var out testReading
+	if err := UnmarshalPayload(b, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(float64(out.Temperature-in.Temperature)) > 0.05 {
+		t.Errorf("Temperature = %v; should be %v", out.Temperature, in.Temperature)
+	}
+
+	if out.Battery == nil || *out.Battery != battery {
+		t.Errorf("Battery = %v; should be %v", out.Battery, battery)
+	}
+
+	out.Temperature, in.Temperature = 0, 0
+	out.Battery, in.Battery = nil, nil
+	in.Skipped = 0
+
+	if out != in {
+		t.Errorf("UnmarshalPayload() = %+v; should be %+v", out, in)
+	}
+}
+
+func TestPayloadOptionalAbsent(t *testing.T) {
+	v := struct {
+		A *uint16 `payload:"optional"`
+		B uint8
+	}{nil, 0xFF}
+
+	b, err := MarshalPayload(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, []byte{0x7F, 0x80}) {
+		t.Errorf("MarshalPayload() = %X; should be 7F80", b)
+	}
+}
+
+func TestMarshalPayloadOutOfRange(t *testing.T) {
+	v := struct {
+		A uint8 `payload:"bits=3"`
+	}{8}
+
+	if _, err := MarshalPayload(v); err == nil {
+		t.Error("MarshalPayload() returned no error while the value doesn't fit")
+	}
+
+	w := struct {
+		A float64
+	}{1}
+
+	if _, err := MarshalPayload(w); err == nil {
+		t.Error("MarshalPayload() returned no error for a float without bit width")
+	}
+}
+
+func TestUnmarshalPayloadTooShort(t *testing.T) {
+	var v testReading
+
+	if err := UnmarshalPayload([]byte{0x01, 0x02}, &v); err == nil {
+		t.Error("UnmarshalPayload() returned no error with a short payload")
+	}
+
+	if err := UnmarshalPayload([]byte{0x01}, v); err == nil {
+		t.Error("UnmarshalPayload() returned no error with a non pointer")
+	}
+}
+
+func TestPayloadFormatter(t *testing.T) {
+	js, err := PayloadFormatter(testReading{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"function decodeUplink(input)",
+		`data["temperature"] = read(12, true, false) * 0.1;`,
+		`data["Counter"] = read(16, false, true);`,
+		`if (read(1, false, false)) {`,
+		`data["Position"]["X"] = read(8, true, false);`,
+		`data["Flags"][i0] = read(1, false, false) === 1;`,
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(js, e) {
+			t.Errorf("PayloadFormatter() doesn't contain %q:\n%s", e, js)
+		}
+	}
+
+	if strings.Contains(js, "Skipped") {
+		t.Error("PayloadFormatter() contains a skipped field")
+	}
+}
@@ -22,19 +22,33 @@
 
 package rn2483
 
-//type myState struct {
-//	macPaused    bool
-//	macPausedEnd time.Time
-//	joined       bool
-//}
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type myState struct {
+	macPaused     bool
+	macPausedEnd  time.Time
+	joined        bool
+	macIdle       bool // the LoRaWAN stack was reset and hasn't joined since
+	lastCommand   string
+	resetExpected time.Time // deadline for the banner of a requested reset
+	bannerWanted  bool
+}
 
 const (
 	maxUint8  = ^uint8(0)
 	maxUint16 = ^uint16(0)
 	maxUint32 = ^uint32(0)
+	maxUint64 = ^uint64(0)
 	maxInt8   = int8(maxUint8 >> 1)
 	maxInt16  = int16(maxUint16 >> 1)
 	maxInt32  = int32(maxUint32 >> 1)
+	maxInt64  = int64(maxUint64 >> 1)
 )
 
 // The possible modulations
@@ -68,12 +82,26 @@ const (
 	BW3 = "500"
 )
 
+// The possible Gaussian baseband data shaping options for FSK
+const (
+	GaussianNone = "none"
+	Gaussian10   = "1.0"
+	Gaussian05   = "0.5"
+	Gaussian03   = "0.3"
+)
+
 // The possible join modes
 const (
 	OTAA = "otaa"
 	ABP  = "abp"
 )
 
+// The possible LoRaWAN classes
+const (
+	ClassA = "a"
+	ClassC = "c"
+)
+
 // The possible uplink types
 const (
 	CONFIRMED   = "cnf"
@@ -101,11 +129,15 @@ const (
 )
 
 var (
-	//state            = new(myState)
+	state            = new(myState)
 	invalidParameter = "invalid_param"
 	serialRead       = read
 	serialWrite      = write
 	serialFlush      = flush
+	serialPort       = port
+	// serialMu serializes the commands of all goroutines, so a command and
+	// its answers are never interleaved with another one
+	serialMu sync.Mutex
 )
 
 var modulations = []string{
@@ -130,6 +162,20 @@ var BWs = map[uint16]string{
 	500: BW3,
 }
 
+var gaussianShapings = []string{
+	GaussianNone,
+	Gaussian10,
+	Gaussian05,
+	Gaussian03,
+}
+
+// FSKBandWidths are the possible FSK receive and AFC bandwidths in kHz
+var FSKBandWidths = []float64{
+	250, 125, 62.5, 31.3, 15.6, 7.8, 3.9,
+	200, 100, 50, 25, 12.5, 6.3, 3.1,
+	166.7, 83.3, 41.7, 20.8, 10.4, 5.2, 2.6,
+}
+
 // CodingRates is the mapping of the coding rates
 var CodingRates = map[uint8]string{
 	5: CR5,
@@ -146,10 +192,72 @@ func sanitize(b []byte) []byte {
 	return b
 }
 
+// lockSerial takes the serial port for a command and all of its answers.
+// In between, only serialWrite and serialRead may be used.
+func lockSerial() {
+	serialMu.Lock()
+}
+
+// unlockSerial releases the serial port and then handles the resets read
+// in the meantime, as their recovery sends commands of its own.
+func unlockSerial() {
+	resets := pendingResets
+	pendingResets = nil
+	serialMu.Unlock()
+
+	for _, e := range resets {
+		resetHook(e)
+	}
+}
+
+// exchange writes the command and reads its answer while holding the
+// serial port.
+func exchange(cmd string) (int, []byte, error) {
+	lockSerial()
+	defer unlockSerial()
+
+	if err := serialWrite(cmd); err != nil {
+		return 0, nil, err
+	}
+
+	n, answer := serialRead()
+
+	return n, answer, nil
+}
+
+// receive reads what the module sends without a command, like downlinks,
+// while holding the serial port.
+func receive() (int, []byte) {
+	lockSerial()
+	defer unlockSerial()
+
+	return serialRead()
+}
+
+// query writes the command and returns the sanitized answer of the module.
+// An empty or invalid_param answer is an error.
+func query(cmd string) (string, error) {
+	n, answer, err := exchange(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	if n == 0 {
+		return "", errors.New("no answer")
+	}
+
+	if string(sanitize(answer)) == invalidParameter {
+		return "", errors.New("invalid parameter")
+	}
+
+	return string(sanitize(answer)), nil
+}
+
 func resetOriginals() {
 	serialRead = read
 	serialWrite = write
 	serialFlush = flush
+	serialPort = port
 }
 
 func stringInList(s string, list []string) bool {
@@ -160,3 +268,11 @@ func stringInList(s string, list []string) bool {
 	}
 	return false
 }
+
+// round rounds half away from zero.
+func round(f float64) float64 {
+	if f < 0 {
+		return math.Ceil(f - 0.5)
+	}
+	return math.Floor(f + 0.5)
+}
@@ -0,0 +1,323 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DeviceConfigVersion is the version of the device configuration format.
+const DeviceConfigVersion = 2
+
+// DeviceConfig is a snapshot of everything configured on the module.
+// In a desired configuration, empty strings, nil channels and zero optional
+// radio values are left unchanged, so a golden configuration can leave out
+// the identifiers. Like a ChannelPlan, it can list only some channels, and
+// radio values its modulation doesn't use are ignored. Version 2 added the
+// preamble length and the FSK values.
+type DeviceConfig struct {
+	Version int         `json:"version"`
+	System  string      `json:"system,omitempty"`
+	Mac     MacConfig   `json:"mac"`
+	Radio   RadioConfig `json:"radio"`
+}
+
+// MacConfig is the configuration of the LoRaWAN stack.
+type MacConfig struct {
+	DeviceEUI       string    `json:"deveui,omitempty"`
+	ApplicationEUI  string    `json:"appeui,omitempty"`
+	DeviceAddress   string    `json:"devaddr,omitempty"`
+	DataRate        uint8     `json:"dr"`
+	PowerIndex      uint8     `json:"pwridx"`
+	ADR             bool      `json:"adr"`
+	RX2DataRate     uint8     `json:"rx2_dr"`
+	RX2Frequency    uint32    `json:"rx2_freq"`
+	Retransmissions uint8     `json:"retx"`
+	Channels        []Channel `json:"channels,omitempty"`
+}
+
+// ConfigDifference is a configuration value that differs from the desired one.
+type ConfigDifference struct {
+	Field   string
+	Current interface{}
+	Desired interface{}
+}
+
+func (d ConfigDifference) String() string {
+	return fmt.Sprintf("%s: %v, should be %v", d.Field, d.Current, d.Desired)
+}
+
+// configField is a configuration value with the way to write it to the module.
+// Values are compared with same, or with reflect.DeepEqual when it is nil.
+type configField struct {
+	name  string
+	value func(c *DeviceConfig) interface{}
+	apply func(c *DeviceConfig) error
+	same  func(current, desired interface{}) bool
+}
+
+// configFields are the configuration values in the order they are applied.
+var configFields = append(macConfigFields, radioConfigFields()...)
+
+// macConfigFields are the LoRaWAN stack values in the order they are applied.
+var macConfigFields = []configField{
+	{"mac.deveui", func(c *DeviceConfig) interface{} { return c.Mac.DeviceEUI },
+		func(c *DeviceConfig) error { return MacSetDeviceEUI(c.Mac.DeviceEUI) }, nil},
+	{"mac.appeui", func(c *DeviceConfig) interface{} { return c.Mac.ApplicationEUI },
+		func(c *DeviceConfig) error { return MacSetApplicationEUI(c.Mac.ApplicationEUI) }, nil},
+	{"mac.devaddr", func(c *DeviceConfig) interface{} { return c.Mac.DeviceAddress },
+		func(c *DeviceConfig) error { return MacSetDeviceAddress(c.Mac.DeviceAddress) }, nil},
+	{"mac.dr", func(c *DeviceConfig) interface{} { return c.Mac.DataRate },
+		func(c *DeviceConfig) error { return MacSetDataRate(c.Mac.DataRate) }, nil},
+	{"mac.pwridx", func(c *DeviceConfig) interface{} { return c.Mac.PowerIndex },
+		func(c *DeviceConfig) error { return MacSetPowerIndex(c.Mac.PowerIndex) }, nil},
+	{"mac.adr", func(c *DeviceConfig) interface{} { return c.Mac.ADR },
+		func(c *DeviceConfig) error { return MacSetADR(c.Mac.ADR) }, nil},
+	{"mac.rx2", func(c *DeviceConfig) interface{} { return [2]uint32{uint32(c.Mac.RX2DataRate), c.Mac.RX2Frequency} },
+		func(c *DeviceConfig) error { return MacSetRX2(c.Mac.RX2DataRate, c.Mac.RX2Frequency) }, nil},
+	{"mac.retx", func(c *DeviceConfig) interface{} { return c.Mac.Retransmissions },
+		func(c *DeviceConfig) error { return MacSetRetransmissions(c.Mac.Retransmissions) }, nil},
+	{"mac.channels", func(c *DeviceConfig) interface{} { return c.Mac.Channels },
+		func(c *DeviceConfig) error { return (&ChannelPlan{Channels: c.Mac.Channels}).Apply() },
+		func(current, desired interface{}) bool { return sameChannels(current.([]Channel), desired.([]Channel)) }},
+}
+
+// sameChannels returns whether applying the desired channels changes none of
+// the current channels. Like a ChannelPlan, channels that are not desired and
+// a zero duty cycle are left unchanged.
+func sameChannels(current, desired []Channel) bool {
+	for _, want := range desired {
+		found := false
+
+		for _, ch := range current {
+			if ch.ID != want.ID {
+				continue
+			}
+			found = true
+
+			var dcycle uint16
+			if ch.DutyCycle != 0 {
+				dcycle = uint16(round(float64(100/ch.DutyCycle)) - 1)
+			}
+
+			changes, err := channelChanges(ch, dcycle, want)
+			if err != nil || len(changes) != 0 {
+				return false
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// radioSet turns the result of a radio setter into an error.
+func radioSet(ok bool) error {
+	if !ok {
+		return errors.New("rejected by the module")
+	}
+
+	return nil
+}
+
+// ReadDeviceConfig reads the complete configuration from the module.
+func ReadDeviceConfig() (*DeviceConfig, error) {
+	c := &DeviceConfig{Version: DeviceConfigVersion}
+	var err error
+
+	var answers = []struct {
+		cmd   string
+		value interface{}
+	}{
+		{"sys get ver", &c.System},
+		{"mac get deveui", &c.Mac.DeviceEUI},
+		{"mac get appeui", &c.Mac.ApplicationEUI},
+		{"mac get devaddr", &c.Mac.DeviceAddress},
+		{"mac get dr", &c.Mac.DataRate},
+		{"mac get pwridx", &c.Mac.PowerIndex},
+		{"mac get adr", &c.Mac.ADR},
+		{"mac get retx", &c.Mac.Retransmissions},
+	}
+
+	for _, a := range answers {
+		answer, err := query(a.cmd)
+		if err != nil {
+			return nil, errors.Wrap(err, a.cmd)
+		}
+
+		if err := parseConfigValue(answer, a.value); err != nil {
+			return nil, errors.Wrap(err, a.cmd)
+		}
+	}
+
+	radio, err := ReadRadioConfig()
+	if err != nil {
+		return nil, err
+	}
+	c.Radio = *radio
+
+	c.Mac.RX2DataRate, c.Mac.RX2Frequency, err = MacGetRX2(region.Band)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := ReadChannelPlan()
+	if err != nil {
+		return nil, err
+	}
+	c.Mac.Channels = plan.Channels
+
+	return c, nil
+}
+
+// parseConfigValue parses an answer of the module into the value.
+// Spreading factors (sf7) and coding rates (4/5) are reduced to their number.
+func parseConfigValue(answer string, value interface{}) error {
+	var err error
+	var u uint64
+	var i int64
+
+	switch v := value.(type) {
+	case *string:
+		*v = answer
+	case *bool:
+		*v = answer == "on"
+	case *uint8:
+		answer = strings.TrimPrefix(answer, "sf")
+		if strings.HasPrefix(answer, "4/") {
+			answer = answer[2:]
+		}
+		u, err = strconv.ParseUint(answer, 10, 8)
+		*v = uint8(u)
+	case *uint16:
+		u, err = strconv.ParseUint(answer, 10, 16)
+		*v = uint16(u)
+	case *uint32:
+		u, err = strconv.ParseUint(answer, 10, 32)
+		*v = uint32(u)
+	case *int8:
+		i, err = strconv.ParseInt(answer, 10, 8)
+		*v = int8(i)
+	case *float64:
+		*v, err = strconv.ParseFloat(answer, 64)
+	default:
+		err = errors.Errorf("unsupported value type %T", value)
+	}
+
+	return err
+}
+
+// LoadDeviceConfig decodes a device configuration from JSON.
+func LoadDeviceConfig(r io.Reader) (*DeviceConfig, error) {
+	c := &DeviceConfig{}
+
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return nil, errors.Wrap(err, "could not decode device configuration")
+	}
+
+	if c.Version < 1 || c.Version > DeviceConfigVersion {
+		return nil, errors.Errorf("unsupported device configuration version %v", c.Version)
+	}
+
+	return c, nil
+}
+
+// Save encodes the device configuration as JSON.
+func (c *DeviceConfig) Save(w io.Writer) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode device configuration")
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Diff returns the values of the configuration that differ from the desired
+// configuration, in the order they are applied.
+func (c *DeviceConfig) Diff(desired *DeviceConfig) []ConfigDifference {
+	var diff []ConfigDifference
+
+	for _, f := range configFields {
+		current, want := f.value(c), f.value(desired)
+
+		if want == "" || want == nil || reflect.DeepEqual(want, []Channel(nil)) {
+			continue
+		}
+
+		same := reflect.DeepEqual
+		if f.same != nil {
+			same = f.same
+		}
+
+		if !same(current, want) {
+			diff = append(diff, ConfigDifference{Field: f.name, Current: current, Desired: want})
+		}
+	}
+
+	return diff
+}
+
+// VerifyDeviceConfig reads the configuration of the module and returns the
+// differences with the desired configuration.
+func VerifyDeviceConfig(desired *DeviceConfig) ([]ConfigDifference, error) {
+	current, err := ReadDeviceConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return current.Diff(desired), nil
+}
+
+// ApplyDeviceConfig writes only the values that differ from the desired
+// configuration to the module and returns the differences it applied.
+func ApplyDeviceConfig(desired *DeviceConfig) ([]ConfigDifference, error) {
+	diff, err := VerifyDeviceConfig(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, d := range diff {
+		for _, f := range configFields {
+			if f.name != d.Field {
+				continue
+			}
+
+			if err := f.apply(desired); err != nil {
+				return diff[:i], errors.Wrapf(err, "could not apply %s", d.Field)
+			}
+		}
+	}
+
+	return diff, nil
+}
@@ -0,0 +1,258 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var configAnswers = map[string]string{
+	"sys get ver":        "RN2483 1.0.1 Dec 15 2015 09:38:09",
+	"mac get deveui":     "0004A30B001A2B3C",
+	"mac get appeui":     "70B3D57ED0000000",
+	"mac get devaddr":    "26011234",
+	"mac get dr":         "5",
+	"mac get pwridx":     "1",
+	"mac get adr":        "on",
+	"mac get retx":       "7",
+	"mac get rx2":        "3 869525000",
+	"mac get ch freq":    "868100000",
+	"mac get ch drrange": "0 5",
+	"mac get ch dcycle":  "99",
+	"mac get ch status":  "on",
+	"radio get mod":      "lora",
+	"radio get freq":     "868100000",
+	"radio get pwr":      "14",
+	"radio get sf":       "sf12",
+	"radio get bw":       "125",
+	"radio get cr":       "4/5",
+	"radio get crc":      "on",
+	"radio get iqi":      "off",
+	"radio get sync":     "34",
+	"radio get wdt":      "15000",
+	"radio get prlen":    "8",
+	"radio get bitrate":  "50000",
+	"radio get fdev":     "25000",
+	"radio get rxbw":     "62.5",
+	"radio get afcbw":    "83.3",
+	"radio get bt":       "0.5",
+}
+
+func TestReadDeviceConfig(t *testing.T) {
+	defer resetOriginals()
+	mockSerial(t, configAnswers)
+
+	c, err := ReadDeviceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := MacConfig{
+		DeviceEUI:       "0004A30B001A2B3C",
+		ApplicationEUI:  "70B3D57ED0000000",
+		DeviceAddress:   "26011234",
+		DataRate:        5,
+		PowerIndex:      1,
+		ADR:             true,
+		RX2DataRate:     3,
+		RX2Frequency:    869525000,
+		Retransmissions: 7,
+	}
+	radio := RadioConfig{
+		Modulation:         LoRa,
+		Frequency:          868100000,
+		Power:              14,
+		SpreadingFactor:    12,
+		BandWidth:          125,
+		CodingRate:         5,
+		CRC:                true,
+		SyncWord:           "34",
+		PreambleLength:     8,
+		WatchDogTimer:      15000,
+		BitRate:            50000,
+		FrequencyDeviation: 25000,
+		RxBandWidth:        62.5,
+		AFCBandWidth:       83.3,
+		GaussianShaping:    Gaussian05,
+	}
+
+	if len(c.Mac.Channels) != 16 {
+		t.Errorf("%v channels read; should be 16", len(c.Mac.Channels))
+	}
+	c.Mac.Channels = nil
+
+	if c.Version != DeviceConfigVersion || c.System != configAnswers["sys get ver"] {
+		t.Errorf("version = %v, system = %v", c.Version, c.System)
+	}
+	if fmt.Sprint(c.Mac) != fmt.Sprint(mac) {
+		t.Errorf("mac = %+v; should be %+v", c.Mac, mac)
+	}
+	if c.Radio != radio {
+		t.Errorf("radio = %+v; should be %+v", c.Radio, radio)
+	}
+}
+
+func TestReadDeviceConfigError(t *testing.T) {
+	defer resetOriginals()
+	mockSerial(t, map[string]string{"radio get bw": invalidParameter})
+
+	if _, err := ReadDeviceConfig(); err == nil {
+		t.Error("ReadDeviceConfig() succeeded while the module rejected a command")
+	}
+}
+
+func TestApplyDeviceConfig(t *testing.T) {
+	defer resetOriginals()
+	written := mockSerial(t, configAnswers)
+
+	desired, err := ReadDeviceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a golden configuration leaves out the identifiers
+	desired.Mac.DeviceEUI = ""
+	desired.Mac.Retransmissions = 3
+	desired.Radio.SpreadingFactor = 7
+	desired.Radio.SyncWord = "12"
+
+	*written = nil
+	diff, err := ApplyDeviceConfig(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff) != 3 {
+		t.Fatalf("diff = %v; should have 3 differences", diff)
+	}
+
+	var set []string
+	for _, cmd := range *written {
+		if strings.Contains(cmd, " set ") {
+			set = append(set, cmd)
+		}
+	}
+
+	expected := []string{"mac set retx 3", "radio set sf sf7", "radio set sync 12"}
+	if fmt.Sprint(set) != fmt.Sprint(expected) {
+		t.Errorf("commands = %v; should be %v", set, expected)
+	}
+}
+
+func TestDeviceConfigSaveLoad(t *testing.T) {
+	c := &DeviceConfig{Version: DeviceConfigVersion, Radio: RadioConfig{Modulation: LoRa, SpreadingFactor: 9}}
+
+	var b bytes.Buffer
+	if err := c.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadDeviceConfig(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := loaded.Diff(c); len(diff) != 0 {
+		t.Errorf("loaded configuration differs: %v", diff)
+	}
+
+	if _, err := LoadDeviceConfig(strings.NewReader(`{"version": 99}`)); err == nil {
+		t.Error("LoadDeviceConfig accepted an unknown version")
+	}
+}
+
+func TestDeviceConfigDiffUnchanged(t *testing.T) {
+	defer resetOriginals()
+	mockSerial(t, configAnswers)
+
+	current, err := ReadDeviceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only channel 3 is listed, without a duty cycle, and the sync word
+	// differs in case only
+	desired := &DeviceConfig{Version: DeviceConfigVersion, Radio: current.Radio}
+	desired.Mac = current.Mac
+	desired.Mac.Channels = []Channel{{ID: 3, Frequency: 868100000, MinDataRate: 0, MaxDataRate: 5, Enabled: true}}
+	desired.Radio.SyncWord = "3a"
+	current.Radio.SyncWord = "3A"
+
+	if diff := current.Diff(desired); len(diff) != 0 {
+		t.Errorf("Diff() = %v; should be empty", diff)
+	}
+
+	desired.Mac.Channels[0].DutyCycle = 10
+	if diff := current.Diff(desired); len(diff) != 1 || diff[0].Field != "mac.channels" {
+		t.Errorf("Diff() = %v; should only have the channels", diff)
+	}
+}
+
+func TestApplyDeviceConfigFSK(t *testing.T) {
+	defer resetOriginals()
+	written := mockSerial(t, configAnswers)
+
+	desired, err := ReadDeviceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the LoRa values of an FSK configuration are zero
+	desired.Radio = RadioConfig{
+		Modulation:         FSK,
+		Frequency:          868100000,
+		Power:              14,
+		CRC:                true,
+		SyncWord:           "34",
+		WatchDogTimer:      15000,
+		BitRate:            50000,
+		FrequencyDeviation: 25000,
+		GaussianShaping:    Gaussian05,
+	}
+
+	*written = nil
+	diff, err := ApplyDeviceConfig(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the FSK values of a LoRa configuration are unknown, so they are written
+	var fields []string
+	for _, d := range diff {
+		fields = append(fields, d.Field)
+	}
+
+	expected := []string{"radio.mod", "radio.bitrate", "radio.fdev", "radio.bt"}
+	if fmt.Sprint(fields) != fmt.Sprint(expected) {
+		t.Errorf("diff = %v; should be %v", fields, expected)
+	}
+
+	for _, cmd := range *written {
+		if strings.HasPrefix(cmd, "radio set sf") || strings.HasPrefix(cmd, "radio set bw") || strings.HasPrefix(cmd, "radio set cr") {
+			t.Errorf("%v written for an FSK configuration", cmd)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cwSignals are the signals that switch the carrier off before the process
+// exits.
+var cwSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// cwPauseMargin is added to the pause of the LoRaWAN stack for the commands
+// around the carrier, so the stack can't resume while it is on.
+const cwPauseMargin = 5 * time.Second
+
+// ErrCWInterrupted is the cause of the error of RadioCW when a signal
+// ended it and the carrier was switched off.
+var ErrCWInterrupted = errors.New("cw interrupted")
+
+// raiseSignal sends the signal to the process again after the carrier is
+// off, so it still exits the way it would have without RadioCW.
+var raiseSignal = func(sig os.Signal) error {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+
+	return p.Signal(sig)
+}
+
+// RadioCW transmits an unmodulated carrier on the frequency in Hz with the
+// power in dBm, for RF certification. It blocks until the timeout elapses
+// or the context is done, and switches the carrier off with radio cw off
+// before it returns. An interrupt or termination signal also switches the
+// carrier off, before the signal is raised again, and ErrCWInterrupted is
+// the cause of the error. A signal that arrives while the carrier isn't on
+// is raised again as well. When the LoRaWAN stack is active, it is paused
+// for the timeout and resumed afterwards. It returns nil after the timeout
+// and the error of the context when it is done first.
+func RadioCW(ctx context.Context, frequency uint32, power int8, timeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, cwSignals...)
+
+	sig, err := radioCW(ctx, frequency, power, timeout, signals)
+	signal.Stop(signals)
+
+	// a signal during the setup or at the end isn't swallowed
+	if sig == nil {
+		sig = queuedSignal(signals)
+	}
+
+	if sig != nil {
+		// the raised signal may end the process before the caller sees
+		// the error
+		if err != nil && errors.Cause(err) != ErrCWInterrupted {
+			WARN.Println("radio cw error:", err)
+		}
+
+		if err := raiseSignal(sig); err != nil {
+			WARN.Println("radio cw error: could not raise", sig, err)
+		}
+	}
+
+	return err
+}
+
+// queuedSignal returns the signal waiting in the channel, if any.
+func queuedSignal(signals <-chan os.Signal) os.Signal {
+	select {
+	case sig := <-signals:
+		return sig
+	default:
+		return nil
+	}
+}
+
+// radioCW runs RadioCW and returns the signal that ended it, if any.
+func radioCW(ctx context.Context, frequency uint32, power int8, timeout time.Duration, signals <-chan os.Signal) (os.Signal, error) {
+	if timeout <= 0 {
+		return nil, errors.Errorf("invalid cw timeout %v", timeout)
+	}
+
+	if !validRadioFrequency(frequency) {
+		return nil, errors.Errorf("invalid cw frequency %v", frequency)
+	}
+
+	if !validRadioPower(power) {
+		return nil, errors.Errorf("invalid cw power %v", power)
+	}
+
+	lease, err := pauseMac(func() (time.Duration, error) { return timeout + cwPauseMargin, nil })
+	if err != nil {
+		return nil, err
+	}
+	defer lease.release()
+
+	if !RadioSetFrequency(frequency) {
+		return nil, errors.New("could not set cw frequency")
+	}
+
+	if !RadioSetPower(power) {
+		return nil, errors.New("could not set cw power")
+	}
+
+	if err := radioSetCW(true); err != nil {
+		// the module may have started the carrier before it failed
+		if offErr := radioSetCW(false); offErr != nil {
+			return nil, errors.Wrapf(err, "carrier may still be on (%v)", offErr)
+		}
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil, radioSetCW(false)
+	case <-ctx.Done():
+		if err := radioSetCW(false); err != nil {
+			return nil, err
+		}
+		return nil, ctx.Err()
+	case sig := <-signals:
+		if err := radioSetCW(false); err != nil {
+			return sig, err
+		}
+		return sig, errors.Wrapf(ErrCWInterrupted, "%v", sig)
+	}
+}
+
+// radioSetCW switches the continuous wave mode on or off.
+func radioSetCW(on bool) error {
+	mode := "off"
+	if on {
+		mode = "on"
+	}
+
+	answer, err := query("radio cw " + mode)
+	if err != nil {
+		return errors.Wrapf(err, "could not switch cw %v", mode)
+	}
+
+	if answer != "ok" {
+		return errors.Errorf("could not switch cw %v: %s", mode, answer)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRadioCWTimeout(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{macIdle: true}
+	commands := mockSerial(t, nil)
+
+	if err := RadioCW(context.Background(), 868100000, 14, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"radio set freq 868100000", "radio set pwr 14", "radio cw on", "radio cw off"}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q; should be %q", *commands, want)
+	}
+}
+
+func TestRadioCWCancel(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+	commands := mockSerial(t, map[string]string{"mac pause": "4294967245"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if err := RadioCW(ctx, 868100000, 14, time.Minute); err != context.Canceled {
+		t.Errorf("RadioCW() = %v; should be %v", err, context.Canceled)
+	}
+
+	want := []string{"mac pause", "radio set freq 868100000", "radio set pwr 14", "radio cw on", "radio cw off", "mac resume"}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q; should be %q", *commands, want)
+	}
+}
+
+func TestRadioCWPauseMargin(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+	commands := mockSerial(t, map[string]string{"mac pause": "61000"})
+
+	// the pause ends right after the carrier, too short for the commands
+	if err := RadioCW(context.Background(), 868100000, 14, time.Minute); err == nil {
+		t.Error("RadioCW() succeeded while the mac pause has no margin")
+	}
+
+	for _, cmd := range *commands {
+		if cmd == "radio cw on" {
+			t.Error("carrier switched on without a mac pause covering it")
+		}
+	}
+}
+
+func TestRadioCWSignal(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{macIdle: true}
+	commands := mockSerial(t, nil)
+
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+
+	sig, err := radioCW(context.Background(), 868100000, 14, time.Minute, signals)
+	if sig != os.Interrupt || errors.Cause(err) != ErrCWInterrupted {
+		t.Errorf("radioCW() = %v, %v; should be interrupted", sig, err)
+	}
+
+	if last := (*commands)[len(*commands)-1]; last != "radio cw off" {
+		t.Errorf("last command = %q; should be radio cw off", last)
+	}
+}
+
+func TestRadioCWOnFailed(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{macIdle: true}
+	commands := mockSerial(t, map[string]string{"radio cw on": "busy"})
+
+	if err := RadioCW(context.Background(), 868100000, 14, time.Minute); err == nil {
+		t.Fatal("RadioCW() succeeded while radio cw on failed")
+	}
+
+	if last := (*commands)[len(*commands)-1]; last != "radio cw off" {
+		t.Errorf("last command = %q; should be radio cw off", last)
+	}
+}
+
+func TestRadioCWOffFailed(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{macIdle: true}
+	mockSerial(t, map[string]string{"radio cw": "busy"})
+
+	err := RadioCW(context.Background(), 868100000, 14, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "carrier may still be on") {
+		t.Errorf("RadioCW() = %v; should report the carrier may still be on", err)
+	}
+}
+
+func TestRadioCWQueuedSignal(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	mockSerial(t, nil)
+
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+
+	// the setup fails before the signal is read
+	if sig, err := radioCW(context.Background(), 915000000, 14, time.Second, signals); sig != nil || err == nil {
+		t.Fatalf("radioCW() = %v, %v; should fail without a signal", sig, err)
+	}
+
+	if sig := queuedSignal(signals); sig != os.Interrupt {
+		t.Errorf("queuedSignal() = %v; should be interrupt", sig)
+	}
+	if sig := queuedSignal(signals); sig != nil {
+		t.Errorf("queuedSignal() = %v; should be none", sig)
+	}
+}
+
+func TestRadioCWInvalid(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	commands := mockSerial(t, nil)
+
+	for name, err := range map[string]error{
+		"timeout":   RadioCW(context.Background(), 868100000, 14, 0),
+		"frequency": RadioCW(context.Background(), 915000000, 14, time.Second),
+		"power":     RadioCW(context.Background(), 868100000, 20, time.Second),
+	} {
+		if err == nil {
+			t.Errorf("RadioCW() accepted an invalid %v", name)
+		}
+	}
+
+	if len(*commands) != 0 {
+		t.Errorf("commands = %q; should be none", *commands)
+	}
+}
@@ -0,0 +1,306 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SubBand is a frequency range with a regulatory duty cycle limit.
+// The frequencies are in Hz, from MinFrequency up to but not including
+// MaxFrequency, so neighbouring sub-bands can share an edge. The duty cycle
+// is a fraction (0.01 for 1%).
+type SubBand struct {
+	Name         string
+	MinFrequency uint32
+	MaxFrequency uint32
+	DutyCycle    float64
+}
+
+// The duty cycle policies
+const (
+	// DutyCycleRefuse refuses a transmission that would exceed the budget.
+	DutyCycleRefuse = iota
+	// DutyCycleDelay delays a transmission until it fits in the budget.
+	DutyCycleDelay
+)
+
+// DefaultDutyCycleWindow is the observation period of the duty cycle.
+const DefaultDutyCycleWindow = time.Hour
+
+// ErrDutyCycle is returned when a transmission would exceed the duty cycle.
+var ErrDutyCycle = errors.New("duty cycle budget exceeded")
+
+type transmission struct {
+	start   time.Time
+	subBand int
+	airtime time.Duration
+}
+
+// DutyCycleTracker keeps a sliding window of the transmissions per sub-band,
+// to enforce the regulatory duty cycle of raw radio transmissions.
+type DutyCycleTracker struct {
+	// Policy determines what happens with a transmission that would exceed
+	// the budget.
+	Policy int
+	// Window is the observation period of the duty cycle.
+	Window time.Duration
+
+	mu            sync.Mutex
+	subBands      []SubBand
+	transmissions []transmission
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewDutyCycleTracker returns a tracker for the given sub-bands. When no
+// sub-bands are given, the sub-bands of the regions of the module are used.
+func NewDutyCycleTracker(policy int, subBands ...SubBand) *DutyCycleTracker {
+	if len(subBands) == 0 {
+		subBands = moduleSubBands()
+	}
+
+	return &DutyCycleTracker{
+		Policy:   policy,
+		Window:   DefaultDutyCycleWindow,
+		subBands: subBands,
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// SubBand returns the sub-band of the frequency.
+func (d *DutyCycleTracker) SubBand(frequency uint32) (SubBand, bool) {
+	i := subBandIndex(d.subBands, frequency)
+	if i < 0 {
+		return SubBand{}, false
+	}
+
+	return d.subBands[i], true
+}
+
+// Remaining returns the airtime left in the budget of the frequency's sub-band.
+func (d *DutyCycleTracker) Remaining(frequency uint32) (time.Duration, error) {
+	i := subBandIndex(d.subBands, frequency)
+	if i < 0 {
+		return 0, errors.Errorf("frequency %v is not in a sub-band", frequency)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	remaining := d.budget(i) - d.used(i, d.now())
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, nil
+}
+
+// Wait returns how long to wait before a transmission of the given airtime
+// fits in the budget of the frequency's sub-band.
+func (d *DutyCycleTracker) Wait(frequency uint32, airtime time.Duration) (time.Duration, error) {
+	i := subBandIndex(d.subBands, frequency)
+	if i < 0 {
+		return 0, errors.Errorf("frequency %v is not in a sub-band", frequency)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.wait(i, airtime)
+}
+
+// Reserve applies the policy for a transmission of the given airtime on the
+// frequency: it returns ErrDutyCycle if it is refused, or waits until the
+// transmission fits in the budget. The transmission is recorded when it is
+// allowed.
+func (d *DutyCycleTracker) Reserve(frequency uint32, airtime time.Duration) error {
+	_, err := d.reserve(frequency, airtime)
+	return err
+}
+
+// reserve is Reserve, returning the recorded transmission for release.
+func (d *DutyCycleTracker) reserve(frequency uint32, airtime time.Duration) (transmission, error) {
+	i := subBandIndex(d.subBands, frequency)
+	if i < 0 {
+		return transmission{}, errors.Errorf("frequency %v is not in a sub-band", frequency)
+	}
+
+	for {
+		d.mu.Lock()
+		wait, err := d.wait(i, airtime)
+		if err == nil && wait == 0 {
+			// the check and the record can't be separated by another reservation
+			t := transmission{start: d.now(), subBand: i, airtime: airtime}
+			d.transmissions = append(d.transmissions, t)
+			d.mu.Unlock()
+
+			return t, nil
+		}
+		d.mu.Unlock()
+
+		if err != nil {
+			return transmission{}, err
+		}
+
+		if d.Policy != DutyCycleDelay {
+			return transmission{}, errors.Wrapf(ErrDutyCycle, "next transmission possible in %v", wait)
+		}
+
+		DEBUG.Printf("duty cycle: delaying transmission by %v", wait)
+		d.sleep(wait)
+	}
+}
+
+// release removes a reserved transmission that didn't take place.
+func (d *DutyCycleTracker) release(t transmission) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, r := range d.transmissions {
+		if r == t {
+			d.transmissions = append(d.transmissions[:i], d.transmissions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Record adds a transmission of the given airtime on the frequency.
+func (d *DutyCycleTracker) Record(frequency uint32, airtime time.Duration) {
+	i := subBandIndex(d.subBands, frequency)
+	if i < 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.transmissions = append(d.transmissions, transmission{start: d.now(), subBand: i, airtime: airtime})
+}
+
+// subBandIndex returns the index of the sub-band of the frequency, or -1.
+func subBandIndex(subBands []SubBand, frequency uint32) int {
+	for i, sb := range subBands {
+		if frequency >= sb.MinFrequency && frequency < sb.MaxFrequency {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (d *DutyCycleTracker) budget(i int) time.Duration {
+	return time.Duration(float64(d.Window) * d.subBands[i].DutyCycle)
+}
+
+// used returns the airtime used in the sub-band during the window before now,
+// and drops the transmissions that are outside of any window.
+func (d *DutyCycleTracker) used(i int, now time.Time) time.Duration {
+	var used time.Duration
+	kept := d.transmissions[:0]
+
+	for _, t := range d.transmissions {
+		if now.Sub(t.start) >= d.Window {
+			continue
+		}
+
+		kept = append(kept, t)
+
+		if t.subBand == i {
+			used += t.airtime
+		}
+	}
+
+	d.transmissions = kept
+
+	return used
+}
+
+func (d *DutyCycleTracker) wait(i int, airtime time.Duration) (time.Duration, error) {
+	budget := d.budget(i)
+	if airtime > budget {
+		return 0, errors.Wrapf(ErrDutyCycle, "airtime %v exceeds the budget of %v", airtime, budget)
+	}
+
+	now := d.now()
+	used := d.used(i, now)
+
+	var starts []transmission
+	for _, t := range d.transmissions {
+		if t.subBand == i {
+			starts = append(starts, t)
+		}
+	}
+
+	sort.Slice(starts, func(a, b int) bool { return starts[a].start.Before(starts[b].start) })
+
+	// Drop the oldest transmissions until the new one fits
+	for _, t := range starts {
+		if used+airtime <= budget {
+			break
+		}
+
+		used -= t.airtime
+		if wait := t.start.Add(d.Window).Sub(now); used+airtime <= budget {
+			return wait, nil
+		}
+	}
+
+	return 0, nil
+}
+
+var radioDutyCycle *DutyCycleTracker
+
+// SetRadioDutyCycleTracker makes RadioTx enforce the duty cycle with the
+// given tracker. Passing nil disables the duty cycle enforcement.
+func SetRadioDutyCycleTracker(tracker *DutyCycleTracker) {
+	radioDutyCycle = tracker
+}
+
+// reserveRadioDutyCycle reserves the airtime of a raw radio transmission
+// with the current radio settings. It returns the function that releases
+// the reservation when the transmission fails.
+func reserveRadioDutyCycle(length int) (func(), bool) {
+	frequency := RadioGetFrequency()
+
+	airtime, err := RadioTimeOnAir(length)
+	if err != nil {
+		WARN.Println("radio tx error: could not determine airtime:", err)
+		return nil, false
+	}
+
+	tracker := radioDutyCycle
+	t, err := tracker.reserve(frequency, airtime)
+	if err != nil {
+		WARN.Println("radio tx error:", err)
+		return nil, false
+	}
+
+	return func() { tracker.release(t) }, true
+}
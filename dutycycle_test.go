@@ -0,0 +1,222 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestDutyCycleTracker(policy int) (*DutyCycleTracker, *time.Time) {
+	now := time.Date(2018, time.August, 1, 12, 0, 0, 0, time.UTC)
+
+	d := NewDutyCycleTracker(policy)
+	d.now = func() time.Time { return now }
+	d.sleep = func(wait time.Duration) { now = now.Add(wait) }
+
+	return d, &now
+}
+
+func TestDutyCycleSubBand(t *testing.T) {
+	d := NewDutyCycleTracker(DutyCycleRefuse)
+
+	tests := map[uint32]float64{
+		863500000: 0.001,
+		865000000: 0.01,
+		868000000: 0.01,
+		868100000: 0.01,
+		869525000: 0.1,
+		433175000: 0.1,
+		// the general sub-bands between the LoRaWAN ones
+		868600000: 0.001,
+		868699999: 0.001,
+		869300000: 0.001,
+		869650000: 0.001,
+		869999999: 0.01,
+	}
+
+	for frequency, dc := range tests {
+		sb, ok := d.SubBand(frequency)
+		if !ok || sb.DutyCycle != dc {
+			t.Errorf("SubBand(%v) = %+v; should have duty cycle %v", frequency, sb, dc)
+		}
+	}
+
+	if _, ok := d.SubBand(870000000); ok {
+		t.Error("SubBand(870000000) returned a sub-band for a frequency past the last sub-band")
+	}
+
+	// 868000000 is the first frequency of g1, not the last one of g865
+	if sb, _ := d.SubBand(868000000); sb.Name != "g1" {
+		t.Errorf("SubBand(868000000) = %+v; should be g1", sb)
+	}
+}
+
+func TestDutyCycleSubBandNames(t *testing.T) {
+	for _, region := range []*Region{EU868, EU433} {
+		names := map[string]bool{}
+		for _, sb := range region.SubBands {
+			if names[sb.Name] {
+				t.Errorf("%v has two sub-bands named %v", region.Name, sb.Name)
+			}
+			names[sb.Name] = true
+		}
+	}
+}
+
+func TestDutyCycleRefuse(t *testing.T) {
+	d, now := newTestDutyCycleTracker(DutyCycleRefuse)
+
+	// 1% of an hour is 36 seconds
+	if err := d.Reserve(868100000, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, _ := d.Remaining(868300000)
+	if remaining != 6*time.Second {
+		t.Errorf("Remaining() = %v; should be 6s", remaining)
+	}
+
+	if err := d.Reserve(868500000, 10*time.Second); err == nil {
+		t.Error("Reserve() returned no error while the budget is exceeded")
+	}
+
+	// Other sub-bands have their own budget
+	if err := d.Reserve(869525000, 10*time.Second); err != nil {
+		t.Errorf("Reserve() returned an error for another sub-band: %v", err)
+	}
+
+	*now = now.Add(time.Hour)
+
+	if err := d.Reserve(868500000, 10*time.Second); err != nil {
+		t.Errorf("Reserve() returned an error after the window passed: %v", err)
+	}
+}
+
+func TestDutyCycleDelay(t *testing.T) {
+	d, now := newTestDutyCycleTracker(DutyCycleDelay)
+	start := *now
+
+	d.Reserve(868100000, 20*time.Second)
+	*now = now.Add(10 * time.Minute)
+	d.Reserve(868100000, 10*time.Second)
+
+	wait, _ := d.Wait(868100000, 10*time.Second)
+	if wait != 50*time.Minute {
+		t.Errorf("Wait() = %v; should be 50m", wait)
+	}
+
+	if err := d.Reserve(868100000, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if now.Sub(start) != time.Hour {
+		t.Errorf("Reserve() delayed until %v after the start; should be 1h", now.Sub(start))
+	}
+}
+
+func TestDutyCycleAirtimeTooLong(t *testing.T) {
+	d, _ := newTestDutyCycleTracker(DutyCycleDelay)
+
+	if err := d.Reserve(868100000, time.Minute); err == nil {
+		t.Error("Reserve() returned no error with an airtime larger than the budget")
+	}
+}
+
+func TestRadioTxDutyCycleRefused(t *testing.T) {
+	written := mockSerial(t, map[string]string{
+		"radio get freq":  "868100000",
+		"radio get mod":   "lora",
+		"radio get sf":    "sf12",
+		"radio get bw":    "125",
+		"radio get cr":    "4/5",
+		"radio get crc":   "on",
+		"radio get prlen": "8",
+	})
+	defer resetOriginals()
+
+	d, _ := newTestDutyCycleTracker(DutyCycleRefuse)
+	d.Record(868100000, 35500*time.Millisecond)
+
+	SetRadioDutyCycleTracker(d)
+	defer SetRadioDutyCycleTracker(nil)
+
+	if RadioTx([]byte("test")) == true {
+		t.Error("RadioTx() returned true while the duty cycle budget is exceeded")
+	}
+
+	for _, s := range *written {
+		if s == "radio tx 74657374" {
+			t.Error("RadioTx() transmitted while the duty cycle budget is exceeded")
+		}
+	}
+}
+
+func TestRadioTxDutyCycleReleased(t *testing.T) {
+	mockSerial(t, map[string]string{
+		"radio get freq":  "868100000",
+		"radio get mod":   "lora",
+		"radio get sf":    "sf12",
+		"radio get bw":    "125",
+		"radio get cr":    "4/5",
+		"radio get crc":   "on",
+		"radio get prlen": "8",
+		"radio tx":        "invalid_param",
+	})
+	defer resetOriginals()
+
+	d, _ := newTestDutyCycleTracker(DutyCycleRefuse)
+
+	SetRadioDutyCycleTracker(d)
+	defer SetRadioDutyCycleTracker(nil)
+
+	if RadioTx([]byte("test")) == true {
+		t.Fatal("RadioTx() returned true while the transmission failed")
+	}
+
+	remaining, _ := d.Remaining(868100000)
+	if remaining != 36*time.Second {
+		t.Errorf("Remaining() = %v after a failed transmission; should be 36s", remaining)
+	}
+}
+
+func TestDutyCycleReserveConcurrent(t *testing.T) {
+	d, _ := newTestDutyCycleTracker(DutyCycleRefuse)
+
+	// only three of the 10 second transmissions fit in the 36 second budget
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() { errs <- d.Reserve(868100000, 10*time.Second) }()
+	}
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if <-errs == nil {
+			allowed++
+		}
+	}
+
+	if allowed != 3 {
+		t.Errorf("Reserve() allowed %v concurrent transmissions; should be 3", allowed)
+	}
+}
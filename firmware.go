@@ -0,0 +1,179 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// firmwareDateLayout is the layout of the build date in the version string.
+const firmwareDateLayout = "Jan 2 2006 15:04:05"
+
+// FirmwareVersion is the decoded version string of the module,
+// like "RN2483 1.0.3 Mar 22 2017 06:00:42".
+type FirmwareVersion struct {
+	Model string
+	Major int
+	Minor int
+	Patch int
+	Date  time.Time
+}
+
+// ParseFirmwareVersion decodes the answer of sys get ver or the boot banner.
+func ParseFirmwareVersion(s string) (FirmwareVersion, error) {
+	var v FirmwareVersion
+
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return v, errors.Errorf("invalid firmware version %q", s)
+	}
+
+	v.Model = fields[0]
+
+	parts := strings.Split(fields[1], ".")
+	if len(parts) != 3 {
+		return v, errors.Errorf("invalid firmware version %q", s)
+	}
+
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, errors.Wrapf(err, "invalid firmware version %q", s)
+		}
+		*numbers[i] = n
+	}
+
+	if len(fields) >= 6 {
+		date, err := time.Parse(firmwareDateLayout, strings.Join(fields[2:6], " "))
+		if err != nil {
+			return v, errors.Wrapf(err, "invalid firmware date %q", s)
+		}
+		v.Date = date
+	}
+
+	return v, nil
+}
+
+func (v FirmwareVersion) String() string {
+	return fmt.Sprintf("%s %d.%d.%d", v.Model, v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns whether the version is the given version or newer.
+func (v FirmwareVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+
+	return v.Patch >= patch
+}
+
+// Capability is a feature that depends on the firmware version, see Supports.
+type Capability int
+
+// The capabilities that depend on the firmware version
+const (
+	CapClassC Capability = iota
+	CapMulticast
+	CapRadioRSSI
+	CapRadioRxStop
+)
+
+// ErrUnsupported is the cause of the errors of calls that need a capability
+// the firmware of the module doesn't have.
+var ErrUnsupported = errors.New("not supported by the firmware")
+
+// capabilities is the oldest firmware of each model supporting a capability.
+var capabilities = map[Capability]struct {
+	name     string
+	versions map[string][3]int
+}{
+	CapClassC:      {"class C", map[string][3]int{ModuleRN2483: {1, 0, 5}, ModuleRN2903: {1, 0, 5}}},
+	CapMulticast:   {"multicast", map[string][3]int{ModuleRN2483: {1, 0, 5}, ModuleRN2903: {1, 0, 5}}},
+	CapRadioRSSI:   {"radio get rssi", map[string][3]int{ModuleRN2483: {1, 0, 5}, ModuleRN2903: {1, 0, 5}}},
+	CapRadioRxStop: {"radio rxstop", map[string][3]int{ModuleRN2483: {1, 0, 5}, ModuleRN2903: {1, 0, 5}}},
+}
+
+// firmware is the firmware of the module, nil until it is read
+var firmware *FirmwareVersion
+
+// Firmware returns the decoded firmware version of the module.
+// The version is read once and kept for the capability checks.
+func Firmware() (FirmwareVersion, error) {
+	if firmware != nil {
+		return *firmware, nil
+	}
+
+	version := Version()
+	if version == "" {
+		return FirmwareVersion{}, errors.New("could not read the firmware version")
+	}
+
+	v, err := ParseFirmwareVersion(version)
+	if err != nil {
+		return v, err
+	}
+
+	firmware = &v
+
+	return v, nil
+}
+
+// Supports returns whether the firmware has the capability. The firmware
+// version is read from the module the first time, when it is still unknown.
+// If it can't be read, every capability is assumed.
+func Supports(capability Capability) bool {
+	return requireCapability(capability) == nil
+}
+
+// requireCapability returns an error with ErrUnsupported as cause when the
+// firmware doesn't have the capability.
+func requireCapability(capability Capability) error {
+	c, ok := capabilities[capability]
+	if !ok {
+		return errors.Errorf("unknown capability %v", capability)
+	}
+
+	if firmware == nil {
+		if _, err := Firmware(); err != nil {
+			WARN.Printf("%s: assuming the capability: %v", c.name, err)
+			return nil
+		}
+	}
+
+	min, ok := c.versions[firmware.Model]
+	if !ok || !firmware.AtLeast(min[0], min[1], min[2]) {
+		return errors.Wrapf(ErrUnsupported, "%s on %v", c.name, firmware)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseFirmwareVersion(t *testing.T) {
+	v, err := ParseFirmwareVersion("RN2483 1.0.3 Mar 22 2017 06:00:42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := FirmwareVersion{
+		Model: ModuleRN2483,
+		Major: 1,
+		Minor: 0,
+		Patch: 3,
+		Date:  time.Date(2017, time.March, 22, 6, 0, 42, 0, time.UTC),
+	}
+	if v != expected {
+		t.Errorf("ParseFirmwareVersion() = %+v; should be %+v", v, expected)
+	}
+	if v.String() != "RN2483 1.0.3" {
+		t.Errorf("String() = %v; should be RN2483 1.0.3", v)
+	}
+
+	for _, s := range []string{"", "RN2483", "RN2483 1.0", "RN2483 1.x.3", "RN2483 1.0.3 Mar 32 2017 06:00:42"} {
+		if _, err := ParseFirmwareVersion(s); err == nil {
+			t.Errorf("ParseFirmwareVersion(%q) succeeded", s)
+		}
+	}
+}
+
+func TestFirmwareVersionAtLeast(t *testing.T) {
+	v := FirmwareVersion{Major: 1, Minor: 0, Patch: 3}
+
+	tests := []struct {
+		major, minor, patch int
+		result              bool
+	}{
+		{1, 0, 3, true},
+		{1, 0, 2, true},
+		{0, 9, 9, true},
+		{1, 0, 5, false},
+		{1, 1, 0, false},
+		{2, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		if v.AtLeast(test.major, test.minor, test.patch) != test.result {
+			t.Errorf("AtLeast(%v, %v, %v) = %v", test.major, test.minor, test.patch, !test.result)
+		}
+	}
+}
+
+// knownFirmware sets a firmware version with every capability, so it isn't
+// read from the module. restoreModule forgets it.
+func knownFirmware() {
+	firmware = &FirmwareVersion{Model: ModuleRN2483, Major: 1, Minor: 0, Patch: 5}
+}
+
+func TestCapabilityGating(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	written := mockSerial(t, map[string]string{"sys get ver": "RN2483 1.0.3 Mar 22 2017 06:00:42"})
+
+	// the unknown firmware is read the first time
+	if Supports(CapClassC) {
+		t.Error("Supports(CapClassC) = true on 1.0.3")
+	}
+	if fmt.Sprint(*written) != "[sys get ver]" {
+		t.Errorf("commands = %v; should be [sys get ver]", *written)
+	}
+
+	*written = nil
+
+	err := MacSetClass(ClassC)
+	if errors.Cause(err) != ErrUnsupported {
+		t.Errorf("MacSetClass(ClassC) = %v; should be unsupported", err)
+	}
+	if err := MacSetMulticast(true); errors.Cause(err) != ErrUnsupported {
+		t.Errorf("MacSetMulticast(true) = %v; should be unsupported", err)
+	}
+	if len(*written) != 0 {
+		t.Errorf("commands = %v; should be none", *written)
+	}
+
+	if err := MacSetClass(ClassA); err != nil {
+		t.Errorf("MacSetClass(ClassA) = %v; should be nil", err)
+	}
+
+	firmware = &FirmwareVersion{Model: ModuleRN2483, Major: 1, Minor: 0, Patch: 5}
+	if err := MacSetClass(ClassC); err != nil {
+		t.Errorf("MacSetClass(ClassC) = %v on 1.0.5; should be nil", err)
+	}
+}
+
+func TestCapabilityUnknownFirmware(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	mockSerial(t, map[string]string{"sys get ver": invalidParameter})
+
+	if !Supports(CapClassC) {
+		t.Error("Supports(CapClassC) = false while the firmware can't be read")
+	}
+}
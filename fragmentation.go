@@ -0,0 +1,635 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FragmentationPort is the FPort of the LoRaWAN Fragmented Data Block
+// Transport package (TS004).
+const FragmentationPort = uint8(201)
+
+// The fragmentation commands
+const (
+	fragPackageVersion = byte(0x00)
+	fragSessionStatus  = byte(0x01)
+	fragSessionSetup   = byte(0x02)
+	fragSessionDelete  = byte(0x03)
+	fragDataFragment   = byte(0x08)
+)
+
+// The fragmentation package identifier and version
+const (
+	fragPackageID       = byte(3)
+	fragPackageVersion1 = byte(1)
+)
+
+// The FragSessionSetupAns status bits
+const (
+	fragSetupEncodingUnsupported = byte(1 << 0)
+	fragSetupNotEnoughMemory     = byte(1 << 1)
+	fragSetupIndexUnsupported    = byte(1 << 2)
+	fragSetupWrongDescriptor     = byte(1 << 3)
+)
+
+// fragAnswerDelay returns the random delay before a FragSessionStatusAns is
+// sent, to spread the answers of all devices in a multicast group.
+var fragAnswerDelay = func(blockAckDelay uint8) time.Duration {
+	max := int64(1) << (blockAckDelay + 4)
+	return time.Duration(rand.Int63n(max*1000)) * time.Millisecond
+}
+
+// FragmentStore holds the uncoded fragments of a data block while it is being
+// received. Fragment i (starting at 0) is stored at offset i * fragment size.
+type FragmentStore interface {
+	io.ReaderAt
+	io.WriterAt
+
+	// Init prepares the store for a data block of the given size. It should
+	// return an error if the block doesn't fit.
+	Init(size int) error
+}
+
+// MemoryFragmentStore is a FragmentStore backed by a byte slice.
+type MemoryFragmentStore struct {
+	// Capacity limits the size of the data block, 0 means unlimited.
+	Capacity int
+
+	buf []byte
+}
+
+// Init allocates the memory for the data block.
+func (m *MemoryFragmentStore) Init(size int) error {
+	if m.Capacity > 0 && size > m.Capacity {
+		return errors.Errorf("data block of %v bytes exceeds the capacity of %v bytes", size, m.Capacity)
+	}
+
+	m.buf = make([]byte, size)
+
+	return nil
+}
+
+// ReadAt reads from the data block.
+func (m *MemoryFragmentStore) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(m.buf)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt writes to the data block.
+func (m *MemoryFragmentStore) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(m.buf)) {
+		return 0, errors.New("write outside of the data block")
+	}
+
+	return copy(m.buf[off:], p), nil
+}
+
+// VerifyCRC32 is an integrity check which expects the session descriptor to
+// hold the IEEE CRC32 of the data block.
+func VerifyCRC32(descriptor uint32, data []byte) error {
+	if sum := crc32.ChecksumIEEE(data); sum != descriptor {
+		return errors.Errorf("crc32 mismatch: %08X != %08X", sum, descriptor)
+	}
+
+	return nil
+}
+
+// FragmentationReceiver receives data blocks with the LoRaWAN Fragmented
+// Data Block Transport package. Lost fragments are recovered with the
+// forward error correction of the coded fragments.
+type FragmentationReceiver struct {
+	// Verify checks the integrity of a completed data block. A block that
+	// fails the check is discarded. No check is done when it is nil.
+	Verify func(descriptor uint32, data []byte) error
+	// OnComplete is called with every data block that has been received.
+	OnComplete func(index uint8, descriptor uint32, data []byte)
+
+	mu       sync.Mutex
+	sessions [4]*fragSession
+	pending  []fragAnswer
+	newStore func(index uint8) FragmentStore
+	send     func(port uint8, data []byte) error
+}
+
+// fragAnswer is an answer that is sent once it is due.
+type fragAnswer struct {
+	due  time.Time
+	data []byte
+}
+
+type fragSession struct {
+	mcGroupMask   uint8
+	nbFrag        int
+	fragSize      int
+	blockAckDelay uint8
+	padding       int
+	descriptor    uint32
+	decoder       *fragDecoder
+}
+
+// NewFragmentationReceiver returns a receiver that gets its downlinks from the
+// router and stores every session in a store returned by newStore. If newStore
+// is nil, the fragments are kept in memory. The delayed FragSessionStatusAns
+// is sent when the router polls, see Router.Poll.
+func NewFragmentationReceiver(router *Router, newStore func(index uint8) FragmentStore) *FragmentationReceiver {
+	f := newFragmentationReceiver(func(port uint8, data []byte) error {
+		if !MacTxRouted(false, port, data, router) {
+			return errors.New("could not transmit fragmentation uplink")
+		}
+		return nil
+	}, newStore)
+
+	if router != nil {
+		router.Handle(FragmentationPort, f.HandleDownlink)
+		router.HandlePoll(f.SendDue)
+	}
+
+	return f
+}
+
+func newFragmentationReceiver(send func(port uint8, data []byte) error, newStore func(index uint8) FragmentStore) *FragmentationReceiver {
+	if newStore == nil {
+		newStore = func(index uint8) FragmentStore {
+			return &MemoryFragmentStore{}
+		}
+	}
+
+	return &FragmentationReceiver{newStore: newStore, send: send}
+}
+
+// HandleDownlink processes the fragmentation commands in the downlink.
+// It can be registered on a router for port 201.
+func (f *FragmentationReceiver) HandleDownlink(d Downlink) {
+	var answers []byte
+	var err error
+
+	data := d.Data
+	for len(data) > 0 && err == nil {
+		cmd := data[0]
+		data = data[1:]
+
+		switch cmd {
+		case fragPackageVersion:
+			answers = append(answers, fragPackageVersion, fragPackageID, fragPackageVersion1)
+		case fragSessionStatus:
+			if len(data) < 1 {
+				err = errors.New("FragSessionStatusReq too short")
+				break
+			}
+			f.sessionStatus(data[0])
+			data = data[1:]
+		case fragSessionSetup:
+			if len(data) < 10 {
+				err = errors.New("FragSessionSetupReq too short")
+				break
+			}
+			answers = append(answers, fragSessionSetup, f.sessionSetup(data[:10]))
+			data = data[10:]
+		case fragSessionDelete:
+			if len(data) < 1 {
+				err = errors.New("FragSessionDeleteReq too short")
+				break
+			}
+			answers = append(answers, fragSessionDelete, f.sessionDelete(data[0]&0x03))
+			data = data[1:]
+		case fragDataFragment:
+			if len(data) < 2 {
+				err = errors.New("DataFragment too short")
+				break
+			}
+			err = f.dataFragment(binary.LittleEndian.Uint16(data[:2]), data[2:])
+			data = nil
+		default:
+			err = errors.Errorf("unknown command 0x%02X", cmd)
+		}
+	}
+
+	if err != nil {
+		WARN.Println("fragmentation error:", err)
+	}
+
+	if len(answers) > 0 {
+		if err := f.send(FragmentationPort, answers); err != nil {
+			WARN.Println("fragmentation error:", err)
+		}
+	}
+}
+
+func (f *FragmentationReceiver) sessionSetup(req []byte) byte {
+	index := (req[0] >> 4) & 0x03
+	status := index << 6
+
+	s := &fragSession{
+		mcGroupMask:   req[0] & 0x0F,
+		nbFrag:        int(binary.LittleEndian.Uint16(req[1:3])),
+		fragSize:      int(req[3]),
+		blockAckDelay: req[4] & 0x07,
+		padding:       int(req[5]),
+		descriptor:    binary.LittleEndian.Uint32(req[6:10]),
+	}
+
+	if algo := (req[4] >> 3) & 0x07; algo != 0 {
+		status |= fragSetupEncodingUnsupported
+	}
+
+	if s.nbFrag == 0 || s.fragSize == 0 || s.padding >= s.nbFrag*s.fragSize {
+		status |= fragSetupWrongDescriptor
+	}
+
+	if status&0x3F != 0 {
+		return status
+	}
+
+	store := f.newStore(index)
+	if store == nil {
+		return status | fragSetupIndexUnsupported
+	}
+
+	if err := store.Init(s.nbFrag * s.fragSize); err != nil {
+		WARN.Println("fragmentation error:", err)
+		return status | fragSetupNotEnoughMemory
+	}
+
+	s.decoder = newFragDecoder(s.nbFrag, s.fragSize, store)
+
+	f.mu.Lock()
+	f.sessions[index] = s
+	f.mu.Unlock()
+
+	DEBUG.Printf("fragmentation session %v: %v fragments of %v bytes", index, s.nbFrag, s.fragSize)
+
+	return status
+}
+
+func (f *FragmentationReceiver) sessionDelete(index uint8) byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.sessions[index] == nil {
+		return index | 1<<2
+	}
+
+	f.sessions[index] = nil
+
+	return index
+}
+
+func (f *FragmentationReceiver) sessionStatus(param byte) {
+	index := (param >> 1) & 0x03
+	allParticipants := param&0x01 != 0
+
+	f.mu.Lock()
+	s := f.sessions[index]
+	if s == nil {
+		f.mu.Unlock()
+		return
+	}
+
+	received := s.decoder.received
+	missing := s.decoder.needed()
+	delay := s.blockAckDelay
+	f.mu.Unlock()
+
+	if !allParticipants && missing == 0 {
+		return
+	}
+
+	if received > 0x3FFF {
+		received = 0x3FFF
+	}
+
+	if missing > 255 {
+		missing = 255
+	}
+
+	ans := make([]byte, 5)
+	ans[0] = fragSessionStatus
+	binary.LittleEndian.PutUint16(ans[1:3], uint16(received)|uint16(index)<<14)
+	ans[3] = byte(missing)
+
+	f.mu.Lock()
+	f.pending = append(f.pending, fragAnswer{due: time.Now().Add(fragAnswerDelay(delay)), data: ans})
+	f.mu.Unlock()
+}
+
+// SendDue sends the delayed answers that are due, on the goroutine of the
+// caller. The router calls it when it polls.
+func (f *FragmentationReceiver) SendDue() {
+	now := time.Now()
+
+	f.mu.Lock()
+	var due [][]byte
+	pending := f.pending[:0]
+	for _, a := range f.pending {
+		if now.Before(a.due) {
+			pending = append(pending, a)
+		} else {
+			due = append(due, a.data)
+		}
+	}
+	f.pending = pending
+	f.mu.Unlock()
+
+	for _, data := range due {
+		if err := f.send(FragmentationPort, data); err != nil {
+			WARN.Println("fragmentation error:", err)
+		}
+	}
+}
+
+func (f *FragmentationReceiver) dataFragment(indexAndN uint16, payload []byte) error {
+	index := uint8(indexAndN >> 14)
+	n := int(indexAndN & 0x3FFF)
+
+	f.mu.Lock()
+	s := f.sessions[index]
+	if s == nil {
+		f.mu.Unlock()
+		return errors.Errorf("fragment for unknown session %v", index)
+	}
+
+	if len(payload) != s.fragSize {
+		f.mu.Unlock()
+		return errors.Errorf("fragment of %v bytes, expected %v", len(payload), s.fragSize)
+	}
+
+	wasComplete := s.decoder.complete
+	err := s.decoder.process(n, payload)
+	completed := !wasComplete && s.decoder.complete
+	f.mu.Unlock()
+
+	if err != nil || !completed {
+		return err
+	}
+
+	data := make([]byte, s.nbFrag*s.fragSize-s.padding)
+	if _, err := s.decoder.store.ReadAt(data, 0); err != nil && err != io.EOF {
+		return errors.Wrap(err, "could not read data block")
+	}
+
+	if f.Verify != nil {
+		if err := f.Verify(s.descriptor, data); err != nil {
+			return errors.Wrapf(err, "data block of session %v failed the integrity check", index)
+		}
+	}
+
+	DEBUG.Printf("fragmentation session %v: data block of %v bytes complete", index, len(data))
+
+	if f.OnComplete != nil {
+		f.OnComplete(index, s.descriptor, data)
+	}
+
+	return nil
+}
+
+// fragDecoder reassembles a data block from uncoded and coded fragments.
+// The coded fragments are kept as parity rows in row echelon form, indexed
+// by their pivot (their lowest missing fragment). Once there is a row for
+// every missing fragment, the missing fragments are solved by back
+// substitution.
+type fragDecoder struct {
+	nbFrag   int
+	fragSize int
+	store    FragmentStore
+	known    []bool
+	missing  int
+	rows     map[int]*parityRow
+	received int
+	complete bool
+}
+
+type parityRow struct {
+	bits []uint64
+	data []byte
+}
+
+func newFragDecoder(nbFrag, fragSize int, store FragmentStore) *fragDecoder {
+	return &fragDecoder{
+		nbFrag:   nbFrag,
+		fragSize: fragSize,
+		store:    store,
+		known:    make([]bool, nbFrag),
+		missing:  nbFrag,
+		rows:     make(map[int]*parityRow),
+	}
+}
+
+// needed returns the number of fragments that are still needed.
+func (d *fragDecoder) needed() int {
+	return d.missing - len(d.rows)
+}
+
+// process handles fragment n, with 1 <= n <= nbFrag for the uncoded fragments
+// and n > nbFrag for the coded fragments.
+func (d *fragDecoder) process(n int, payload []byte) error {
+	if n == 0 {
+		return errors.New("invalid fragment number 0")
+	}
+
+	if d.complete {
+		return nil
+	}
+
+	d.received++
+
+	if n <= d.nbFrag {
+		i := n - 1
+		if d.known[i] {
+			return nil
+		}
+
+		if err := d.setFragment(i, payload); err != nil {
+			return err
+		}
+
+		if row, ok := d.rows[i]; ok {
+			delete(d.rows, i)
+			if err := d.insert(row); err != nil {
+				return err
+			}
+		}
+	} else {
+		row := &parityRow{
+			bits: fragParityRow(n-d.nbFrag, d.nbFrag),
+			data: append([]byte(nil), payload...),
+		}
+
+		if err := d.insert(row); err != nil {
+			return err
+		}
+	}
+
+	if d.missing == 0 || d.missing > len(d.rows) {
+		d.complete = d.missing == 0
+		return nil
+	}
+
+	return d.solve()
+}
+
+func (d *fragDecoder) setFragment(i int, payload []byte) error {
+	if _, err := d.store.WriteAt(payload, int64(i*d.fragSize)); err != nil {
+		return errors.Wrap(err, "could not store fragment")
+	}
+
+	d.known[i] = true
+	d.missing--
+
+	return nil
+}
+
+func (d *fragDecoder) fragment(i int) ([]byte, error) {
+	b := make([]byte, d.fragSize)
+	if _, err := d.store.ReadAt(b, int64(i*d.fragSize)); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "could not read fragment")
+	}
+
+	return b, nil
+}
+
+// insert reduces the row by the known fragments and the existing rows, and
+// stores it if anything remains.
+func (d *fragDecoder) insert(row *parityRow) error {
+	for i := 0; i < d.nbFrag; i++ {
+		if !bitIsSet(row.bits, i) {
+			continue
+		}
+
+		if d.known[i] {
+			frag, err := d.fragment(i)
+			if err != nil {
+				return err
+			}
+			xorBytes(row.data, frag)
+			bitClear(row.bits, i)
+			continue
+		}
+
+		pivot, ok := d.rows[i]
+		if !ok {
+			d.rows[i] = row
+			return nil
+		}
+
+		for w := range row.bits {
+			row.bits[w] ^= pivot.bits[w]
+		}
+		xorBytes(row.data, pivot.data)
+	}
+
+	return nil
+}
+
+func (d *fragDecoder) solve() error {
+	for i := d.nbFrag - 1; i >= 0; i-- {
+		row, ok := d.rows[i]
+		if !ok {
+			continue
+		}
+
+		for j := i + 1; j < d.nbFrag; j++ {
+			if !bitIsSet(row.bits, j) {
+				continue
+			}
+
+			frag, err := d.fragment(j)
+			if err != nil {
+				return err
+			}
+			xorBytes(row.data, frag)
+		}
+
+		if err := d.setFragment(i, row.data); err != nil {
+			return err
+		}
+
+		delete(d.rows, i)
+	}
+
+	d.complete = true
+
+	return nil
+}
+
+// fragParityRow returns row n (starting at 1) of the parity matrix for a data
+// block of m fragments, as defined by the fragmentation specification.
+func fragParityRow(n, m int) []uint64 {
+	row := make([]uint64, (m+63)/64)
+
+	mTemp := 0
+	if m&(m-1) == 0 {
+		mTemp = 1
+	}
+
+	x := 1 + 1001*n
+	for nbCoeff := 0; nbCoeff < m/2; nbCoeff++ {
+		r := 1 << 16
+		for r >= m {
+			x = fragPRBS23(x)
+			r = x % (m + mTemp)
+		}
+		bitSet(row, r)
+	}
+
+	return row
+}
+
+func fragPRBS23(x int) int {
+	b0 := x & 1
+	b1 := (x & 32) >> 5
+	return (x >> 1) + ((b0 ^ b1) << 22)
+}
+
+func bitIsSet(bits []uint64, i int) bool {
+	return bits[i/64]&(1<<uint(i%64)) != 0
+}
+
+func bitSet(bits []uint64, i int) {
+	bits[i/64] |= 1 << uint(i%64)
+}
+
+func bitClear(bits []uint64, i int) {
+	bits[i/64] &^= 1 << uint(i%64)
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
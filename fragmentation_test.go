@@ -0,0 +1,235 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func fragSetupRequest(index uint8, nbFrag uint16, fragSize, padding uint8, descriptor uint32) []byte {
+	req := make([]byte, 11)
+	req[0] = fragSessionSetup
+	req[1] = index << 4
+	binary.LittleEndian.PutUint16(req[2:4], nbFrag)
+	req[4] = fragSize
+	req[5] = 0
+	req[6] = padding
+	binary.LittleEndian.PutUint32(req[7:11], descriptor)
+	return req
+}
+
+func fragDataRequest(index uint8, n int, payload []byte) []byte {
+	req := make([]byte, 3, 3+len(payload))
+	req[0] = fragDataFragment
+	binary.LittleEndian.PutUint16(req[1:3], uint16(n)|uint16(index)<<14)
+	return append(req, payload...)
+}
+
+// fragEncode returns fragment n (starting at 1) of the data block, which is
+// coded for n > number of fragments.
+func fragEncode(block []byte, fragSize, n int) []byte {
+	m := len(block) / fragSize
+	if n <= m {
+		return block[(n-1)*fragSize : n*fragSize]
+	}
+
+	row := fragParityRow(n-m, m)
+	frag := make([]byte, fragSize)
+	for i := 0; i < m; i++ {
+		if bitIsSet(row, i) {
+			xorBytes(frag, block[i*fragSize:(i+1)*fragSize])
+		}
+	}
+	return frag
+}
+
+func TestFragmentationReassembly(t *testing.T) {
+	const nbFrag, fragSize, padding = 20, 8, 3
+
+	block := make([]byte, nbFrag*fragSize)
+	rand.New(rand.NewSource(1)).Read(block)
+	data := block[:len(block)-padding]
+
+	for _, lost := range [][]int{{}, {2}, {1, 5, 7}, {3, 4, 5, 6, 19, 20}} {
+		var answers [][]byte
+		var completed []byte
+
+		f := newFragmentationReceiver(func(port uint8, data []byte) error {
+			answers = append(answers, data)
+			return nil
+		}, nil)
+		f.Verify = VerifyCRC32
+		f.OnComplete = func(index uint8, descriptor uint32, b []byte) {
+			completed = b
+		}
+
+		f.HandleDownlink(Downlink{Port: FragmentationPort, Data: fragSetupRequest(1, nbFrag, fragSize, padding, crc32.ChecksumIEEE(data))})
+
+		if len(answers) != 1 || !bytes.Equal(answers[0], []byte{fragSessionSetup, 1 << 6}) {
+			t.Fatalf("FragSessionSetupAns = % X; should be 02 40", answers)
+		}
+
+		skip := make(map[int]bool)
+		for _, n := range lost {
+			skip[n] = true
+		}
+
+		for n := 1; n <= 3*nbFrag && completed == nil; n++ {
+			if skip[n] {
+				continue
+			}
+			f.HandleDownlink(Downlink{Port: FragmentationPort, Data: fragDataRequest(1, n, fragEncode(block, fragSize, n))})
+		}
+
+		if !bytes.Equal(completed, data) {
+			t.Errorf("data block with lost fragments %v was not reassembled", lost)
+		}
+	}
+}
+
+func TestFragmentationIntegrityFailure(t *testing.T) {
+	completed := false
+
+	f := newFragmentationReceiver(func(port uint8, data []byte) error { return nil }, nil)
+	f.Verify = VerifyCRC32
+	f.OnComplete = func(index uint8, descriptor uint32, b []byte) { completed = true }
+
+	f.HandleDownlink(Downlink{Data: fragSetupRequest(0, 1, 4, 0, 0xDEADBEEF)})
+	f.HandleDownlink(Downlink{Data: fragDataRequest(0, 1, []byte{1, 2, 3, 4})})
+
+	if completed {
+		t.Error("OnComplete was called for a data block that failed the integrity check")
+	}
+}
+
+func TestFragmentationSetupErrors(t *testing.T) {
+	var answers [][]byte
+
+	f := newFragmentationReceiver(func(port uint8, data []byte) error {
+		answers = append(answers, data)
+		return nil
+	}, func(index uint8) FragmentStore {
+		return &MemoryFragmentStore{Capacity: 100}
+	})
+
+	f.HandleDownlink(Downlink{Data: fragSetupRequest(2, 200, 10, 0, 0)})
+
+	req := fragSetupRequest(0, 2, 10, 0, 0)
+	req[5] = 1 << 3
+	f.HandleDownlink(Downlink{Data: req})
+
+	f.HandleDownlink(Downlink{Data: []byte{fragSessionDelete, 0x03}})
+
+	expected := [][]byte{
+		{fragSessionSetup, 2<<6 | fragSetupNotEnoughMemory},
+		{fragSessionSetup, fragSetupEncodingUnsupported},
+		{fragSessionDelete, 0x03 | 1<<2},
+	}
+
+	if len(answers) != len(expected) {
+		t.Fatalf("answers = % X; should be % X", answers, expected)
+	}
+
+	for i := range expected {
+		if !bytes.Equal(answers[i], expected[i]) {
+			t.Errorf("answer %v = % X; should be % X", i, answers[i], expected[i])
+		}
+	}
+}
+
+func TestFragmentationStatus(t *testing.T) {
+	original := fragAnswerDelay
+	fragAnswerDelay = func(uint8) time.Duration { return 0 }
+	defer func() { fragAnswerDelay = original }()
+
+	answers := make(chan []byte, 4)
+
+	f := newFragmentationReceiver(func(port uint8, data []byte) error {
+		answers <- data
+		return nil
+	}, nil)
+
+	f.HandleDownlink(Downlink{Data: fragSetupRequest(0, 4, 2, 0, 0)})
+	<-answers
+
+	f.HandleDownlink(Downlink{Data: fragDataRequest(0, 1, []byte{1, 2})})
+	f.HandleDownlink(Downlink{Data: []byte{fragSessionStatus, 0x00}})
+
+	if len(answers) != 0 {
+		t.Fatal("FragSessionStatusAns sent before it was polled")
+	}
+
+	f.SendDue()
+
+	select {
+	case ans := <-answers:
+		expected := []byte{fragSessionStatus, 1, 0, 3, 0}
+		if !bytes.Equal(ans, expected) {
+			t.Errorf("FragSessionStatusAns = % X; should be % X", ans, expected)
+		}
+	default:
+		t.Error("FragSessionStatusReq was not answered while fragments are missing")
+	}
+
+	f.SendDue()
+	if len(answers) != 0 {
+		t.Error("FragSessionStatusAns sent twice")
+	}
+}
+
+func TestFragmentationStatusDelay(t *testing.T) {
+	original := fragAnswerDelay
+	fragAnswerDelay = func(uint8) time.Duration { return time.Hour }
+	defer func() { fragAnswerDelay = original }()
+
+	var answers [][]byte
+	router := NewRouter()
+	f := newFragmentationReceiver(func(port uint8, data []byte) error {
+		answers = append(answers, data)
+		return nil
+	}, nil)
+	router.Handle(FragmentationPort, f.HandleDownlink)
+	router.HandlePoll(f.SendDue)
+
+	router.Dispatch(Downlink{Port: FragmentationPort, Data: fragSetupRequest(0, 4, 2, 0, 0)})
+	router.Dispatch(Downlink{Port: FragmentationPort, Data: []byte{fragSessionStatus, 0x01}})
+	router.Poll()
+
+	if len(answers) != 1 || answers[0][0] != fragSessionSetup {
+		t.Errorf("answers = % X; should only be the FragSessionSetupAns", answers)
+	}
+
+	f.mu.Lock()
+	f.pending[0].due = time.Now()
+	f.mu.Unlock()
+	router.Poll()
+
+	if len(answers) != 2 || answers[1][0] != fragSessionStatus {
+		t.Errorf("answers = % X; should end with the FragSessionStatusAns", answers)
+	}
+}
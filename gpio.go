@@ -0,0 +1,269 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Pin is a GPIO pin of the module.
+type Pin uint8
+
+// The GPIO pins of the module
+const (
+	GPIO0 Pin = iota
+	GPIO1
+	GPIO2
+	GPIO3
+	GPIO4
+	GPIO5
+	GPIO6
+	GPIO7
+	GPIO8
+	GPIO9
+	GPIO10
+	GPIO11
+	GPIO12
+	GPIO13
+	GPIO14
+	UARTCTS
+	UARTRTS
+	TEST0
+	TEST1
+)
+
+// PinMode is the function of a GPIO pin.
+type PinMode string
+
+// The pin modes
+const (
+	PinDigitalOutput PinMode = "digout"
+	PinDigitalInput  PinMode = "digin"
+	PinAnalog        PinMode = "ana"
+)
+
+// pinNames are the names of the pins in the sys commands
+var pinNames = map[Pin]string{
+	GPIO0:   "GPIO0",
+	GPIO1:   "GPIO1",
+	GPIO2:   "GPIO2",
+	GPIO3:   "GPIO3",
+	GPIO4:   "GPIO4",
+	GPIO5:   "GPIO5",
+	GPIO6:   "GPIO6",
+	GPIO7:   "GPIO7",
+	GPIO8:   "GPIO8",
+	GPIO9:   "GPIO9",
+	GPIO10:  "GPIO10",
+	GPIO11:  "GPIO11",
+	GPIO12:  "GPIO12",
+	GPIO13:  "GPIO13",
+	GPIO14:  "GPIO14",
+	UARTCTS: "UART_CTS",
+	UARTRTS: "UART_RTS",
+	TEST0:   "TEST0",
+	TEST1:   "TEST1",
+}
+
+func (p Pin) String() string {
+	if name, ok := pinNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Pin(%d)", uint8(p))
+}
+
+// Analog reports whether the pin has an analog input,
+// which are GPIO0-GPIO3 and GPIO5-GPIO13.
+func (p Pin) Analog() bool {
+	return p <= GPIO13 && p != GPIO4
+}
+
+// SetPinMode configures the pin as digital output, digital input or analog input.
+func SetPinMode(pin Pin, mode PinMode) error {
+	if _, ok := pinNames[pin]; !ok {
+		return errors.Errorf("invalid pin %v", pin)
+	}
+
+	switch mode {
+	case PinDigitalOutput, PinDigitalInput:
+	case PinAnalog:
+		if !pin.Analog() {
+			return errors.Errorf("%v has no analog input", pin)
+		}
+	default:
+		return errors.Errorf("invalid pin mode %v", mode)
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("sys set pinmode %v %s", pin, mode))
+	if err != nil {
+		return errors.Wrap(err, "could not set pin mode")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set pin mode: invalid parameter")
+	}
+
+	return nil
+}
+
+// WriteDigitalPin sets the level of a pin configured as digital output.
+func WriteDigitalPin(pin Pin, high bool) error {
+	if _, ok := pinNames[pin]; !ok {
+		return errors.Errorf("invalid pin %v", pin)
+	}
+
+	level := 0
+	if high {
+		level = 1
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("sys set pindig %v %v", pin, level))
+	if err != nil {
+		return errors.Wrap(err, "could not set pin")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set pin: invalid parameter")
+	}
+
+	return nil
+}
+
+// ReadDigitalPin returns the level of a digital pin.
+func ReadDigitalPin(pin Pin) (bool, error) {
+	if _, ok := pinNames[pin]; !ok {
+		return false, errors.Errorf("invalid pin %v", pin)
+	}
+
+	answer, err := query(fmt.Sprintf("sys get pindig %v", pin))
+	if err != nil {
+		return false, errors.Wrap(err, "could not get pin")
+	}
+
+	switch answer {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+
+	return false, errors.Errorf("could not get pin: invalid answer %s", answer)
+}
+
+// ReadAnalogPin returns the value of an analog input, between [0, 1023].
+func ReadAnalogPin(pin Pin) (uint16, error) {
+	if !pin.Analog() {
+		return 0, errors.Errorf("%v has no analog input", pin)
+	}
+
+	answer, err := query(fmt.Sprintf("sys get pinana %v", pin))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get analog pin")
+	}
+
+	value, err := strconv.ParseUint(answer, 10, 16)
+	if err != nil || value > 1023 {
+		return 0, errors.Errorf("could not get analog pin: invalid answer %s", answer)
+	}
+
+	return uint16(value), nil
+}
+
+// PinEvent reports a change of a digital input.
+type PinEvent struct {
+	Pin  Pin
+	High bool
+	Time time.Time
+}
+
+// PinWatcher polls digital inputs and reports their changes.
+type PinWatcher struct {
+	// OnChange is called for every change of a watched pin
+	OnChange func(e PinEvent)
+	interval time.Duration
+	pins     []Pin
+
+	mu     sync.Mutex
+	levels map[Pin]bool
+}
+
+// NewPinWatcher returns a watcher polling the pins at the interval.
+func NewPinWatcher(interval time.Duration, pins ...Pin) *PinWatcher {
+	return &PinWatcher{
+		interval: interval,
+		pins:     pins,
+		levels:   make(map[Pin]bool),
+	}
+}
+
+// Poll reads the watched pins once and reports the changes. The first read
+// of a pin only sets its level.
+func (w *PinWatcher) Poll() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, pin := range w.pins {
+		high, err := ReadDigitalPin(pin)
+		if err != nil {
+			return errors.Wrapf(err, "could not poll %v", pin)
+		}
+
+		last, known := w.levels[pin]
+		w.levels[pin] = high
+
+		if known && last != high && w.OnChange != nil {
+			w.OnChange(PinEvent{Pin: pin, High: high, Time: time.Now()})
+		}
+	}
+
+	return nil
+}
+
+// Run polls the pins until the stop channel is closed. It fails when the
+// watcher has no positive interval.
+func (w *PinWatcher) Run(stop <-chan struct{}) error {
+	if w.interval <= 0 {
+		return errors.Errorf("invalid pin watcher interval %v", w.interval)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Poll(); err != nil {
+			WARN.Println("pin watcher error:", err)
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSetPinMode(t *testing.T) {
+	defer resetOriginals()
+	written := mockSerial(t, nil)
+
+	if err := SetPinMode(UARTCTS, PinDigitalOutput); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPinMode(GPIO5, PinAnalog); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"sys set pinmode UART_CTS digout", "sys set pinmode GPIO5 ana"}
+	if fmt.Sprint(*written) != fmt.Sprint(expected) {
+		t.Errorf("commands = %v; should be %v", *written, expected)
+	}
+
+	*written = nil
+	for _, pin := range []Pin{GPIO4, GPIO14, TEST0, UARTRTS} {
+		if err := SetPinMode(pin, PinAnalog); err == nil {
+			t.Errorf("SetPinMode(%v, PinAnalog) succeeded", pin)
+		}
+	}
+	if err := SetPinMode(TEST1+1, PinDigitalInput); err == nil {
+		t.Error("SetPinMode accepted an invalid pin")
+	}
+	if err := SetPinMode(GPIO0, "pwm"); err == nil {
+		t.Error("SetPinMode accepted an invalid mode")
+	}
+	if len(*written) != 0 {
+		t.Errorf("commands = %v; should be none", *written)
+	}
+}
+
+func TestDigitalPin(t *testing.T) {
+	defer resetOriginals()
+	written := mockSerial(t, map[string]string{"sys get pindig GPIO2": "1", "sys get pindig GPIO3": "2"})
+
+	if err := WriteDigitalPin(GPIO10, true); err != nil {
+		t.Fatal(err)
+	}
+	if (*written)[0] != "sys set pindig GPIO10 1" {
+		t.Errorf("command = %v; should be sys set pindig GPIO10 1", (*written)[0])
+	}
+
+	if high, err := ReadDigitalPin(GPIO2); err != nil || !high {
+		t.Errorf("ReadDigitalPin(GPIO2) = %v, %v; should be true", high, err)
+	}
+	if _, err := ReadDigitalPin(GPIO3); err == nil {
+		t.Error("ReadDigitalPin accepted an invalid answer")
+	}
+}
+
+func TestReadAnalogPin(t *testing.T) {
+	defer resetOriginals()
+	mockSerial(t, map[string]string{"sys get pinana GPIO0": "512", "sys get pinana GPIO1": "2048"})
+
+	if value, err := ReadAnalogPin(GPIO0); err != nil || value != 512 {
+		t.Errorf("ReadAnalogPin(GPIO0) = %v, %v; should be 512", value, err)
+	}
+	if _, err := ReadAnalogPin(GPIO1); err == nil {
+		t.Error("ReadAnalogPin accepted a value out of range")
+	}
+	if _, err := ReadAnalogPin(GPIO4); err == nil {
+		t.Error("ReadAnalogPin accepted a digital pin")
+	}
+}
+
+func TestPinWatcher(t *testing.T) {
+	defer resetOriginals()
+	answers := map[string]string{"sys get pindig GPIO7": "0", "sys get pindig GPIO8": "1"}
+	mockSerial(t, answers)
+
+	var events []PinEvent
+	w := NewPinWatcher(0, GPIO7, GPIO8)
+	w.OnChange = func(e PinEvent) { events = append(events, e) }
+
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %v after the first poll; should be none", events)
+	}
+
+	answers["sys get pindig GPIO7"] = "1"
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].Pin != GPIO7 || !events[0].High {
+		t.Errorf("events = %+v; should be GPIO7 going high", events)
+	}
+
+	if err := w.Run(make(chan struct{})); err == nil {
+		t.Error("Run() accepted a zero interval")
+	}
+}
@@ -0,0 +1,199 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The Intel HEX record types
+const (
+	hexData                   = 0x00
+	hexEndOfFile              = 0x01
+	hexExtendedSegmentAddress = 0x02
+	hexStartSegmentAddress    = 0x03
+	hexExtendedLinearAddress  = 0x04
+	hexStartLinearAddress     = 0x05
+)
+
+// FirmwareSegment is a contiguous block of a firmware image.
+type FirmwareSegment struct {
+	Address uint32
+	Data    []byte
+}
+
+// FirmwareImage is a firmware image as a sorted list of segments.
+type FirmwareImage struct {
+	Segments []FirmwareSegment
+}
+
+// ParseIntelHex reads a firmware image in the Intel HEX format.
+func ParseIntelHex(r io.Reader) (*FirmwareImage, error) {
+	memory := make(map[uint32]byte)
+	var base uint32
+	eof := false
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if eof {
+			return nil, errors.Errorf("line %v: data after end of file record", line)
+		}
+
+		if text[0] != ':' {
+			return nil, errors.Errorf("line %v: missing start code", line)
+		}
+
+		record, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %v", line)
+		}
+
+		if len(record) < 5 || len(record) != int(record[0])+5 {
+			return nil, errors.Errorf("line %v: invalid record length", line)
+		}
+
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, errors.Errorf("line %v: invalid checksum", line)
+		}
+
+		address := uint32(record[1])<<8 | uint32(record[2])
+		data := record[4 : len(record)-1]
+
+		switch record[3] {
+		case hexData:
+			for i, b := range data {
+				memory[base+address+uint32(i)] = b
+			}
+		case hexEndOfFile:
+			eof = true
+		case hexExtendedSegmentAddress:
+			if len(data) != 2 {
+				return nil, errors.Errorf("line %v: invalid extended segment address", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case hexExtendedLinearAddress:
+			if len(data) != 2 {
+				return nil, errors.Errorf("line %v: invalid extended linear address", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case hexStartSegmentAddress, hexStartLinearAddress:
+			// the start address is not used by the bootloader
+		default:
+			return nil, errors.Errorf("line %v: unknown record type %v", line, record[3])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read firmware image")
+	}
+
+	if !eof {
+		return nil, errors.New("missing end of file record")
+	}
+
+	return newFirmwareImage(memory), nil
+}
+
+// newFirmwareImage groups the bytes in contiguous segments.
+func newFirmwareImage(memory map[uint32]byte) *FirmwareImage {
+	addresses := make([]int, 0, len(memory))
+	for address := range memory {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+
+	img := &FirmwareImage{}
+	for _, a := range addresses {
+		address := uint32(a)
+		n := len(img.Segments)
+
+		if n > 0 {
+			last := &img.Segments[n-1]
+			if last.Address+uint32(len(last.Data)) == address {
+				last.Data = append(last.Data, memory[address])
+				continue
+			}
+		}
+
+		img.Segments = append(img.Segments, FirmwareSegment{Address: address, Data: []byte{memory[address]}})
+	}
+
+	return img
+}
+
+// Size returns the number of bytes in the image.
+func (img *FirmwareImage) Size() int {
+	size := 0
+	for _, s := range img.Segments {
+		size += len(s.Data)
+	}
+
+	return size
+}
+
+// Blocks returns the image in blocks of the given size, aligned on the size
+// and padded with 0xFF, limited to the addresses in [start, end).
+func (img *FirmwareImage) Blocks(size int, start, end uint32) []FirmwareSegment {
+	var blocks []FirmwareSegment
+
+	for _, s := range img.Segments {
+		for i, b := range s.Data {
+			address := s.Address + uint32(i)
+			if address < start || address >= end {
+				continue
+			}
+
+			aligned := address - address%uint32(size)
+			n := len(blocks)
+
+			if n == 0 || blocks[n-1].Address != aligned {
+				data := make([]byte, size)
+				for j := range data {
+					data[j] = 0xFF
+				}
+
+				blocks = append(blocks, FirmwareSegment{Address: aligned, Data: data})
+				n++
+			}
+
+			blocks[n-1].Data[address-aligned] = b
+		}
+	}
+
+	return blocks
+}
@@ -0,0 +1,302 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// The Cayenne LPP data types (IPSO object ID - 3200)
+const (
+	LPPDigitalInput  = uint8(0)
+	LPPDigitalOutput = uint8(1)
+	LPPAnalogInput   = uint8(2)
+	LPPAnalogOutput  = uint8(3)
+	LPPGenericSensor = uint8(100)
+	LPPIlluminance   = uint8(101)
+	LPPPresence      = uint8(102)
+	LPPTemperature   = uint8(103)
+	LPPHumidity      = uint8(104)
+	LPPAccelerometer = uint8(113)
+	LPPBarometer     = uint8(115)
+	LPPVoltage       = uint8(116)
+	LPPCurrent       = uint8(117)
+	LPPFrequency     = uint8(118)
+	LPPPercentage    = uint8(120)
+	LPPAltitude      = uint8(121)
+	LPPConcentration = uint8(125)
+	LPPPower         = uint8(128)
+	LPPDistance      = uint8(130)
+	LPPEnergy        = uint8(131)
+	LPPDirection     = uint8(132)
+	LPPUnixTime      = uint8(133)
+	LPPGyrometer     = uint8(134)
+	LPPColour        = uint8(135)
+	LPPGPS           = uint8(136)
+	LPPSwitch        = uint8(142)
+)
+
+// lppType describes how the values of a data type are encoded. Every value
+// is stored big endian in size bytes, after being multiplied by its
+// multiplier.
+type lppType struct {
+	name        string
+	size        int
+	multipliers []float64
+	signed      bool
+}
+
+var lppTypes = map[uint8]lppType{
+	LPPDigitalInput:  {"digital_input", 1, []float64{1}, false},
+	LPPDigitalOutput: {"digital_output", 1, []float64{1}, false},
+	LPPAnalogInput:   {"analog_input", 2, []float64{100}, true},
+	LPPAnalogOutput:  {"analog_output", 2, []float64{100}, true},
+	LPPGenericSensor: {"generic_sensor", 4, []float64{1}, false},
+	LPPIlluminance:   {"illuminance", 2, []float64{1}, false},
+	LPPPresence:      {"presence", 1, []float64{1}, false},
+	LPPTemperature:   {"temperature", 2, []float64{10}, true},
+	LPPHumidity:      {"humidity", 1, []float64{2}, false},
+	LPPAccelerometer: {"accelerometer", 2, []float64{1000, 1000, 1000}, true},
+	LPPBarometer:     {"barometer", 2, []float64{10}, false},
+	LPPVoltage:       {"voltage", 2, []float64{100}, false},
+	LPPCurrent:       {"current", 2, []float64{1000}, false},
+	LPPFrequency:     {"frequency", 4, []float64{1}, false},
+	LPPPercentage:    {"percentage", 1, []float64{1}, false},
+	LPPAltitude:      {"altitude", 2, []float64{1}, true},
+	LPPConcentration: {"concentration", 2, []float64{1}, false},
+	LPPPower:         {"power", 2, []float64{1}, false},
+	LPPDistance:      {"distance", 4, []float64{1000}, false},
+	LPPEnergy:        {"energy", 4, []float64{1000}, false},
+	LPPDirection:     {"direction", 2, []float64{1}, false},
+	LPPUnixTime:      {"unix_time", 4, []float64{1}, false},
+	LPPGyrometer:     {"gyrometer", 2, []float64{100, 100, 100}, true},
+	LPPColour:        {"colour", 1, []float64{1, 1, 1}, false},
+	LPPGPS:           {"gps", 3, []float64{10000, 10000, 100}, true},
+	LPPSwitch:        {"switch", 1, []float64{1}, false},
+}
+
+// LPP builds a Cayenne Low Power Payload frame.
+type LPP struct {
+	buf []byte
+}
+
+// LPPValue is a single decoded Cayenne LPP data item.
+type LPPValue struct {
+	Channel uint8
+	Type    uint8
+	Name    string
+	Values  []float64
+}
+
+// NewLPP returns an empty Cayenne LPP frame.
+func NewLPP() *LPP {
+	return &LPP{}
+}
+
+// Reset clears the frame.
+func (l *LPP) Reset() {
+	l.buf = l.buf[:0]
+}
+
+// Bytes returns the encoded frame.
+func (l *LPP) Bytes() []byte {
+	return l.buf
+}
+
+// Len returns the size of the encoded frame in bytes.
+func (l *LPP) Len() int {
+	return len(l.buf)
+}
+
+// Add appends a data item of the given type to the frame. The number of
+// values has to match the type (3 for the accelerometer, gyrometer, colour
+// and GPS types, 1 for the others). Values that don't fit in the type's
+// encoding are rejected.
+func (l *LPP) Add(channel uint8, dataType uint8, values ...float64) error {
+	t, ok := lppTypes[dataType]
+	if !ok {
+		return errors.Errorf("unknown lpp type %v", dataType)
+	}
+
+	if len(values) != len(t.multipliers) {
+		return errors.Errorf("lpp %s takes %v values, got %v", t.name, len(t.multipliers), len(values))
+	}
+
+	item := []byte{channel, dataType}
+
+	for i, v := range values {
+		raw := round(v * t.multipliers[i])
+
+		bits := uint(t.size * 8)
+		min, max := 0.0, math.Exp2(float64(bits))-1
+		if t.signed {
+			min, max = -math.Exp2(float64(bits-1)), math.Exp2(float64(bits-1))-1
+		}
+
+		if raw < min || raw > max {
+			return errors.Errorf("lpp %s value %v out of range", t.name, v)
+		}
+
+		u := uint64(int64(raw))
+		for b := t.size - 1; b >= 0; b-- {
+			item = append(item, byte(u>>(uint(b)*8)))
+		}
+	}
+
+	l.buf = append(l.buf, item...)
+
+	return nil
+}
+
+// AddDigitalInput appends a digital input value.
+func (l *LPP) AddDigitalInput(channel uint8, value uint8) error {
+	return l.Add(channel, LPPDigitalInput, float64(value))
+}
+
+// AddDigitalOutput appends a digital output value.
+func (l *LPP) AddDigitalOutput(channel uint8, value uint8) error {
+	return l.Add(channel, LPPDigitalOutput, float64(value))
+}
+
+// AddAnalogInput appends an analog input value (0.01 resolution).
+func (l *LPP) AddAnalogInput(channel uint8, value float64) error {
+	return l.Add(channel, LPPAnalogInput, value)
+}
+
+// AddAnalogOutput appends an analog output value (0.01 resolution).
+func (l *LPP) AddAnalogOutput(channel uint8, value float64) error {
+	return l.Add(channel, LPPAnalogOutput, value)
+}
+
+// AddIlluminance appends an illuminance value in lux.
+func (l *LPP) AddIlluminance(channel uint8, lux uint16) error {
+	return l.Add(channel, LPPIlluminance, float64(lux))
+}
+
+// AddPresence appends a presence value.
+func (l *LPP) AddPresence(channel uint8, value uint8) error {
+	return l.Add(channel, LPPPresence, float64(value))
+}
+
+// AddTemperature appends a temperature in °C (0.1 °C resolution).
+func (l *LPP) AddTemperature(channel uint8, celsius float64) error {
+	return l.Add(channel, LPPTemperature, celsius)
+}
+
+// AddRelativeHumidity appends a relative humidity in % (0.5 % resolution).
+func (l *LPP) AddRelativeHumidity(channel uint8, rh float64) error {
+	return l.Add(channel, LPPHumidity, rh)
+}
+
+// AddAccelerometer appends an acceleration in G per axis (0.001 G resolution).
+func (l *LPP) AddAccelerometer(channel uint8, x, y, z float64) error {
+	return l.Add(channel, LPPAccelerometer, x, y, z)
+}
+
+// AddBarometricPressure appends a pressure in hPa (0.1 hPa resolution).
+func (l *LPP) AddBarometricPressure(channel uint8, hpa float64) error {
+	return l.Add(channel, LPPBarometer, hpa)
+}
+
+// AddGyrometer appends a rotation in °/s per axis (0.01 °/s resolution).
+func (l *LPP) AddGyrometer(channel uint8, x, y, z float64) error {
+	return l.Add(channel, LPPGyrometer, x, y, z)
+}
+
+// AddGPS appends a location, with the latitude and longitude in degrees
+// (0.0001° resolution) and the altitude in meters (0.01 m resolution).
+func (l *LPP) AddGPS(channel uint8, latitude, longitude, altitude float64) error {
+	return l.Add(channel, LPPGPS, latitude, longitude, altitude)
+}
+
+// DecodeLPP decodes a Cayenne LPP frame, for example received in a downlink.
+func DecodeLPP(data []byte) ([]LPPValue, error) {
+	var values []LPPValue
+
+	for i := 0; i < len(data); {
+		if len(data)-i < 2 {
+			return values, errors.New("lpp frame truncated")
+		}
+
+		channel, dataType := data[i], data[i+1]
+		i += 2
+
+		t, ok := lppTypes[dataType]
+		if !ok {
+			return values, errors.Errorf("unknown lpp type %v", dataType)
+		}
+
+		if len(data)-i < t.size*len(t.multipliers) {
+			return values, errors.Errorf("lpp %s truncated", t.name)
+		}
+
+		v := LPPValue{Channel: channel, Type: dataType, Name: t.name}
+
+		for _, m := range t.multipliers {
+			var u uint64
+			for b := 0; b < t.size; b++ {
+				u = u<<8 | uint64(data[i+b])
+			}
+			i += t.size
+
+			raw := int64(u)
+			if t.signed && u&(1<<uint(t.size*8-1)) != 0 {
+				raw -= 1 << uint(t.size*8)
+			}
+
+			v.Values = append(v.Values, float64(raw)/m)
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+// String returns a readable representation of the value.
+func (v LPPValue) String() string {
+	return fmt.Sprintf("%s[%v]=%v", v.Name, v.Channel, v.Values)
+}
+
+// MacTxLPP transmits the LPP frame on the given port like MacTx does.
+// The size of the frame is checked against the maximum payload of the
+// current data rate first.
+func MacTxLPP(confirmed bool, port uint8, lpp *LPP, callback receiveCallback) error {
+	if lpp == nil || lpp.Len() == 0 {
+		return errors.New("empty lpp frame")
+	}
+
+	dr := MacGetDataRate()
+	if max, ok := region.MaxPayload(dr); ok && lpp.Len() > max {
+		return errors.Errorf("lpp frame of %v bytes exceeds the maximum of %v bytes for data rate %v", lpp.Len(), max, dr)
+	}
+
+	if !MacTx(confirmed, port, lpp.Bytes(), callback) {
+		return errors.New("could not transmit lpp frame")
+	}
+
+	return nil
+}
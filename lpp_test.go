@@ -0,0 +1,140 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+func TestLPPEncode(t *testing.T) {
+	lpp := NewLPP()
+
+	if err := lpp.AddTemperature(3, 27.2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lpp.AddGPS(1, 42.3519, -87.9094, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lpp.AddAccelerometer(6, 1.234, -1.234, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := hex.DecodeString("03670110" + "018806765ff2960a0003e8" + "067104d2fb2e0000")
+	if !bytes.Equal(lpp.Bytes(), expected) {
+		t.Errorf("LPP.Bytes() = %X; should be %X", lpp.Bytes(), expected)
+	}
+
+	lpp.Reset()
+	if lpp.Len() != 0 {
+		t.Errorf("LPP.Len() = %v after Reset(); should be 0", lpp.Len())
+	}
+}
+
+func TestLPPEncodeOutOfRange(t *testing.T) {
+	lpp := NewLPP()
+
+	if lpp.AddRelativeHumidity(1, 200) == nil {
+		t.Error("AddRelativeHumidity(1, 200) returned no error while the value is out of range")
+	}
+
+	if lpp.Add(1, LPPGPS, 1) == nil {
+		t.Error("Add() returned no error with the wrong number of values")
+	}
+
+	if lpp.Add(1, 255, 1) == nil {
+		t.Error("Add() returned no error with an unknown type")
+	}
+
+	if lpp.Len() != 0 {
+		t.Errorf("LPP.Len() = %v; failed items should not be added", lpp.Len())
+	}
+}
+
+func TestDecodeLPP(t *testing.T) {
+	data, _ := hex.DecodeString("03670110056700ff" + "018806765ff2960a0003e8")
+
+	values, err := DecodeLPP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []LPPValue{
+		{Channel: 3, Type: LPPTemperature, Values: []float64{27.2}},
+		{Channel: 5, Type: LPPTemperature, Values: []float64{25.5}},
+		{Channel: 1, Type: LPPGPS, Values: []float64{42.3519, -87.9094, 10}},
+	}
+
+	if len(values) != len(expected) {
+		t.Fatalf("DecodeLPP() returned %v values; should be %v", len(values), len(expected))
+	}
+
+	for i, e := range expected {
+		v := values[i]
+		if v.Channel != e.Channel || v.Type != e.Type || len(v.Values) != len(e.Values) {
+			t.Errorf("DecodeLPP() value %v = %v; should be %v", i, v, e)
+			continue
+		}
+
+		for j := range e.Values {
+			if math.Abs(v.Values[j]-e.Values[j]) > 1e-9 {
+				t.Errorf("DecodeLPP() value %v = %v; should be %v", i, v, e)
+			}
+		}
+	}
+}
+
+func TestDecodeLPPTruncated(t *testing.T) {
+	data, _ := hex.DecodeString("036701")
+
+	if _, err := DecodeLPP(data); err == nil {
+		t.Error("DecodeLPP() returned no error with a truncated frame")
+	}
+}
+
+func TestMacTxLPPTooLarge(t *testing.T) {
+	serialWrite = func(s string) error {
+		t.Logf("String written to serial: %v", s)
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		b := []byte("0\r\n")
+		return len(b), b
+	}
+
+	defer resetOriginals()
+
+	lpp := NewLPP()
+	for i := 0; i < 6; i++ {
+		lpp.AddGPS(uint8(i), 0, 0, 0)
+	}
+
+	if err := MacTxLPP(false, 1, lpp, nil); err == nil {
+		t.Errorf("MacTxLPP() returned no error with %v bytes on data rate 0", lpp.Len())
+	}
+}
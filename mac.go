@@ -34,26 +34,37 @@ import (
 type receiveCallback func(port uint8, data []byte)
 
 // MacReset will automatically reset the software LoRaWAN stack and initilize
-// it with the parameters for the selected band.
+// it with the parameters for the selected band. The region of the band
+// becomes the current region.
+// The RN2903 only supports the 915 band and takes no band argument.
 func MacReset(band uint16) bool {
-	if band != 433 && band != 868 {
-		WARN.Println("mac reset error: invalid band selected (433 or 868)")
+	r := regionForBand(band)
+	if r == nil {
+		WARN.Println("mac reset error: invalid band selected:", band)
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("mac reset %v", band))
+	cmd := fmt.Sprintf("mac reset %v", band)
+	if module == ModuleRN2903 {
+		cmd = "mac reset"
+	}
+
+	n, answer, err := exchange(cmd)
 	if err != nil {
 		WARN.Println("mac reset error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("mac reset error: invalid parameter")
 		return false
 	}
 
-	//state.macPaused = false
+	SetRegion(r)
+
+	state.joined = false
+	state.macPaused = false
+	state.macIdle = true
 
 	return true
 }
@@ -62,13 +73,12 @@ func MacReset(band uint16) bool {
 // The length is the time in milliseconds the stack will be paused, with a maximum of 4294967295
 // (max of uint32), is returned as an uint32.
 func MacPause() uint32 {
-	err := serialWrite("mac pause")
+	n, answer, err := exchange("mac pause")
 	if err != nil {
 		WARN.Println("mac pause error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("mac pause error: invalid parameter")
 		return 0
@@ -80,8 +90,8 @@ func MacPause() uint32 {
 		return 0
 	}
 
-	//state.macPaused = true
-	//state.macPausedEnd = time.Now().Add(time.Duration(value) * time.Millisecond)
+	state.macPaused = true
+	state.macPausedEnd = time.Now().Add(time.Duration(value) * time.Millisecond)
 
 	return uint32(value)
 }
@@ -89,35 +99,44 @@ func MacPause() uint32 {
 // MacResume will resume the LoRaWAN stack functionality, in order to continue normal
 // functionality after being paused.
 func MacResume() bool {
-	err := serialWrite("mac resume")
+	n, answer, err := exchange("mac resume")
 	if err != nil {
 		WARN.Println("mac resume error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("mac resume error: invalid parameter")
 		return false
 	}
 
-	//state.macPaused = false
+	state.macPaused = false
 
 	return true
 }
 
-// The length is passed in milliseconds.
-//func isMacPaused(length int) bool {
-//	// An offset of 100 milliseconds is added to ensure we have enough
-//	// time left over
-//	d := time.Duration(length)*time.Millisecond + time.Duration(100)
-//
-//	if state.macPaused {
-//		return time.Now().Add(d).Before(state.macPausedEnd)
-//	}
-//
-//	return false
-//}
+// macPauseOffset is the margin in milliseconds a pause must last beyond
+// the length passed to isMacPaused.
+const macPauseOffset = 100
+
+// isMacPaused returns whether the LoRaWAN stack stays paused for the length,
+// which is passed in milliseconds.
+func isMacPaused(length int) bool {
+	// An offset is added to ensure we have enough time left over
+	d := time.Duration(uint64(length)+macPauseOffset) * time.Millisecond
+
+	if state.macPaused {
+		return time.Now().Add(d).Before(state.macPausedEnd)
+	}
+
+	return false
+}
+
+// Joined returns whether the LoRaWAN session was joined with MacJoin.
+// A reset of the module or the LoRaWAN stack ends the session.
+func Joined() bool {
+	return state.joined
+}
 
 // MacJoin will join the configured network with the given mode.
 func MacJoin(mode string) bool {
@@ -126,6 +145,11 @@ func MacJoin(mode string) bool {
 		return false
 	}
 
+	lockSerial()
+	defer unlockSerial()
+
+	state.macIdle = false
+
 	err := serialWrite(fmt.Sprintf("mac join %s", mode))
 	if err != nil {
 		WARN.Println("mac join error:", err)
@@ -154,6 +178,8 @@ func MacJoin(mode string) bool {
 					return false
 				}
 
+				state.joined = true
+
 				return true
 			}
 		}
@@ -185,6 +211,14 @@ func MacTx(confirmed bool, port uint8, data []byte, callback receiveCallback) bo
 		uplinkType = CONFIRMED
 	}
 
+	lockSerial()
+	locked := true
+	defer func() {
+		if locked {
+			unlockSerial()
+		}
+	}()
+
 	err := serialWrite(fmt.Sprintf("mac tx %s %v %X", uplinkType, port, data))
 	if err != nil {
 		WARN.Println("mac tx error:", err)
@@ -217,21 +251,17 @@ func MacTx(confirmed bool, port uint8, data []byte, callback receiveCallback) bo
 					return true
 				} else if strings.HasPrefix(s, "mac_rx") {
 					if callback != nil {
-						params := strings.Split(s, " ")
-
-						port, err := strconv.ParseInt(params[1], 10, 8)
+						port, decoded, err := parseMacRx(s)
 						if err != nil {
-							WARN.Printf("mac_rx invalid port: %s", params[1])
+							WARN.Println("mac_rx error:", err)
 							return true
 						}
 
-						decoded, err := hex.DecodeString(params[2])
-						if err != nil {
-							WARN.Printf("mac_rx invalid hex data: %s", params[2])
-							return true
-						}
+						// the callback may issue commands of its own
+						locked = false
+						unlockSerial()
 
-						callback(uint8(port), []byte(decoded))
+						callback(port, decoded)
 					}
 					return true
 				} else {
@@ -242,17 +272,40 @@ func MacTx(confirmed bool, port uint8, data []byte, callback receiveCallback) bo
 	}
 }
 
+// parseMacRx splits a "mac_rx <port> <data>" answer into the port number
+// and the decoded payload.
+func parseMacRx(s string) (uint8, []byte, error) {
+	params := strings.Fields(s)
+	if len(params) < 2 || params[0] != "mac_rx" {
+		return 0, nil, errors.Errorf("invalid answer: %s", s)
+	}
+
+	port, err := strconv.ParseUint(params[1], 10, 8)
+	if err != nil {
+		return 0, nil, errors.Errorf("invalid port: %s", params[1])
+	}
+
+	var decoded []byte
+	if len(params) > 2 {
+		decoded, err = hex.DecodeString(params[2])
+		if err != nil {
+			return 0, nil, errors.Errorf("invalid hex data: %s", params[2])
+		}
+	}
+
+	return uint8(port), decoded, nil
+}
+
 // MacGetDeviceAddress will return the current end device address of the module.
 // The address is represented as a 4-byte hexadecimal number and returned as a string.
 // The default value of 00000000 will be returned in case of an error.
 func MacGetDeviceAddress() string {
-	err := serialWrite("mac get devaddr")
+	n, answer, err := exchange("mac get devaddr")
 	if err != nil {
 		WARN.Println("mac get devaddr error:", err)
 		return "00000000"
 	}
 
-	n, answer := serialRead()
 	if n != 0 {
 		return string(sanitize(answer))
 	}
@@ -267,12 +320,11 @@ func MacSetDeviceAddress(address string) error {
 		return errors.New("invalid address length")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set devaddr %s", address))
+	n, answer, err := exchange(fmt.Sprintf("mac set devaddr %s", address))
 	if err != nil {
 		return errors.Wrap(err, "could not set device address")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set device address: invalid parameter")
 	}
@@ -284,13 +336,12 @@ func MacSetDeviceAddress(address string) error {
 // The EUI is represented as a 8-byte hexadecimal number and returned as a string.
 // The default value of 0000000000000000 will be returned in case of an error.
 func MacGetDeviceEUI() string {
-	err := serialWrite("mac get deveui")
+	n, answer, err := exchange("mac get deveui")
 	if err != nil {
 		WARN.Println("mac get deveui error:", err)
 		return "0000000000000000"
 	}
 
-	n, answer := serialRead()
 	if n != 0 {
 		return string(sanitize(answer))
 	}
@@ -305,12 +356,11 @@ func MacSetDeviceEUI(eui string) error {
 		return errors.New("invalid eui length")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set deveui %s", eui))
+	n, answer, err := exchange(fmt.Sprintf("mac set deveui %s", eui))
 	if err != nil {
 		return errors.Wrap(err, "could not set device eui")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set device eui: invalid parameter")
 	}
@@ -322,13 +372,12 @@ func MacSetDeviceEUI(eui string) error {
 // The EUI is represented as a 8-byte hexadecimal number and returned as a string.
 // The default value of 0000000000000000 will be returned in case of an error.
 func MacGetApplicationEUI() string {
-	err := serialWrite("mac get appeui")
+	n, answer, err := exchange("mac get appeui")
 	if err != nil {
 		WARN.Println("mac get appeui error:", err)
 		return "0000000000000000"
 	}
 
-	n, answer := serialRead()
 	if n != 0 {
 		return string(sanitize(answer))
 	}
@@ -343,12 +392,11 @@ func MacSetApplicationEUI(eui string) error {
 		return errors.New("invalid eui length")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set appeui %s", eui))
+	n, answer, err := exchange(fmt.Sprintf("mac set appeui %s", eui))
 	if err != nil {
 		return errors.Wrap(err, "could not set application eui")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set application eui: invalid parameter")
 	}
@@ -363,12 +411,11 @@ func MacSetNetworkSessionKey(key string) error {
 		return errors.New("invalid key length")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set nwkskey %s", key))
+	n, answer, err := exchange(fmt.Sprintf("mac set nwkskey %s", key))
 	if err != nil {
 		return errors.Wrap(err, "could not set network session key")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set network session key: invalid parameter")
 	}
@@ -383,12 +430,11 @@ func MacSetApplicationSessionKey(key string) error {
 		return errors.New("invalid key length")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set appskey %s", key))
+	n, answer, err := exchange(fmt.Sprintf("mac set appskey %s", key))
 	if err != nil {
 		return errors.Wrap(err, "could not set application session key")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set application session key: invalid parameter")
 	}
@@ -403,12 +449,11 @@ func MacSetApplicationKey(key string) error {
 		return errors.New("invalid key length")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set appkey %s", key))
+	n, answer, err := exchange(fmt.Sprintf("mac set appkey %s", key))
 	if err != nil {
 		return errors.Wrap(err, "could not set application key")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set application key: invalid parameter")
 	}
@@ -420,13 +465,12 @@ func MacSetApplicationKey(key string) error {
 // The data rate is a number in the range of [0-5],
 // with 0 = SF12BW125 and 5 = SF7BW125.
 func MacGetDataRate() uint8 {
-	err := serialWrite("mac get dr")
+	n, answer, err := exchange("mac get dr")
 	if err != nil {
 		WARN.Println("mac get dr error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n != 0 {
 		dr, err := strconv.ParseUint(string(sanitize(answer)), 10, 8)
 		if err != nil {
@@ -441,19 +485,18 @@ func MacGetDataRate() uint8 {
 }
 
 // MacSetDataRate will configure the data rate for the next transmission.
-// The data rate has to be in the range of [0-5],
-// with 0 = SF12BW125 and 5 = SF7BW125.
+// The data rate has to exist in the current region,
+// for EU868 with 0 = SF12BW125 and 5 = SF7BW125.
 func MacSetDataRate(dr uint8) error {
-	if dr > 5 {
+	if !region.ValidDataRate(dr) {
 		return errors.New("invalid data rate")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set dr %v", dr))
+	n, answer, err := exchange(fmt.Sprintf("mac set dr %v", dr))
 	if err != nil {
 		return errors.Wrap(err, "could not set data rate")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set data rate: invalid parameter")
 	}
@@ -466,13 +509,12 @@ func MacSetDataRate(dr uint8) error {
 // with 0 = 20 dBm (if available), 1 = 14 dBm, 2 = 11 dBm,
 // 3 = 8 dBm, 4 = 5dBm and 5 = 2 dBm.
 func MacGetPowerIndex() uint8 {
-	err := serialWrite("mac get pwridx")
+	n, answer, err := exchange("mac get pwridx")
 	if err != nil {
 		WARN.Println("mac get pwridx error:", err)
 		return 1
 	}
 
-	n, answer := serialRead()
 	if n != 0 {
 		pwr, err := strconv.ParseUint(string(sanitize(answer)), 10, 8)
 		if err != nil {
@@ -487,18 +529,18 @@ func MacGetPowerIndex() uint8 {
 }
 
 // MacSetPowerIndex will configure the power index for the next transmission.
-// The index has to be in the range of [1-5] for 868 MHz and [0-5] for 433 MHz.
+// The index has to exist in the current region:
+// [1-5] for 868 MHz and [0-5] for 433 MHz.
 func MacSetPowerIndex(index uint8) error {
-	if index > 5 {
+	if !region.ValidPowerIndex(index) {
 		return errors.New("invalid power index")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set pwridx %v", index))
+	n, answer, err := exchange(fmt.Sprintf("mac set pwridx %v", index))
 	if err != nil {
 		return errors.Wrap(err, "could not set power index")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set power index: invalid parameter")
 	}
@@ -508,13 +550,12 @@ func MacSetPowerIndex(index uint8) error {
 
 // MacGetADR will return the state of the adpative data rate mechanism.
 func MacGetADR() bool {
-	err := serialWrite("mac get adr")
+	n, answer, err := exchange("mac get adr")
 	if err != nil {
 		WARN.Println("mac get adr error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("mac get adr error: no answer")
 		return false
@@ -535,12 +576,11 @@ func MacSetADR(adr bool) error {
 		state = "on"
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set adr %s", state))
+	n, answer, err := exchange(fmt.Sprintf("mac set adr %s", state))
 	if err != nil {
 		return errors.Wrap(err, "could not set adaptive data rate")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set adaptive data rate: invalid parameter")
 	}
@@ -548,14 +588,66 @@ func MacSetADR(adr bool) error {
 	return nil
 }
 
+// MacGetRetransmissions will return the number of retransmissions used
+// for a confirmed uplink.
+func MacGetRetransmissions() (uint8, error) {
+	answer, err := query("mac get retx")
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get retransmissions")
+	}
+
+	retx, err := strconv.ParseUint(answer, 10, 8)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get retransmissions")
+	}
+
+	return uint8(retx), nil
+}
+
+// MacSetRetransmissions will set the number of retransmissions used
+// for a confirmed uplink.
+func MacSetRetransmissions(retx uint8) error {
+	n, answer, err := exchange(fmt.Sprintf("mac set retx %v", retx))
+	if err != nil {
+		return errors.Wrap(err, "could not set retransmissions")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set retransmissions: invalid parameter")
+	}
+
+	return nil
+}
+
+// The special battery levels of MacSetBattery
+const (
+	BatteryExternalPower = uint8(0)
+	BatteryUnknown       = uint8(255)
+)
+
+// MacSetBattery will set the battery level reported to the server in the
+// DevStatusAns: 0 for external power, [1-254] from empty to full and 255
+// when the level can't be measured.
+func MacSetBattery(level uint8) error {
+	n, answer, err := exchange(fmt.Sprintf("mac set bat %v", level))
+	if err != nil {
+		return errors.Wrap(err, "could not set battery level")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set battery level: invalid parameter")
+	}
+
+	return nil
+}
+
 // MacSetLinkCheck will set the time interval for the link check process to be triggered.
 func MacSetLinkCheck(interval uint16) error {
-	err := serialWrite(fmt.Sprintf("mac set linkchk %v", interval))
+	n, answer, err := exchange(fmt.Sprintf("mac set linkchk %v", interval))
 	if err != nil {
 		return errors.Wrap(err, "could not set link check")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set link check: invalid parameter")
 	}
@@ -563,24 +655,212 @@ func MacSetLinkCheck(interval uint16) error {
 	return nil
 }
 
+// MacSetClass will set the LoRaWAN operation class of the module.
+// The class is one of the class constants of the package (A or C).
+func MacSetClass(class string) error {
+	if class != ClassA && class != ClassC {
+		return errors.New("invalid class (A or C)")
+	}
+
+	if class == ClassC {
+		if err := requireCapability(CapClassC); err != nil {
+			return errors.Wrap(err, "could not set class")
+		}
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set class %s", class))
+	if err != nil {
+		return errors.Wrap(err, "could not set class")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set class: invalid parameter")
+	}
+
+	return nil
+}
+
+// MacGetRX2 will return the data rate and frequency (in Hz) used for the
+// second receive window, for the given band.
+func MacGetRX2(band uint16) (uint8, uint32, error) {
+	n, answer, err := exchange(fmt.Sprintf("mac get rx2 %v", band))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "could not get rx2")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return 0, 0, errors.New("could not get rx2: invalid parameter")
+	}
+
+	params := strings.Fields(string(sanitize(answer)))
+	if len(params) != 2 {
+		return 0, 0, errors.Errorf("could not get rx2: invalid answer %s", string(sanitize(answer)))
+	}
+
+	dr, err := strconv.ParseUint(params[0], 10, 8)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "could not get rx2")
+	}
+
+	frequency, err := strconv.ParseUint(params[1], 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "could not get rx2")
+	}
+
+	return uint8(dr), uint32(frequency), nil
+}
+
+// MacSetRX2 will set the data rate and frequency (in Hz) used for the
+// second receive window.
+func MacSetRX2(dr uint8, frequency uint32) error {
+	if !region.ValidDataRate(dr) {
+		return errors.New("invalid data rate")
+	}
+
+	if !region.ValidFrequency(frequency) {
+		return errors.New("invalid frequency")
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set rx2 %v %v", dr, frequency))
+	if err != nil {
+		return errors.Wrap(err, "could not set rx2")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set rx2: invalid parameter")
+	}
+
+	return nil
+}
+
+// MacSetMulticast will enable or disable the reception of multicast downlinks.
+func MacSetMulticast(on bool) error {
+	if err := requireCapability(CapMulticast); err != nil {
+		return errors.Wrap(err, "could not set multicast")
+	}
+
+	mode := "off"
+	if on {
+		mode = "on"
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set mcast %s", mode))
+	if err != nil {
+		return errors.Wrap(err, "could not set multicast")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set multicast: invalid parameter")
+	}
+
+	return nil
+}
+
+// MacSetMulticastDeviceAddress will configure the module with a multicast device address.
+// The address is a 4-byte hexadecimal value given as a string.
+func MacSetMulticastDeviceAddress(address string) error {
+	if err := requireCapability(CapMulticast); err != nil {
+		return errors.Wrap(err, "could not set multicast device address")
+	}
+
+	if len(address) != 8 {
+		return errors.New("invalid address length")
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set mcastdevaddr %s", address))
+	if err != nil {
+		return errors.Wrap(err, "could not set multicast device address")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set multicast device address: invalid parameter")
+	}
+
+	return nil
+}
+
+// MacSetMulticastNetworkSessionKey will configure the module with a multicast network session key.
+// The key is a 16-byte hexadecimal value given as a string.
+func MacSetMulticastNetworkSessionKey(key string) error {
+	if err := requireCapability(CapMulticast); err != nil {
+		return errors.Wrap(err, "could not set multicast network session key")
+	}
+
+	if len(key) != 32 {
+		return errors.New("invalid key length")
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set mcastnwkskey %s", key))
+	if err != nil {
+		return errors.Wrap(err, "could not set multicast network session key")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set multicast network session key: invalid parameter")
+	}
+
+	return nil
+}
+
+// MacSetMulticastApplicationSessionKey will configure the module with a multicast application session key.
+// The key is a 16-byte hexadecimal value given as a string.
+func MacSetMulticastApplicationSessionKey(key string) error {
+	if err := requireCapability(CapMulticast); err != nil {
+		return errors.Wrap(err, "could not set multicast application session key")
+	}
+
+	if len(key) != 32 {
+		return errors.New("invalid key length")
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set mcastappskey %s", key))
+	if err != nil {
+		return errors.Wrap(err, "could not set multicast application session key")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set multicast application session key: invalid parameter")
+	}
+
+	return nil
+}
+
+// MacSetMulticastDownlinkCounter will set the value of the multicast downlink frame counter
+// that will be used for the next multicast downlink reception.
+func MacSetMulticastDownlinkCounter(counter uint32) error {
+	if err := requireCapability(CapMulticast); err != nil {
+		return errors.Wrap(err, "could not set multicast downlink counter")
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set mcastdnctr %v", counter))
+	if err != nil {
+		return errors.Wrap(err, "could not set multicast downlink counter")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set multicast downlink counter: invalid parameter")
+	}
+
+	return nil
+}
+
 //TODO: implement mac get status
 
 // MacGetChannelFrequency will return the frequency on the requested channelID.
 // This frequency is returned in Hz.
-// The channelID has to be in the range of [0-15].
+// The channelID has to exist in the current region, [0-15] for EU868.
 func MacGetChannelFrequency(channelID uint8) uint32 {
-	if channelID > 15 {
+	if !region.ValidChannel(channelID) {
 		WARN.Println("mac get ch freq error: invalid channel")
 		return 0
 	}
 
-	err := serialWrite(fmt.Sprintf("mac get ch freq %v", channelID))
+	n, answer, err := exchange(fmt.Sprintf("mac get ch freq %v", channelID))
 	if err != nil {
 		WARN.Println("mac get ch freq error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n != 0 {
 		value, err := strconv.ParseUint(string(sanitize(answer)), 10, 32)
 		if err != nil {
@@ -597,22 +877,21 @@ func MacGetChannelFrequency(channelID uint8) uint32 {
 // MacSetChannelFrequency will set the frequency on the given channel id.
 // The default channels (0-2) cannot be modified.
 // The applicable range for the channel id is [3-15].
-// The frequency has to be given in Hz.
+// The frequency has to be given in Hz and has to be in the current region.
 func MacSetChannelFrequency(channelID uint8, frequency uint32) error {
-	if channelID < 3 || channelID > 15 {
+	if !region.ValidChannel(channelID) || region.IsDefaultChannel(channelID) {
 		return errors.New("invalid channel id")
 	}
 
-	if frequency < 433050000 || (frequency > 434790000 && frequency < 863000000) || frequency > 870000000 {
+	if !region.ValidFrequency(frequency) {
 		return errors.New("invalid frequency")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set ch freq %v %v", channelID, frequency))
+	n, answer, err := exchange(fmt.Sprintf("mac set ch freq %v %v", channelID, frequency))
 	if err != nil {
 		return errors.Wrap(err, "could not set channel frequency")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set channel frequency: invalid parameter")
 	}
@@ -622,20 +901,25 @@ func MacSetChannelFrequency(channelID uint8, frequency uint32) error {
 
 // MacGetChannelDutyCycle will return the duty cycle on the requested channelID.
 // The duty cycle will be returned as a percentage.
-// The channelID has to be in the range of [0-15].
+// The channelID has to exist in the current region, [0-15] for EU868.
+// Regions without duty cycle, like US915, always return 0.
 func MacGetChannelDutyCycle(channelID uint8) float32 {
-	if channelID > 15 {
+	if !region.ValidChannel(channelID) {
 		WARN.Println("mac get ch dcycle error: invalid channel")
 		return 0
 	}
 
-	err := serialWrite(fmt.Sprintf("mac get ch dcycle %v", channelID))
+	if len(region.SubBands) == 0 {
+		WARN.Println("mac get ch dcycle error: no duty cycle in", region.Name)
+		return 0
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac get ch dcycle %v", channelID))
 	if err != nil {
 		WARN.Println("mac get ch dcycle error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n != 0 {
 		value, err := strconv.ParseUint(string(sanitize(answer)), 10, 16)
 		if err != nil {
@@ -653,44 +937,110 @@ func MacGetChannelDutyCycle(channelID uint8) float32 {
 // The applicable range for the channel id is [0-15].
 // The duty cycle can be given as a percentage.
 func MacSetChannelDutyCycle(channelID uint8, dcycle float32) error {
-	if channelID > 15 {
+	if !region.ValidChannel(channelID) {
 		return errors.New("invalid channel id")
 	}
 
+	if len(region.SubBands) == 0 {
+		return errors.Errorf("no duty cycle in %v", region.Name)
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set ch dcycle %v %v", channelID, dutyCycleValue(dcycle)))
+	if err != nil {
+		return errors.Wrap(err, "could not set channel duty cycle")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set channel duty cycle: invalid parameter")
+	}
+
+	return nil
+}
+
+// dutyCycleValue converts a duty cycle percentage to the value used by the
+// module, which is the duty cycle as 100/(value+1).
+func dutyCycleValue(dcycle float32) uint16 {
 	value := uint64((100 / dcycle) - 1)
 
 	if value > uint64(^uint16(0)) {
 		value = uint64(^uint16(0))
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set ch dcycle %v %v", channelID, uint16(value)))
+	return uint16(value)
+}
+
+// MacGetChannelDataRateRange will return the minimum and maximum data rate
+// allowed on the given channel id.
+func MacGetChannelDataRateRange(channelID uint8) (uint8, uint8, error) {
+	if !region.ValidChannel(channelID) {
+		return 0, 0, errors.New("invalid channel id")
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac get ch drrange %v", channelID))
 	if err != nil {
-		return errors.Wrap(err, "could not set channel duty cycle")
+		return 0, 0, errors.Wrap(err, "could not get channel data rate range")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
-		return errors.New("could not set channel duty cycle: invalid parameter")
+		return 0, 0, errors.New("could not get channel data rate range: invalid parameter")
+	}
+
+	params := strings.Fields(string(sanitize(answer)))
+	if len(params) != 2 {
+		return 0, 0, errors.Errorf("could not get channel data rate range: invalid answer %s", string(sanitize(answer)))
+	}
+
+	min, err := strconv.ParseUint(params[0], 10, 8)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "could not get channel data rate range")
+	}
+
+	max, err := strconv.ParseUint(params[1], 10, 8)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "could not get channel data rate range")
+	}
+
+	return uint8(min), uint8(max), nil
+}
+
+// MacSetChannelDataRateRange will set the minimum and maximum data rate
+// allowed on the given channel id. Both data rates have to exist in the
+// current region.
+func MacSetChannelDataRateRange(channelID uint8, min, max uint8) error {
+	if !region.ValidChannel(channelID) {
+		return errors.New("invalid channel id")
+	}
+
+	if !region.ValidDataRate(min) || !region.ValidDataRate(max) || min > max {
+		return errors.New("invalid data rate range")
+	}
+
+	n, answer, err := exchange(fmt.Sprintf("mac set ch drrange %v %v %v", channelID, min, max))
+	if err != nil {
+		return errors.Wrap(err, "could not set channel data rate range")
+	}
+
+	if n == 0 || string(sanitize(answer)) == invalidParameter {
+		return errors.New("could not set channel data rate range: invalid parameter")
 	}
 
 	return nil
 }
 
 // MacGetChannelStatus will return if the given channelID is currently enabled for use.
-// The channelID has to be in the range of [0-15].
+// The channelID has to exist in the current region, [0-15] for EU868.
 func MacGetChannelStatus(channelID uint8) bool {
-	if channelID > 15 {
+	if !region.ValidChannel(channelID) {
 		WARN.Println("mac get ch status error: invalid channel")
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("mac get ch status %v", channelID))
+	n, answer, err := exchange(fmt.Sprintf("mac get ch status %v", channelID))
 	if err != nil {
 		WARN.Println("mac get ch status error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("mac get ch status error: no answer")
 		return false
@@ -704,7 +1054,7 @@ func MacGetChannelStatus(channelID uint8) bool {
 }
 
 // MacSetChannelStatus will set the operation on the given channel id.
-// The applicable range for the channel id is [0-15].
+// The channel id has to exist in the current region, [0-15] for EU868.
 func MacSetChannelStatus(channelID uint8, status bool) error {
 	var state = "off"
 
@@ -712,16 +1062,15 @@ func MacSetChannelStatus(channelID uint8, status bool) error {
 		state = "on"
 	}
 
-	if channelID > 15 {
+	if !region.ValidChannel(channelID) {
 		return errors.New("invalid channel id")
 	}
 
-	err := serialWrite(fmt.Sprintf("mac set ch dcycle %v %s", channelID, state))
+	n, answer, err := exchange(fmt.Sprintf("mac set ch status %v %s", channelID, state))
 	if err != nil {
 		return errors.Wrap(err, "could not set channel status")
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		return errors.New("could not set channel status: invalid parameter")
 	}
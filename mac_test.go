@@ -26,6 +26,7 @@ import (
 	"errors"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestMacResetWrongArgument(t *testing.T) {
@@ -201,10 +202,15 @@ func TestMacPauseSuccess(t *testing.T) {
 	}
 
 	defer resetOriginals()
+	defer func() { *state = myState{} }()
 
 	if MacPause() == 0 {
 		t.Errorf("MacPause() returned zero value while the serial read succeeded")
 	}
+
+	if !isMacPaused(1000) {
+		t.Errorf("MacPause() didn't record the pause")
+	}
 }
 
 func TestMacResumeWriteError(t *testing.T) {
@@ -256,34 +262,36 @@ func TestMacResumeSuccess(t *testing.T) {
 	}
 }
 
-//func TestIsMacPausedFalse(t *testing.T) {
-//	state.macPaused = false
-//	length := 0
-//
-//	if isMacPaused(length) == true {
-//		t.Errorf("isMacPaused(%v) returned true while the state is set to false", length)
-//	}
-//}
-//
-//func TestIsMacPausedFalseBecauseOffset(t *testing.T) {
-//	state.macPaused = true
-//	state.macPausedEnd = time.Now().Add(time.Duration(100) * time.Millisecond)
-//	length := 100
-//
-//	if isMacPaused(length) == true {
-//		t.Errorf("isMacPaused(%v) returned true wile the end time + offset is too close", length)
-//	}
-//}
-//
-//func TestIsMacPausedTrue(t *testing.T) {
-//	state.macPaused = true
-//	state.macPausedEnd = time.Now().Add(time.Duration(200) * time.Millisecond)
-//	length := 100
-//
-//	if isMacPaused(length) == false {
-//		t.Errorf("isMacPaused(%v) returned false wile the state is set to true", length)
-//	}
-//}
+func TestIsMacPausedFalse(t *testing.T) {
+	state.macPaused = false
+	length := 0
+
+	if isMacPaused(length) == true {
+		t.Errorf("isMacPaused(%v) returned true while the state is set to false", length)
+	}
+}
+
+func TestIsMacPausedFalseBecauseOffset(t *testing.T) {
+	defer func() { *state = myState{} }()
+	state.macPaused = true
+	state.macPausedEnd = time.Now().Add(time.Duration(100) * time.Millisecond)
+	length := 100
+
+	if isMacPaused(length) == true {
+		t.Errorf("isMacPaused(%v) returned true wile the end time + offset is too close", length)
+	}
+}
+
+func TestIsMacPausedTrue(t *testing.T) {
+	defer func() { *state = myState{} }()
+	state.macPaused = true
+	state.macPausedEnd = time.Now().Add(time.Duration(300) * time.Millisecond)
+	length := 100
+
+	if isMacPaused(length) == false {
+		t.Errorf("isMacPaused(%v) returned false wile the state is set to true", length)
+	}
+}
 
 func TestMacJoinInvalidMode(t *testing.T) {
 	mode := "test"
@@ -440,3 +448,43 @@ func TestMacTxOkRx(t *testing.T) {
 		t.Errorf("MacTX(%v, %v, %v, callback) returned false", uplinkType, port, data)
 	}
 }
+
+func TestMacSetClassInvalid(t *testing.T) {
+	if MacSetClass("b") == nil {
+		t.Error("MacSetClass(b) returned no error while only A and C are supported")
+	}
+}
+
+func TestMacSetClassSuccess(t *testing.T) {
+	written := mockSerial(t, nil)
+	defer resetOriginals()
+	defer restoreModule()
+	knownFirmware()
+
+	if err := MacSetClass(ClassC); err != nil {
+		t.Errorf("MacSetClass(%v) returned an error while the serial read returned ok: %v", ClassC, err)
+	}
+
+	if (*written)[0] != "mac set class c" {
+		t.Errorf("MacSetClass(%v) wrote %v", ClassC, *written)
+	}
+}
+
+func TestMacGetRX2(t *testing.T) {
+	mockSerial(t, map[string]string{"mac get rx2": "3 869525000"})
+	defer resetOriginals()
+
+	dr, frequency, err := MacGetRX2(868)
+	if err != nil || dr != 3 || frequency != 869525000 {
+		t.Errorf("MacGetRX2(868) = %v, %v, %v; should be 3, 869525000, nil", dr, frequency, err)
+	}
+}
+
+func TestMacGetRX2InvalidAnswer(t *testing.T) {
+	mockSerial(t, map[string]string{"mac get rx2": "3"})
+	defer resetOriginals()
+
+	if _, _, err := MacGetRX2(868); err == nil {
+		t.Error("MacGetRX2(868) returned no error with an invalid answer")
+	}
+}
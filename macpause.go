@@ -0,0 +1,127 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// macPauseLease keeps the LoRaWAN stack paused during a radio operation,
+// for the duration the operation needs. It only resumes the stack when the
+// lease paused it, so a pause of the application is left alone.
+type macPauseLease struct {
+	duration func() (time.Duration, error)
+	resume   bool
+}
+
+// macActive returns whether the LoRaWAN stack may use the radio. Unless the
+// stack was reset and hasn't joined since, it is assumed to be active, as
+// the module may have joined before the application (re)started.
+func macActive() bool {
+	return !state.macIdle || state.macPaused
+}
+
+// pauseMac obtains a lease that keeps the LoRaWAN stack paused for the
+// duration of a radio operation. The duration is only computed when the
+// stack is active.
+func pauseMac(duration func() (time.Duration, error)) (*macPauseLease, error) {
+	l := &macPauseLease{duration: duration}
+	if !macActive() {
+		return l, nil
+	}
+
+	l.resume = !isMacPaused(0)
+
+	return l, l.extend()
+}
+
+// extend pauses the LoRaWAN stack again when the pause ends within the
+// duration of the radio operation.
+func (l *macPauseLease) extend() error {
+	if !macActive() {
+		return nil
+	}
+
+	d, err := l.duration()
+	if err != nil {
+		return errors.Wrap(err, "could not pause mac")
+	}
+
+	length := int((d + time.Millisecond - 1) / time.Millisecond)
+	if uint64(length)+macPauseOffset > uint64(maxUint32) {
+		return errors.Errorf("could not pause mac for %v: longer than the maximum pause", d)
+	}
+
+	if isMacPaused(length) {
+		return nil
+	}
+
+	if MacPause() == 0 || !isMacPaused(length) {
+		return errors.Errorf("could not pause mac for %v", d)
+	}
+
+	return nil
+}
+
+// release resumes the LoRaWAN stack when the lease paused it.
+func (l *macPauseLease) release() {
+	if !l.resume || !state.macPaused {
+		return
+	}
+
+	if !MacResume() {
+		WARN.Println("mac resume error: could not release mac pause")
+	}
+}
+
+// radioRxDuration returns how long the receiver is open with the window:
+// the number of symbols with LoRa, milliseconds with FSK, and until the
+// watchdog time-out for continuous reception. Without watchdog time-out,
+// continuous reception has no end to pause the LoRaWAN stack for.
+func radioRxDuration(window uint16) (time.Duration, error) {
+	if window == 0 {
+		wdt := RadioGetWatchDogTimer()
+		if wdt == 0 {
+			return 0, errors.New("continuous reception without watchdog time-out can't pause the mac, set a watchdog time-out")
+		}
+
+		return time.Duration(wdt) * time.Millisecond, nil
+	}
+
+	switch mod := RadioGetModulation(); mod {
+	case FSK:
+		return time.Duration(window) * time.Millisecond, nil
+	case LoRa:
+		sf, bw := RadioGetSpreadingFactor(), RadioGetBandWidth()
+		if sf == 0 || bw == 0 {
+			return 0, errors.New("could not get lora symbol time")
+		}
+
+		symbol := time.Duration(1<<sf) * time.Millisecond / time.Duration(bw)
+		return time.Duration(window) * symbol, nil
+	default:
+		return 0, errors.Errorf("rx window not supported for modulation %q", mod)
+	}
+}
@@ -0,0 +1,279 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var macPauseAnswers = map[string]string{
+	"mac pause":       "60000",
+	"radio get mod":   "lora",
+	"radio get sf":    "sf7",
+	"radio get bw":    "125",
+	"radio get cr":    "4/5",
+	"radio get crc":   "on",
+	"radio get prlen": "8",
+	"radio get wdt":   "5000",
+}
+
+func TestRadioTxMacIdle(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{macIdle: true}
+	f := newFakeRadio(macPauseAnswers, "radio_tx_ok")
+
+	if !RadioTx([]byte{1}) {
+		t.Fatal("RadioTx() failed")
+	}
+
+	if want := []string{"radio tx 01"}; !reflect.DeepEqual(f.commands(), want) {
+		t.Errorf("commands = %q; should be %q", f.commands(), want)
+	}
+}
+
+func TestMacActive(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{}
+	mockSerial(t, map[string]string{"mac join": "denied"})
+
+	// the module may have joined before the application started
+	if !macActive() {
+		t.Error("macActive() = false while the stack state is unknown")
+	}
+
+	if !MacReset(868) || macActive() {
+		t.Error("macActive() = true after a reset of the stack")
+	}
+
+	MacJoin(OTAA)
+	if !macActive() {
+		t.Error("macActive() = false after a join attempt")
+	}
+}
+
+func TestRadioTxMacPause(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+	f := newFakeRadio(macPauseAnswers, "radio_tx_ok")
+
+	if !RadioTx([]byte{1}) {
+		t.Fatal("RadioTx() failed")
+	}
+
+	want := []string{"mac pause", "radio tx 01", "mac resume"}
+	if !reflect.DeepEqual(f.commands(), want) {
+		t.Errorf("commands = %q; should be %q", f.commands(), want)
+	}
+
+	if state.macPaused {
+		t.Error("RadioTx() left the mac paused")
+	}
+}
+
+func TestRadioTxMacPausedByApplication(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+	state.macPaused = true
+	state.macPausedEnd = time.Now().Add(time.Minute)
+	f := newFakeRadio(macPauseAnswers, "radio_tx_ok")
+
+	if !RadioTx([]byte{1}) {
+		t.Fatal("RadioTx() failed")
+	}
+
+	if want := []string{"radio tx 01"}; !reflect.DeepEqual(f.commands(), want) {
+		t.Errorf("commands = %q; should be %q", f.commands(), want)
+	}
+}
+
+func TestRadioTxMacPauseTooShort(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+
+	answers := map[string]string{"mac pause": "100"}
+	for cmd, answer := range macPauseAnswers {
+		if cmd != "mac pause" {
+			answers[cmd] = answer
+		}
+	}
+	f := newFakeRadio(answers, "radio_tx_ok")
+
+	if RadioTx([]byte{1}) {
+		t.Fatal("RadioTx() succeeded while the mac pause was too short")
+	}
+
+	if want := []string{"mac pause"}; !reflect.DeepEqual(f.commands(), want) {
+		t.Errorf("commands = %q; should be %q", f.commands(), want)
+	}
+}
+
+func TestRadioRxBlockingMacPause(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	knownFirmware()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+	f := newFakeRadio(macPauseAnswers, "radio_rx  01")
+
+	if _, err := RadioRxBlocking(0); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"mac pause", "radio rx 0", "mac resume"}
+	if !reflect.DeepEqual(f.commands(), want) {
+		t.Errorf("commands = %q; should be %q", f.commands(), want)
+	}
+}
+
+func TestRadioReceiveExtendsMacPause(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	knownFirmware()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+	f := newFakeRadio(macPauseAnswers, "radio_rx  01")
+
+	received := make(chan struct{})
+	r, err := RadioReceive(0, func(p Packet) {
+		// the pause ends before the next watchdog time-out
+		state.macPausedEnd = time.Now()
+		close(received)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("no packet received")
+	}
+
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"mac pause", "radio rx 0", "mac pause", "radio rx 0", "radio rxstop", "mac resume"}
+	if !reflect.DeepEqual(f.commands(), want) {
+		t.Errorf("commands = %q; should be %q", f.commands(), want)
+	}
+}
+
+func TestRadioRxDuration(t *testing.T) {
+	defer resetOriginals()
+
+	tests := []struct {
+		answers map[string]string
+		window  uint16
+		want    time.Duration
+	}{
+		{map[string]string{"radio get mod": "lora", "radio get sf": "sf7", "radio get bw": "125"}, 100, 102400 * time.Microsecond},
+		{map[string]string{"radio get mod": "fsk"}, 100, 100 * time.Millisecond},
+		{map[string]string{"radio get wdt": "5000"}, 0, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		mockSerial(t, test.answers)
+
+		d, err := radioRxDuration(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d != test.want {
+			t.Errorf("radioRxDuration(%v) = %v; should be %v", test.window, d, test.want)
+		}
+	}
+}
+
+func TestRadioReceiveUnbounded(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{}
+	f := newFakeRadio(map[string]string{"radio get wdt": "0"})
+
+	// continuous reception without watchdog time-out never ends
+	if _, err := RadioReceive(0, nil); err == nil {
+		t.Error("RadioReceive(0) succeeded without watchdog time-out while the mac is active")
+	}
+
+	if len(f.commands()) != 0 {
+		t.Errorf("commands = %q; should be none", f.commands())
+	}
+
+	state.macIdle = true
+	r, err := RadioReceive(0, nil)
+	if err != nil {
+		t.Fatalf("RadioReceive(0) = %v while the mac is idle", err)
+	}
+	r.once.Do(func() { close(r.stop) })
+	<-r.Done()
+}
+
+func TestMacPauseTooLong(t *testing.T) {
+	defer resetOriginals()
+	defer func() { *state = myState{} }()
+	*state = myState{}
+	f := newFakeRadio(map[string]string{"radio get wdt": "4294967295"})
+
+	if _, err := RadioReceive(0, nil); err == nil {
+		t.Error("RadioReceive(0) succeeded with a window longer than the maximum mac pause")
+	}
+
+	if len(f.commands()) != 0 {
+		t.Errorf("commands = %q; should be none", f.commands())
+	}
+}
+
+func TestRadioReceiveStopReleasesMacPause(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	defer func() { *state = myState{} }()
+	*state = myState{joined: true}
+	f := newFakeRadio(macPauseAnswers)
+
+	firmware = &FirmwareVersion{Model: ModuleRN2483, Major: 1, Minor: 0, Patch: 1}
+
+	r, err := RadioReceive(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Stop(); errors.Cause(err) != ErrUnsupported {
+		t.Errorf("Stop() = %v; should be unsupported", err)
+	}
+
+	want := []string{"mac pause", "radio rx 0", "mac resume"}
+	if !reflect.DeepEqual(f.commands(), want) {
+		t.Errorf("commands = %q; should be %q", f.commands(), want)
+	}
+}
@@ -0,0 +1,124 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import "github.com/pkg/errors"
+
+// The module families supported by the library.
+const (
+	ModuleRN2483 = "RN2483"
+	ModuleRN2903 = "RN2903"
+)
+
+// moduleFamily describes what differs between the module families
+// that share the command set.
+type moduleFamily struct {
+	regions  []*Region
+	minPower int8
+	maxPower int8
+}
+
+var moduleFamilies = map[string]moduleFamily{
+	ModuleRN2483: {regions: []*Region{EU868, EU433}, minPower: -3, maxPower: 15},
+	ModuleRN2903: {regions: []*Region{US915}, minPower: 2, maxPower: 20},
+}
+
+// module is the family of the connected module, RN2483 until detected
+var module = ModuleRN2483
+
+// Module returns the family of the connected module, see DetectModule.
+func Module() string {
+	return module
+}
+
+// DetectModule reads the module family from sys get ver and switches the
+// validation of frequencies, bands, data rates and channels to match it.
+// The firmware version is kept for the capability checks.
+func DetectModule() (string, error) {
+	firmware = nil
+
+	v, err := Firmware()
+	if err != nil {
+		return "", err
+	}
+
+	if err := setModule(v.Model); err != nil {
+		return "", err
+	}
+
+	return v.Model, nil
+}
+
+// setModule switches to the given module family. The current region is kept
+// when the module supports it, otherwise the first region of the module is used.
+func setModule(name string) error {
+	family, ok := moduleFamilies[name]
+	if !ok {
+		return errors.Errorf("unsupported module: %v", name)
+	}
+
+	module = name
+	moduleRegions = family.regions
+
+	for _, r := range moduleRegions {
+		if r == region {
+			return nil
+		}
+	}
+
+	region = moduleRegions[0]
+
+	return nil
+}
+
+// validRadioPower returns whether the module accepts the output power in dBm.
+func validRadioPower(pwr int8) bool {
+	family := moduleFamilies[module]
+	return pwr >= family.minPower && pwr <= family.maxPower
+}
+
+// MacEnableSubBand enables the 8 channels of the 125 kHz sub-band and its
+// 500 kHz channel, and disables all other channels.
+// The sub-band has to be in the range of [1-8] and is only available in
+// regions with 64+8 channels, like US915.
+func MacEnableSubBand(subBand uint8) error {
+	if region.Channels != 72 {
+		return errors.Errorf("sub-bands are not available in %v", region.Name)
+	}
+
+	if subBand < 1 || subBand > 8 {
+		return errors.New("invalid sub-band")
+	}
+
+	first := (subBand - 1) * 8
+
+	for ch := uint8(0); ch < region.Channels; ch++ {
+		enabled := (ch >= first && ch < first+8) || ch == 64+subBand-1
+
+		if err := MacSetChannelStatus(ch, enabled); err != nil {
+			return errors.Wrapf(err, "could not enable sub-band %v", subBand)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"testing"
+)
+
+// restoreModule switches back to the RN2483 in EU868, with an unknown
+// firmware, after a test.
+func restoreModule() {
+	setModule(ModuleRN2483)
+	SetRegion(EU868)
+	firmware = nil
+}
+
+func TestDetectModuleRN2903(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	written := mockSerial(t, map[string]string{"sys get ver": "RN2903 1.0.3 Aug 08 2017 15:11:09"})
+
+	name, err := DetectModule()
+	if err != nil || name != ModuleRN2903 {
+		t.Fatalf("DetectModule() = %v, %v; should be %v", name, err, ModuleRN2903)
+	}
+
+	if CurrentRegion() != US915 {
+		t.Errorf("CurrentRegion() = %v; should be US915", CurrentRegion().Name)
+	}
+
+	if !RadioSetFrequency(915000000) {
+		t.Error("RadioSetFrequency(915000000) = false; should be true")
+	}
+	if RadioSetFrequency(868100000) {
+		t.Error("RadioSetFrequency(868100000) = true; should be false")
+	}
+	if !RadioSetPower(20) || RadioSetPower(-3) {
+		t.Error("RadioSetPower should accept [2, 20] on the RN2903")
+	}
+
+	if MacReset(868) {
+		t.Error("MacReset(868) = true; should be false")
+	}
+	*written = nil
+	if !MacReset(915) {
+		t.Fatal("MacReset(915) = false; should be true")
+	}
+	if (*written)[0] != "mac reset" {
+		t.Errorf("command = %v; should be mac reset", (*written)[0])
+	}
+
+	if err := MacSetChannelStatus(71, true); err != nil {
+		t.Errorf("MacSetChannelStatus(71, true) = %v; should be nil", err)
+	}
+	if err := MacSetChannelDutyCycle(0, 1); err == nil {
+		t.Error("MacSetChannelDutyCycle succeeded in US915")
+	}
+}
+
+func TestDetectModuleRN2483(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	mockSerial(t, map[string]string{"sys get ver": "RN2483 1.0.1 Dec 15 2015 09:38:09"})
+
+	SetRegion(EU433)
+
+	name, err := DetectModule()
+	if err != nil || name != ModuleRN2483 {
+		t.Fatalf("DetectModule() = %v, %v; should be %v", name, err, ModuleRN2483)
+	}
+
+	if CurrentRegion() != EU433 {
+		t.Errorf("CurrentRegion() = %v; should stay EU433", CurrentRegion().Name)
+	}
+}
+
+func TestDetectModuleUnsupported(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	mockSerial(t, map[string]string{"sys get ver": "RN2xx3 1.0.0"})
+
+	if _, err := DetectModule(); err == nil {
+		t.Error("DetectModule() succeeded for an unknown module")
+	}
+	if Module() != ModuleRN2483 {
+		t.Errorf("Module() = %v; should be %v", Module(), ModuleRN2483)
+	}
+}
+
+func TestMacEnableSubBand(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	written := mockSerial(t, nil)
+
+	if err := MacEnableSubBand(2); err == nil {
+		t.Error("MacEnableSubBand succeeded in EU868")
+	}
+
+	setModule(ModuleRN2903)
+
+	if err := MacEnableSubBand(9); err == nil {
+		t.Error("MacEnableSubBand(9) succeeded")
+	}
+
+	if err := MacEnableSubBand(2); err != nil {
+		t.Fatalf("MacEnableSubBand(2) = %v; should be nil", err)
+	}
+
+	if len(*written) != 72 {
+		t.Fatalf("%v commands written; should be 72", len(*written))
+	}
+
+	for ch, cmd := range *written {
+		state := "off"
+		if (ch >= 8 && ch < 16) || ch == 65 {
+			state = "on"
+		}
+
+		if expected := fmt.Sprintf("mac set ch status %v %s", ch, state); cmd != expected {
+			t.Errorf("command %v = %v; should be %v", ch, cmd, expected)
+		}
+	}
+}
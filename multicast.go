@@ -0,0 +1,494 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MulticastSetupPort is the FPort of the LoRaWAN Remote Multicast Setup
+// package (TS005).
+const MulticastSetupPort = uint8(200)
+
+// The remote multicast setup commands
+const (
+	mcPackageVersion = byte(0x00)
+	mcGroupStatus    = byte(0x01)
+	mcGroupSetup     = byte(0x02)
+	mcGroupDelete    = byte(0x03)
+	mcClassCSession  = byte(0x04)
+)
+
+// The remote multicast setup package identifier and version
+const (
+	mcPackageID       = byte(2)
+	mcPackageVersion1 = byte(1)
+)
+
+// The McClassCSessionAns status bits
+const (
+	mcSessionDataRateError  = byte(1 << 2)
+	mcSessionFrequencyError = byte(1 << 3)
+	mcSessionGroupUndefined = byte(1 << 4)
+)
+
+// mcMaxGroups is the number of multicast groups supported by the module.
+const mcMaxGroups = 1
+
+// Clock is a source of the current time, for example a ClockSync.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// MulticastGroup is a multicast group that has been set up remotely.
+type MulticastGroup struct {
+	ID        uint8
+	Address   uint32
+	McKey     [16]byte
+	AppSKey   [16]byte
+	NwkSKey   [16]byte
+	MinFCount uint32
+	MaxFCount uint32
+}
+
+// MulticastSession is a scheduled Class C multicast session.
+type MulticastSession struct {
+	Group     uint8
+	Start     time.Time
+	Timeout   time.Duration
+	Frequency uint32
+	DataRate  uint8
+}
+
+// MulticastSetup implements the LoRaWAN Remote Multicast Setup package.
+// It configures the multicast group of the module and switches the module
+// to Class C for the duration of a multicast session. The class switches
+// are done when the router polls, see Router.Poll.
+// The module has to be joined with LoRaWAN 1.0.x, the AppKey is used
+// to decrypt the multicast keys.
+type MulticastSetup struct {
+	// OnSessionStart and OnSessionEnd are called when the module switched
+	// class for a session.
+	OnSessionStart func(s MulticastSession)
+	OnSessionEnd   func(s MulticastSession)
+
+	mu      sync.Mutex
+	mcKEKey [16]byte
+	clock   Clock
+	groups  [mcMaxGroups]*MulticastGroup
+	send    func(port uint8, data []byte) error
+
+	// next is the scheduled session, current the session in progress with
+	// the RX2 parameters that are restored when it ends
+	next         *MulticastSession
+	nextStart    time.Time
+	current      *MulticastSession
+	currentEnd   time.Time
+	rx2DataRate  uint8
+	rx2Frequency uint32
+
+	// now is the monotonic clock of the session start and end, replaced in tests.
+	now func() time.Time
+}
+
+// NewMulticastSetup returns a remote multicast setup handler which receives
+// its downlinks through the router. The session times are interpreted using
+// the clock, which should be synchronized (for example a ClockSync). When the
+// clock is nil, the system clock is used.
+func NewMulticastSetup(router *Router, appKey string, clock Clock) (*MulticastSetup, error) {
+	m, err := newMulticastSetup(func(port uint8, data []byte) error {
+		if !MacTxRouted(false, port, data, router) {
+			return errors.New("could not transmit multicast setup uplink")
+		}
+		return nil
+	}, appKey, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	if router != nil {
+		router.Handle(MulticastSetupPort, m.HandleDownlink)
+		router.HandlePoll(m.Poll)
+	}
+
+	return m, nil
+}
+
+func newMulticastSetup(send func(port uint8, data []byte) error, appKey string, clock Clock) (*MulticastSetup, error) {
+	key, err := hex.DecodeString(appKey)
+	if err != nil || len(key) != 16 {
+		return nil, errors.New("invalid application key")
+	}
+
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	m := &MulticastSetup{
+		clock: clock,
+		send:  send,
+		now:   time.Now,
+	}
+
+	// McRootKey = aes128_encrypt(AppKey, 0x00 | pad16) for LoRaWAN 1.0.x
+	// McKEKey = aes128_encrypt(McRootKey, 0x00 | pad16)
+	var zero [16]byte
+	rootKey := aesEncrypt(key, zero[:])
+	copy(m.mcKEKey[:], aesEncrypt(rootKey, zero[:]))
+
+	return m, nil
+}
+
+// Group returns the multicast group with the given ID, or nil if the group
+// isn't set up.
+func (m *MulticastSetup) Group(id uint8) *MulticastGroup {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if int(id) >= len(m.groups) || m.groups[id] == nil {
+		return nil
+	}
+
+	g := *m.groups[id]
+
+	return &g
+}
+
+// HandleDownlink processes the remote multicast setup commands in the downlink.
+// It can be registered on a router for port 200.
+func (m *MulticastSetup) HandleDownlink(d Downlink) {
+	var answers []byte
+	var err error
+
+	data := d.Data
+	for len(data) > 0 && err == nil {
+		cmd := data[0]
+		data = data[1:]
+
+		switch cmd {
+		case mcPackageVersion:
+			answers = append(answers, mcPackageVersion, mcPackageID, mcPackageVersion1)
+		case mcGroupStatus:
+			if len(data) < 1 {
+				err = errors.New("McGroupStatusReq too short")
+				break
+			}
+			answers = append(answers, m.groupStatus(data[0]&0x0F)...)
+			data = data[1:]
+		case mcGroupSetup:
+			if len(data) < 29 {
+				err = errors.New("McGroupSetupReq too short")
+				break
+			}
+			answers = append(answers, mcGroupSetup, m.groupSetup(data[:29]))
+			data = data[29:]
+		case mcGroupDelete:
+			if len(data) < 1 {
+				err = errors.New("McGroupDeleteReq too short")
+				break
+			}
+			answers = append(answers, mcGroupDelete, m.groupDelete(data[0]&0x03))
+			data = data[1:]
+		case mcClassCSession:
+			if len(data) < 10 {
+				err = errors.New("McClassCSessionReq too short")
+				break
+			}
+			answers = append(answers, m.classCSession(data[:10])...)
+			data = data[10:]
+		default:
+			err = errors.Errorf("unknown command 0x%02X", cmd)
+		}
+	}
+
+	if err != nil {
+		WARN.Println("multicast setup error:", err)
+	}
+
+	if len(answers) > 0 {
+		if err := m.send(MulticastSetupPort, answers); err != nil {
+			WARN.Println("multicast setup error:", err)
+		}
+	}
+}
+
+func (m *MulticastSetup) groupStatus(mask byte) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total, answered byte
+	var groups []byte
+
+	for id, g := range m.groups {
+		if g == nil {
+			continue
+		}
+
+		total++
+
+		if mask&(1<<uint(id)) != 0 {
+			answered |= 1 << uint(id)
+			addr := make([]byte, 4)
+			binary.LittleEndian.PutUint32(addr, g.Address)
+			groups = append(groups, byte(id))
+			groups = append(groups, addr...)
+		}
+	}
+
+	return append([]byte{mcGroupStatus, total<<4 | answered}, groups...)
+}
+
+func (m *MulticastSetup) groupSetup(req []byte) byte {
+	id := req[0] & 0x03
+	if int(id) >= mcMaxGroups {
+		return id | 1<<2
+	}
+
+	g := &MulticastGroup{
+		ID:        id,
+		Address:   binary.LittleEndian.Uint32(req[1:5]),
+		MinFCount: binary.LittleEndian.Uint32(req[21:25]),
+		MaxFCount: binary.LittleEndian.Uint32(req[25:29]),
+	}
+
+	// McKey = aes128_encrypt(McKEKey, McKey_encrypted)
+	copy(g.McKey[:], aesEncrypt(m.mcKEKey[:], req[5:21]))
+	copy(g.AppSKey[:], mcSessionKey(g.McKey[:], 0x01, g.Address))
+	copy(g.NwkSKey[:], mcSessionKey(g.McKey[:], 0x02, g.Address))
+
+	if err := configureMulticastGroup(g); err != nil {
+		WARN.Println("multicast setup error:", err)
+		return id | 1<<2
+	}
+
+	m.mu.Lock()
+	m.groups[id] = g
+	m.mu.Unlock()
+
+	DEBUG.Printf("multicast group %v set up with address %08X", id, g.Address)
+
+	return id
+}
+
+func (m *MulticastSetup) groupDelete(id uint8) byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if int(id) >= mcMaxGroups || m.groups[id] == nil {
+		return id | 1<<2
+	}
+
+	m.groups[id] = nil
+
+	if err := MacSetMulticast(false); err != nil {
+		WARN.Println("multicast setup error:", err)
+	}
+
+	return id
+}
+
+func (m *MulticastSetup) classCSession(req []byte) []byte {
+	id := req[0] & 0x03
+
+	s := MulticastSession{
+		Group:     id,
+		Start:     gpsEpoch.Add(time.Duration(binary.LittleEndian.Uint32(req[1:5]))*time.Second - gpsLeapSeconds),
+		Timeout:   time.Duration(1<<(req[5]&0x0F)) * time.Second,
+		Frequency: uint32(req[6]) | uint32(req[7])<<8 | uint32(req[8])<<16,
+		DataRate:  req[9],
+	}
+	s.Frequency *= 100
+
+	status := id
+
+	if !region.ValidDataRate(s.DataRate) {
+		status |= mcSessionDataRateError
+	}
+
+	if !region.ValidFrequency(s.Frequency) {
+		status |= mcSessionFrequencyError
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if int(id) >= mcMaxGroups || m.groups[id] == nil {
+		status |= mcSessionGroupUndefined
+	}
+
+	if status != id {
+		return []byte{mcClassCSession, status}
+	}
+
+	start := s.Start.Sub(m.clock.Now())
+	if start < 0 {
+		start = 0
+	}
+
+	m.next = &s
+	m.nextStart = m.now().Add(start)
+
+	seconds := uint32(start / time.Second)
+
+	return []byte{mcClassCSession, status, byte(seconds), byte(seconds >> 8), byte(seconds >> 16)}
+}
+
+// Poll starts and ends the multicast sessions that are due, on the goroutine
+// of the caller. The router calls it when it polls.
+func (m *MulticastSetup) Poll() {
+	m.mu.Lock()
+	now := m.now()
+
+	var end, start *MulticastSession
+	if m.current != nil && !now.Before(m.currentEnd) {
+		end, m.current = m.current, nil
+	}
+	if m.next != nil && !now.Before(m.nextStart) {
+		start, m.next = m.next, nil
+	}
+	m.mu.Unlock()
+
+	if end != nil {
+		m.endSession(*end)
+	}
+
+	if start != nil {
+		m.startSession(*start)
+	}
+}
+
+func (m *MulticastSetup) startSession(s MulticastSession) {
+	m.mu.Lock()
+	active := m.current != nil
+	m.mu.Unlock()
+
+	// a session that follows another one keeps the RX2 parameters to restore
+	if !active {
+		dr, frequency, err := MacGetRX2(region.Band)
+		if err != nil {
+			WARN.Println("multicast session error:", err)
+			return
+		}
+
+		m.mu.Lock()
+		m.rx2DataRate, m.rx2Frequency = dr, frequency
+		m.mu.Unlock()
+	}
+
+	if err := MacSetRX2(s.DataRate, s.Frequency); err != nil {
+		WARN.Println("multicast session error:", err)
+		return
+	}
+
+	if err := MacSetClass(ClassC); err != nil {
+		WARN.Println("multicast session error:", err)
+		return
+	}
+
+	DEBUG.Printf("multicast session for group %v started", s.Group)
+
+	m.mu.Lock()
+	m.current = &s
+	m.currentEnd = m.now().Add(s.Timeout)
+	m.mu.Unlock()
+
+	if m.OnSessionStart != nil {
+		m.OnSessionStart(s)
+	}
+}
+
+func (m *MulticastSetup) endSession(s MulticastSession) {
+	if err := MacSetClass(ClassA); err != nil {
+		WARN.Println("multicast session error:", err)
+	}
+
+	m.mu.Lock()
+	dr, frequency := m.rx2DataRate, m.rx2Frequency
+	m.mu.Unlock()
+
+	if err := MacSetRX2(dr, frequency); err != nil {
+		WARN.Println("multicast session error:", err)
+	}
+
+	DEBUG.Printf("multicast session for group %v ended", s.Group)
+
+	if m.OnSessionEnd != nil {
+		m.OnSessionEnd(s)
+	}
+}
+
+// configureMulticastGroup writes the multicast group to the module.
+func configureMulticastGroup(g *MulticastGroup) error {
+	if err := MacSetMulticastDeviceAddress(fmt.Sprintf("%08X", g.Address)); err != nil {
+		return err
+	}
+
+	if err := MacSetMulticastNetworkSessionKey(fmt.Sprintf("%X", g.NwkSKey)); err != nil {
+		return err
+	}
+
+	if err := MacSetMulticastApplicationSessionKey(fmt.Sprintf("%X", g.AppSKey)); err != nil {
+		return err
+	}
+
+	if err := MacSetMulticastDownlinkCounter(g.MinFCount); err != nil {
+		return err
+	}
+
+	return MacSetMulticast(true)
+}
+
+// mcSessionKey derives a multicast session key:
+// aes128_encrypt(McKey, prefix | McAddr | pad16)
+func mcSessionKey(mcKey []byte, prefix byte, address uint32) []byte {
+	b := make([]byte, 16)
+	b[0] = prefix
+	binary.LittleEndian.PutUint32(b[1:5], address)
+
+	return aesEncrypt(mcKey, b)
+}
+
+func aesEncrypt(key, block []byte) []byte {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	out := make([]byte, 16)
+	c.Encrypt(out, block)
+
+	return out
+}
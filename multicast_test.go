@@ -0,0 +1,221 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestMulticastGroupSetup(t *testing.T) {
+	written := mockSerial(t, nil)
+	defer resetOriginals()
+	defer restoreModule()
+	knownFirmware()
+
+	appKey := "2B7E151628AED2A6ABF7158809CF4F3C"
+
+	var answers [][]byte
+	m, err := newMulticastSetup(func(port uint8, data []byte) error {
+		answers = append(answers, data)
+		return nil
+	}, appKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server encrypts the McKey with aes128_decrypt(McKEKey, McKey)
+	mcKey, _ := hex.DecodeString("01020304050607080910111213141516")
+	block, _ := aes.NewCipher(m.mcKEKey[:])
+	encrypted := make([]byte, 16)
+	block.Decrypt(encrypted, mcKey)
+
+	req := []byte{mcGroupSetup, 0x00, 0x78, 0x56, 0x34, 0x12}
+	req = append(req, encrypted...)
+	req = append(req, 10, 0, 0, 0, 0xFF, 0, 0, 0)
+
+	m.HandleDownlink(Downlink{Port: MulticastSetupPort, Data: req})
+
+	if len(answers) != 1 || !bytes.Equal(answers[0], []byte{mcGroupSetup, 0x00}) {
+		t.Fatalf("McGroupSetupAns = % X; should be 02 00", answers)
+	}
+
+	g := m.Group(0)
+	if g == nil || !bytes.Equal(g.McKey[:], mcKey) || g.Address != 0x12345678 || g.MinFCount != 10 {
+		t.Fatalf("Group(0) = %+v; should have the decrypted McKey", g)
+	}
+
+	expected := []string{
+		"mac set mcastdevaddr 12345678",
+		fmt.Sprintf("mac set mcastnwkskey %X", g.NwkSKey),
+		fmt.Sprintf("mac set mcastappskey %X", g.AppSKey),
+		"mac set mcastdnctr 10",
+		"mac set mcast on",
+	}
+
+	if fmt.Sprint(*written) != fmt.Sprint(expected) {
+		t.Errorf("Commands written = %v; should be %v", *written, expected)
+	}
+
+	m.HandleDownlink(Downlink{Port: MulticastSetupPort, Data: []byte{mcGroupStatus, 0x0F}})
+
+	status := []byte{mcGroupStatus, 1<<4 | 1, 0, 0x78, 0x56, 0x34, 0x12}
+	if len(answers) != 2 || !bytes.Equal(answers[1], status) {
+		t.Errorf("McGroupStatusAns = % X; should be % X", answers[1:], status)
+	}
+}
+
+func TestMulticastGroupSetupUnsupportedID(t *testing.T) {
+	var answers [][]byte
+	m, _ := newMulticastSetup(func(port uint8, data []byte) error {
+		answers = append(answers, data)
+		return nil
+	}, "2B7E151628AED2A6ABF7158809CF4F3C", nil)
+
+	req := make([]byte, 30)
+	req[0], req[1] = mcGroupSetup, 0x02
+	m.HandleDownlink(Downlink{Data: append(req, mcGroupDelete, 0x00)})
+
+	expected := []byte{mcGroupSetup, 0x02 | 1<<2, mcGroupDelete, 0x00 | 1<<2}
+	if len(answers) != 1 || !bytes.Equal(answers[0], expected) {
+		t.Errorf("answers = % X; should be % X", answers, expected)
+	}
+}
+
+func TestMulticastClassCSession(t *testing.T) {
+	written := mockSerial(t, map[string]string{"mac get rx2": "0 869525000"})
+	defer resetOriginals()
+	defer restoreModule()
+	knownFirmware()
+
+	now := time.Date(2018, time.August, 1, 12, 0, 0, 0, time.UTC)
+
+	var answers [][]byte
+	m, _ := newMulticastSetup(func(port uint8, data []byte) error {
+		answers = append(answers, data)
+		return nil
+	}, "2B7E151628AED2A6ABF7158809CF4F3C", fixedClock(now))
+	m.groups[0] = &MulticastGroup{}
+
+	elapsed := time.Duration(0)
+	m.now = func() time.Time { return now.Add(elapsed) }
+
+	var started, ended bool
+	m.OnSessionStart = func(s MulticastSession) { started = true }
+	m.OnSessionEnd = func(s MulticastSession) { ended = true }
+
+	start := uint32((now.Add(90*time.Second).Sub(gpsEpoch) + gpsLeapSeconds) / time.Second)
+	req := []byte{mcClassCSession, 0x00, 0, 0, 0, 0, 0x05, 0, 0, 0, 3}
+	binary.LittleEndian.PutUint32(req[2:6], start)
+	frequency := uint32(869525000 / 100)
+	req[7], req[8], req[9] = byte(frequency), byte(frequency>>8), byte(frequency>>16)
+
+	m.HandleDownlink(Downlink{Data: req})
+
+	expected := []byte{mcClassCSession, 0x00, 90, 0, 0}
+	if len(answers) != 1 || !bytes.Equal(answers[0], expected) {
+		t.Fatalf("McClassCSessionAns = % X; should be % X", answers, expected)
+	}
+
+	elapsed = 89 * time.Second
+	m.Poll()
+	if started || len(*written) != 0 {
+		t.Fatalf("session started before 90s with commands %v", *written)
+	}
+
+	elapsed = 90 * time.Second
+	m.Poll()
+	if !started {
+		t.Fatal("session did not start after 90s")
+	}
+
+	elapsed = 121 * time.Second
+	m.Poll()
+	if ended {
+		t.Fatal("session ended before 32s")
+	}
+
+	elapsed = 122 * time.Second
+	m.Poll()
+	if !ended {
+		t.Fatal("session did not end after 32s")
+	}
+
+	commands := []string{
+		"mac get rx2 868",
+		"mac set rx2 3 869525000",
+		"mac set class c",
+		"mac set class a",
+		"mac set rx2 0 869525000",
+	}
+
+	if fmt.Sprint(*written) != fmt.Sprint(commands) {
+		t.Errorf("Commands written = %v; should be %v", *written, commands)
+	}
+}
+
+func TestMulticastClassCSessionRegionBand(t *testing.T) {
+	written := mockSerial(t, map[string]string{"mac get rx2": "8 923300000"})
+	defer resetOriginals()
+	defer restoreModule()
+	SetRegion(US915)
+
+	m, _ := newMulticastSetup(func(port uint8, data []byte) error { return nil }, "2B7E151628AED2A6ABF7158809CF4F3C", nil)
+	m.groups[0] = &MulticastGroup{}
+
+	frequency := uint32(923300000 / 100)
+	req := []byte{mcClassCSession, 0x00, 0, 0, 0, 0, 0x05, byte(frequency), byte(frequency >> 8), byte(frequency >> 16), 8}
+	m.HandleDownlink(Downlink{Data: req})
+	m.Poll()
+
+	if len(*written) == 0 || (*written)[0] != "mac get rx2 915" {
+		t.Errorf("Commands written = %v; should start with mac get rx2 915", *written)
+	}
+}
+
+func TestMulticastClassCSessionErrors(t *testing.T) {
+	var answers [][]byte
+	m, _ := newMulticastSetup(func(port uint8, data []byte) error {
+		answers = append(answers, data)
+		return nil
+	}, "2B7E151628AED2A6ABF7158809CF4F3C", nil)
+
+	req := []byte{mcClassCSession, 0x00, 0, 0, 0, 0, 0x05, 0x01, 0, 0, 9}
+	m.HandleDownlink(Downlink{Data: req})
+
+	status := mcSessionDataRateError | mcSessionFrequencyError | mcSessionGroupUndefined
+	if len(answers) != 1 || !bytes.Equal(answers[0], []byte{mcClassCSession, status}) {
+		t.Errorf("McClassCSessionAns = % X; should be 04 %02X", answers, status)
+	}
+}
@@ -0,0 +1,306 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The user area of the EEPROM
+const (
+	nvmStart = 0x300
+	nvmEnd   = 0x400
+)
+
+// ReadBytes reads length bytes of the EEPROM from the address.
+// The module has no bulk command, so every byte is a sys get nvm.
+func ReadBytes(address uint16, length int) ([]byte, error) {
+	if address < nvmStart || length < 0 || int(address)+length > nvmEnd {
+		return nil, errors.New("address out of range [768-1023]")
+	}
+
+	data := make([]byte, length)
+	for i := range data {
+		b, err := ReadByte(address + uint16(i))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read nvm at 0x%X", address+uint16(i))
+		}
+		data[i] = b
+	}
+
+	return data, nil
+}
+
+// SaveBytes writes the data to the EEPROM from the address. Bytes that are
+// equal to the given current content are skipped, to save writes and wear.
+// A nil current content writes every byte.
+func SaveBytes(address uint16, data, current []byte) error {
+	if address < nvmStart || int(address)+len(data) > nvmEnd {
+		return errors.New("address out of range [768-1023]")
+	}
+
+	for i, b := range data {
+		if i < len(current) && current[i] == b {
+			continue
+		}
+
+		if !SaveByte(address+uint16(i), b) {
+			return errors.Errorf("could not save nvm at 0x%X", address+uint16(i))
+		}
+	}
+
+	return nil
+}
+
+// The layout of a bank of the NVM store: the magic, the version, the
+// generation (LE), the length of the entries, the entries and the CRC32 (LE)
+// of all that precedes it. Every entry is a key, a length and the value.
+const (
+	nvmStoreVersion = 1
+	nvmBankSize     = (nvmEnd - nvmStart) / 2
+	nvmHeaderSize   = 6
+	nvmCRCSize      = 4
+	// NVMStoreCapacity is the number of bytes available for the entries,
+	// including 2 bytes per entry for its key and length
+	NVMStoreCapacity = nvmBankSize - nvmHeaderSize - nvmCRCSize
+)
+
+var nvmMagic = []byte("KV")
+
+// NVMStore is a key-value store in the user EEPROM area. It keeps two banks
+// of 128 bytes: a commit writes the inactive bank, which becomes active
+// through its higher generation once it is complete. An interrupted commit
+// leaves the previous bank active.
+type NVMStore struct {
+	mu         sync.Mutex
+	values     map[uint8][]byte
+	generation uint16
+	active     int
+	banks      [2][]byte
+}
+
+// OpenNVMStore reads the store from the EEPROM. When no bank is valid,
+// the store is empty.
+func OpenNVMStore() (*NVMStore, error) {
+	s := &NVMStore{values: make(map[uint8][]byte), active: -1}
+
+	for i := range s.banks {
+		bank, err := ReadBytes(nvmBankAddress(i), nvmBankSize)
+		if err != nil {
+			return nil, err
+		}
+		s.banks[i] = bank
+
+		generation, values, ok := decodeNVMBank(bank)
+		if !ok {
+			continue
+		}
+
+		if s.active < 0 || int16(generation-s.generation) > 0 {
+			s.active = i
+			s.generation = generation
+			s.values = values
+		}
+	}
+
+	return s, nil
+}
+
+func nvmBankAddress(bank int) uint16 {
+	return uint16(nvmStart + bank*nvmBankSize)
+}
+
+// Get returns the raw value of the key.
+func (s *NVMStore) Get(key uint8) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	return append([]byte(nil), value...), ok
+}
+
+// Set sets the raw value of the key. The change is kept in memory until Commit.
+func (s *NVMStore) Set(key uint8, value []byte) error {
+	if len(value) > 255 {
+		return errors.New("value too long")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = append([]byte(nil), value...)
+
+	return nil
+}
+
+// Delete removes the key. The change is kept in memory until Commit.
+func (s *NVMStore) Delete(key uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+}
+
+// Put sets the key to a typed value: a string, a byte slice or a fixed size
+// value as encoding/binary writes it (little endian).
+func (s *NVMStore) Put(key uint8, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return s.Set(key, []byte(v))
+	case []byte:
+		return s.Set(key, v)
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, value); err != nil {
+		return errors.Wrapf(err, "could not encode key %v", key)
+	}
+
+	return s.Set(key, buf.Bytes())
+}
+
+// Value decodes the value of the key into a pointer to a string, a byte slice
+// or a fixed size value. It returns false when the key doesn't exist.
+func (s *NVMStore) Value(key uint8, value interface{}) (bool, error) {
+	data, ok := s.Get(key)
+	if !ok {
+		return false, nil
+	}
+
+	switch v := value.(type) {
+	case *string:
+		*v = string(data)
+		return true, nil
+	case *[]byte:
+		*v = data
+		return true, nil
+	}
+
+	if binary.Size(value) != len(data) {
+		return true, errors.Errorf("key %v has %v bytes, not %v", key, len(data), binary.Size(value))
+	}
+
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, value); err != nil {
+		return true, errors.Wrapf(err, "could not decode key %v", key)
+	}
+
+	return true, nil
+}
+
+// Commit writes the store to the inactive bank. Only the bytes that differ
+// from the content of the bank are written.
+func (s *NVMStore) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	generation := s.generation + 1
+	bank, err := encodeNVMBank(generation, s.values)
+	if err != nil {
+		return err
+	}
+
+	target := 0
+	if s.active == 0 {
+		target = 1
+	}
+
+	if err := SaveBytes(nvmBankAddress(target), bank, s.banks[target]); err != nil {
+		// the content of the bank is unknown now
+		s.banks[target] = nil
+		return errors.Wrap(err, "could not commit nvm store")
+	}
+
+	s.banks[target] = bank
+	s.active = target
+	s.generation = generation
+
+	return nil
+}
+
+// encodeNVMBank encodes the values in a bank, padded with 0xFF.
+func encodeNVMBank(generation uint16, values map[uint8][]byte) ([]byte, error) {
+	keys := make([]int, 0, len(values))
+	for key := range values {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+
+	var entries []byte
+	for _, key := range keys {
+		value := values[uint8(key)]
+		entries = append(entries, uint8(key), uint8(len(value)))
+		entries = append(entries, value...)
+	}
+
+	if len(entries) > NVMStoreCapacity {
+		return nil, errors.Errorf("nvm store full: %v bytes of %v", len(entries), NVMStoreCapacity)
+	}
+
+	bank := append([]byte(nil), nvmMagic...)
+	bank = append(bank, nvmStoreVersion, byte(generation), byte(generation>>8), byte(len(entries)))
+	bank = append(bank, entries...)
+
+	crc := crc32.ChecksumIEEE(bank)
+	bank = append(bank, byte(crc), byte(crc>>8), byte(crc>>16), byte(crc>>24))
+
+	for len(bank) < nvmBankSize {
+		bank = append(bank, 0xFF)
+	}
+
+	return bank, nil
+}
+
+// decodeNVMBank decodes a bank, it returns false when the bank is not valid.
+func decodeNVMBank(bank []byte) (uint16, map[uint8][]byte, bool) {
+	if len(bank) != nvmBankSize || !bytes.Equal(bank[:2], nvmMagic) || bank[2] != nvmStoreVersion {
+		return 0, nil, false
+	}
+
+	length := int(bank[5])
+	if length > NVMStoreCapacity {
+		return 0, nil, false
+	}
+
+	end := nvmHeaderSize + length
+	if crc32.ChecksumIEEE(bank[:end]) != binary.LittleEndian.Uint32(bank[end:]) {
+		return 0, nil, false
+	}
+
+	values := make(map[uint8][]byte)
+	for i := nvmHeaderSize; i < end; {
+		if i+2 > end || i+2+int(bank[i+1]) > end {
+			return 0, nil, false
+		}
+
+		values[bank[i]] = append([]byte(nil), bank[i+2:i+2+int(bank[i+1])]...)
+		i += 2 + int(bank[i+1])
+	}
+
+	return binary.LittleEndian.Uint16(bank[3:]), values, true
+}
@@ -0,0 +1,215 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeEEPROM emulates the sys nvm commands of the module.
+type fakeEEPROM struct {
+	memory [nvmEnd]byte
+	writes int
+	// failAfter makes the writes fail after this number of writes, when > 0
+	failAfter int
+}
+
+func newFakeEEPROM(t *testing.T) *fakeEEPROM {
+	e := &fakeEEPROM{}
+	for i := range e.memory {
+		e.memory[i] = 0xFF
+	}
+
+	var answer string
+
+	serialWrite = func(s string) error {
+		args := strings.Fields(s)
+		address, _ := strconv.ParseUint(args[3], 16, 16)
+		answer = "ok"
+
+		switch {
+		case args[1] == "get":
+			answer = fmt.Sprintf("%02X", e.memory[address])
+		case e.failAfter > 0 && e.writes >= e.failAfter:
+			answer = invalidParameter
+		default:
+			value, _ := strconv.ParseUint(args[4], 16, 8)
+			e.memory[address] = byte(value)
+			e.writes++
+		}
+
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		b := []byte(answer + "\r\n")
+		return len(b), b
+	}
+
+	return e
+}
+
+func TestBulkNVM(t *testing.T) {
+	defer resetOriginals()
+	e := newFakeEEPROM(t)
+
+	if err := SaveBytes(0x3F0, []byte{1, 2, 3}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveBytes(0x3F0, []byte{1, 5, 3}, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if e.writes != 4 {
+		t.Errorf("%v bytes written; should be 4", e.writes)
+	}
+
+	data, err := ReadBytes(0x3F0, 3)
+	if err != nil || fmt.Sprint(data) != "[1 5 3]" {
+		t.Errorf("ReadBytes() = %v, %v; should be [1 5 3]", data, err)
+	}
+
+	if _, err := ReadBytes(0x3FE, 3); err == nil {
+		t.Error("ReadBytes accepted a range past the user area")
+	}
+	if _, err := ReadBytes(0x3F0, -1); err == nil {
+		t.Error("ReadBytes accepted a negative length")
+	}
+	if err := SaveBytes(0x2FF, []byte{1}, nil); err == nil {
+		t.Error("SaveBytes accepted an address before the user area")
+	}
+}
+
+func TestNVMStore(t *testing.T) {
+	defer resetOriginals()
+	e := newFakeEEPROM(t)
+
+	s, err := OpenNVMStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(1, uint32(600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(2, int16(-42)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(3, "node-7"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenNVMStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var interval uint32
+	var offset int16
+	var name string
+
+	if ok, err := s.Value(1, &interval); !ok || err != nil || interval != 600 {
+		t.Errorf("Value(1) = %v, %v, %v; should be 600", ok, err, interval)
+	}
+	if ok, err := s.Value(2, &offset); !ok || err != nil || offset != -42 {
+		t.Errorf("Value(2) = %v, %v, %v; should be -42", ok, err, offset)
+	}
+	if ok, err := s.Value(3, &name); !ok || err != nil || name != "node-7" {
+		t.Errorf("Value(3) = %v, %v, %v; should be node-7", ok, err, name)
+	}
+	if ok, _ := s.Value(4, &name); ok {
+		t.Error("Value(4) found a key that was never set")
+	}
+	if _, err := s.Value(1, &offset); err == nil {
+		t.Error("Value decoded a 4 byte value in an int16")
+	}
+
+	// the second commit goes to the other bank, the third one only rewrites
+	// the generation and CRC of the first bank
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	e.writes = 0
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if e.writes > 6 {
+		t.Errorf("%v bytes written for an unchanged store; should be at most 6", e.writes)
+	}
+}
+
+func TestNVMStoreInterruptedCommit(t *testing.T) {
+	defer resetOriginals()
+	e := newFakeEEPROM(t)
+
+	s, err := OpenNVMStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Put(1, uint8(1))
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Put(1, uint8(2))
+	e.failAfter = e.writes + 5
+	if err := s.Commit(); err == nil {
+		t.Fatal("Commit() succeeded while the writes failed")
+	}
+
+	s, err = OpenNVMStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value uint8
+	if ok, err := s.Value(1, &value); !ok || err != nil || value != 1 {
+		t.Errorf("Value(1) = %v, %v, %v; should be the committed 1", ok, err, value)
+	}
+}
+
+func TestNVMStoreFull(t *testing.T) {
+	defer resetOriginals()
+	newFakeEEPROM(t)
+
+	s, err := OpenNVMStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Set(1, make([]byte, NVMStoreCapacity-2))
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Set(2, nil)
+	if err := s.Commit(); err == nil {
+		t.Error("Commit() succeeded for a store over capacity")
+	}
+}
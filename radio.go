@@ -23,47 +23,70 @@
 package rn2483
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // RadioRxBlocking will open the receiver.
 // The window size is the number of symbols for LoRa modulation and the
 // time in milliseconds for FSK modulation. In order to enable continuous
 // reception, the window size should be 0. Don't forget to set the radio
-// watchdog timer time-out! This function will return a valid packet that has
-// been received, or an empty array of bytes when the receiver was busy or it
-// timed out without receiving a valid packet. This function is blocking, which
+// watchdog timer time-out! This function will return the packet that has
+// been received, with the radio settings it was received with and its SNR
+// and RSSI, or an error when the receiver was busy or it timed out without
+// receiving a valid packet. This function is blocking, which
 // means if you enabled continous reception, it will block the program until a
 // valid packet has been received or until a time out occured.
-func RadioRxBlocking(window uint16) []byte {
-	var b []byte
+// When the LoRaWAN stack is active, it is paused for the window and
+// resumed afterwards.
+func RadioRxBlocking(window uint16) (Packet, error) {
+	lease, err := pauseMac(func() (time.Duration, error) { return radioRxDuration(window) })
+	if err != nil {
+		return Packet{}, err
+	}
+	defer lease.release()
 
-	// TODO Should get wdt to get the length
-	// if !isMacPaused(length)
+	p := newPacket()
 
-	err := serialWrite(fmt.Sprintf("radio rx %v", window))
+	data, err := receiveRadioRx(window)
 	if err != nil {
-		WARN.Println("radio rx error:", err)
-		return b
+		return p, err
 	}
 
-	n, answer := serialRead()
-	if n == 0 || string(sanitize(answer)) == invalidParameter ||
-		string(sanitize(answer)) == "busy" {
-		WARN.Println("radio rx error: busy or invalid parameter")
-		return b
+	p.received(data)
+
+	return p, nil
+}
+
+// receiveRadioRx opens the receiver and waits for its packet, holding the
+// serial port until the packet or the end of the window.
+func receiveRadioRx(window uint16) ([]byte, error) {
+	lockSerial()
+	defer unlockSerial()
+
+	if err := radioRx(window); err != nil {
+		return nil, err
 	}
 
 	for {
 		n, answer := serialRead()
-		if n != 0 && string(sanitize(answer)) == "radio_err" {
-			return b
+		if n == 0 {
+			continue
 		}
 
-		if n != 0 && string(answer[:8]) == "radio_rx" {
-			return answer[10:]
+		for _, line := range strings.Split(string(answer), "\r\n") {
+			if line == "radio_err" {
+				return nil, ErrRadioRxWindow
+			}
+
+			if strings.HasPrefix(line, "radio_rx") {
+				return parseRadioRx(line)
+			}
 		}
 	}
 }
@@ -72,7 +95,10 @@ func RadioRxBlocking(window uint16) []byte {
 // but has to be smaller than 255 if LoRa modulation is active or smaller
 // than 64 if FSK modulation is active. It will return a boolean, true if
 // the transmit was succesful, false is there was an error. For more info
-// about the error, the user can check the log file.
+// about the error, the user can check the log file. When a duty cycle
+// tracker is set with SetRadioDutyCycleTracker, the transmission is refused
+// or delayed according to its policy. When the LoRaWAN stack is active,
+// it is paused for the time on air and resumed afterwards.
 func RadioTx(data []byte) bool {
 	//TODO check modulation to get maximum bytes allowed: 255 LoRa and 64 FSK
 	if len(data) == 0 {
@@ -80,9 +106,31 @@ func RadioTx(data []byte) bool {
 		return false
 	}
 
-	// TODO check air time to check isMacPaused
+	sent := false
+	if radioDutyCycle != nil {
+		release, ok := reserveRadioDutyCycle(len(data))
+		if !ok {
+			return false
+		}
+
+		defer func() {
+			if !sent {
+				release()
+			}
+		}()
+	}
 
-	err := serialWrite(fmt.Sprintf("radio tx %X", data))
+	lease, err := pauseMac(func() (time.Duration, error) { return RadioTimeOnAir(len(data)) })
+	if err != nil {
+		WARN.Println("radio tx error:", err)
+		return false
+	}
+	defer lease.release()
+
+	lockSerial()
+	defer unlockSerial()
+
+	err = serialWrite(fmt.Sprintf("radio tx %X", data))
 	if err != nil {
 		WARN.Println("radio tx error:", err)
 		return false
@@ -108,6 +156,7 @@ func RadioTx(data []byte) bool {
 			}
 
 			if n != 0 && string(sanitize(answer)) == "radio_tx_ok" {
+				sent = true
 				return true
 			}
 		}
@@ -117,13 +166,12 @@ func RadioTx(data []byte) bool {
 // RadioGetModulation reads back the current mode of operation of the module.
 // It returns an empty string if something went wrong.
 func RadioGetModulation() string {
-	err := serialWrite("radio get mod")
+	n, answer, err := exchange("radio get mod")
 	if err != nil {
 		WARN.Println("radio get mod error:", err)
 		return ""
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get mod error: no answer")
 		return ""
@@ -142,13 +190,12 @@ func RadioSetModulation(mod string) bool {
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set mod %s", mod))
+	n, answer, err := exchange(fmt.Sprintf("radio set mod %s", mod))
 	if err != nil {
 		WARN.Println("radio set mod error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set mod:", string(sanitize(answer)))
 		return false
@@ -160,13 +207,12 @@ func RadioSetModulation(mod string) bool {
 // RadioGetFrequency returns the current operation frequency of the module.
 // If there was an error, the function will return 0.
 func RadioGetFrequency() uint32 {
-	err := serialWrite("radio get freq")
+	n, answer, err := exchange("radio get freq")
 	if err != nil {
 		WARN.Println("radio get freq error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get freq error: no answer")
 		return 0
@@ -182,21 +228,21 @@ func RadioGetFrequency() uint32 {
 }
 
 // RadioSetFrequency changes the communication frequency of the radio transceiver.
-// It will only accept frequencies between [433050000, 434790000] and [863000000, 870000000].
+// It will only accept frequencies in the regions of the module, for the RN2483
+// between [433050000, 434790000] and [863000000, 870000000].
 // The function will return true when the frequency changed and false when an error occured.
 func RadioSetFrequency(freq uint32) bool {
-	if (freq < 433050000 || freq > 434790000) && (freq < 863000000 || freq > 870000000) {
+	if !validRadioFrequency(freq) {
 		WARN.Println("radio set freq error: invalid frequency", freq)
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set freq %v", freq))
+	n, answer, err := exchange(fmt.Sprintf("radio set freq %v", freq))
 	if err != nil {
 		WARN.Println("radio set freq error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set freq error: invalid parameter")
 		return false
@@ -209,13 +255,12 @@ func RadioSetFrequency(freq uint32) bool {
 // The function will return an int8 value, which will be between [-3, 15].
 // If an error occured, it will return -15.
 func RadioGetPower() int8 {
-	err := serialWrite("radio get pwr")
+	n, answer, err := exchange("radio get pwr")
 	if err != nil {
 		WARN.Println("radio get pwr error:", err)
 		return -15
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get pwr error: no answer")
 		return -15
@@ -231,22 +276,22 @@ func RadioGetPower() int8 {
 }
 
 // RadioSetPower changes the transceiver output power.
-// The output power has to be passed as an int8 value between [-3, 15].
+// The output power has to be passed as an int8 value between [-3, 15],
+// or [2, 20] for the RN2903.
 // The function will return true if the change succeeeded, or false when
 // an error occured.
 func RadioSetPower(pwr int8) bool {
-	if pwr < -3 || pwr > 15 {
+	if !validRadioPower(pwr) {
 		WARN.Println("radio set pwr error: invalid power", pwr)
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set pwr %v", pwr))
+	n, answer, err := exchange(fmt.Sprintf("radio set pwr %v", pwr))
 	if err != nil {
 		WARN.Println("radio set pwr error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set pwr error: invalid parameter")
 		return false
@@ -260,13 +305,12 @@ func RadioSetPower(pwr int8) bool {
 // It will return an uint8 between [7, 12].
 // If an error occured, it will return 0.
 func RadioGetSpreadingFactor() uint8 {
-	err := serialWrite("radio get sf")
+	n, answer, err := exchange("radio get sf")
 	if err != nil {
 		WARN.Println("radio get sf error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get sf error: no answer")
 		return 0
@@ -291,13 +335,12 @@ func RadioSetSpreadingFactor(sf uint8) bool {
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set sf %v", SFs[sf]))
+	n, answer, err := exchange(fmt.Sprintf("radio set sf %v", SFs[sf]))
 	if err != nil {
 		WARN.Println("radio set sf error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set sf error: invalid parameter")
 		return false
@@ -310,13 +353,12 @@ func RadioSetSpreadingFactor(sf uint8) bool {
 // if it is to be included during operation. The function will return
 // false as well if something went wrong.
 func RadioGetCrc() bool {
-	err := serialWrite("radio get crc")
+	n, answer, err := exchange("radio get crc")
 	if err != nil {
 		WARN.Println("radio get crc error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get crc error: no answer")
 		return false
@@ -340,13 +382,12 @@ func RadioSetCrc(on bool) bool {
 		state = "off"
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set crc %v", state))
+	n, answer, err := exchange(fmt.Sprintf("radio set crc %v", state))
 	if err != nil {
 		WARN.Println("radio set crc error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set crc error: invalid parameter")
 		return false
@@ -358,13 +399,12 @@ func RadioSetCrc(on bool) bool {
 // RadioGetIqi reads back the status of the Invert IQ functionality.
 // The function will return false as well if something went wrong.
 func RadioGetIqi() bool {
-	err := serialWrite("radio get iqi")
+	n, answer, err := exchange("radio get iqi")
 	if err != nil {
 		WARN.Println("radio get iqi error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get iqi error: no answer")
 		return false
@@ -388,13 +428,12 @@ func RadioSetIqi(on bool) bool {
 		state = "off"
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set iqi %v", state))
+	n, answer, err := exchange(fmt.Sprintf("radio set iqi %v", state))
 	if err != nil {
 		WARN.Println("radio set iqi error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set iqi error: invalid parameter")
 		return false
@@ -408,13 +447,12 @@ func RadioSetIqi(on bool) bool {
 // It will return an uint8 between [5, 8].
 // If an error occured, it will return 0.
 func RadioGetCodingRate() uint8 {
-	err := serialWrite("radio get cr")
+	n, answer, err := exchange("radio get cr")
 	if err != nil {
 		WARN.Println("radio get cr error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get cr error: no answer")
 		return 0
@@ -439,13 +477,12 @@ func RadioSetCodingRate(cr uint8) bool {
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set cr %v", CodingRates[cr]))
+	n, answer, err := exchange(fmt.Sprintf("radio set cr %v", CodingRates[cr]))
 	if err != nil {
 		WARN.Println("radio set cr error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set cr error: invalid parameter")
 		return false
@@ -459,13 +496,12 @@ func RadioSetCodingRate(cr uint8) bool {
 // It will return an uint32.
 // If an error occured, it will return 0 (this also means it is disabled).
 func RadioGetWatchDogTimer() uint32 {
-	err := serialWrite("radio get wdt")
+	n, answer, err := exchange("radio get wdt")
 	if err != nil {
 		WARN.Println("radio get wdt error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get wdt error: no answer")
 		return 0
@@ -488,13 +524,12 @@ func RadioGetWatchDogTimer() uint32 {
 // The function will return true if the command succeeded.
 // If an error occured, it will return false.
 func RadioSetWatchDogTimer(length uint32) bool {
-	err := serialWrite(fmt.Sprintf("radio set wdt %v", length))
+	n, answer, err := exchange(fmt.Sprintf("radio set wdt %v", length))
 	if err != nil {
 		WARN.Println("radio set wdt error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set wdt error: invalid parameter")
 		return false
@@ -507,13 +542,12 @@ func RadioSetWatchDogTimer(length uint32) bool {
 // and false when it is set to private.
 // The function will return false as well if something went wrong.
 func RadioGetSyncWord() bool {
-	err := serialWrite("radio get sync")
+	n, answer, err := exchange("radio get sync")
 	if err != nil {
 		WARN.Println("radio get sync error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get sync error: no answer")
 		return false
@@ -539,13 +573,12 @@ func RadioSetSyncWord(public bool) bool {
 		state = "12"
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set sync %v", state))
+	n, answer, err := exchange(fmt.Sprintf("radio set sync %v", state))
 	if err != nil {
 		WARN.Println("radio set sync error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set sync error: invalid parameter")
 		return false
@@ -559,13 +592,12 @@ func RadioSetSyncWord(public bool) bool {
 // It will return an uint16 with one of the values [125, 250, 500].
 // If an error occured, it will return 0.
 func RadioGetBandWidth() uint16 {
-	err := serialWrite("radio get bw")
+	n, answer, err := exchange("radio get bw")
 	if err != nil {
 		WARN.Println("radio get bw error:", err)
 		return 0
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get bw error: no answer")
 		return 0
@@ -591,13 +623,12 @@ func RadioSetBandWidth(bw uint16) bool {
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("radio set bw %v", BWs[bw]))
+	n, answer, err := exchange(fmt.Sprintf("radio set bw %v", BWs[bw]))
 	if err != nil {
 		WARN.Println("radio set bw error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) != "ok" {
 		WARN.Println("radio set bw error: invalid parameter")
 		return false
@@ -609,13 +640,12 @@ func RadioSetBandWidth(bw uint16) bool {
 // RadioGetSNR reads back the Signal Noise Ratio (SNR) for
 // the last received packet. The default is -128.
 func RadioGetSNR() int8 {
-	err := serialWrite("radio get snr")
+	n, answer, err := exchange("radio get snr")
 	if err != nil {
 		WARN.Println("radio get snr error:", err)
 		return -128
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("radio get s r error: no answer")
 		return -128
@@ -629,3 +659,211 @@ func RadioGetSNR() int8 {
 
 	return int8(value)
 }
+
+// RadioGetRSSI reads back the Received Signal Strength Indication (RSSI)
+// in dBm for the last received packet. It needs firmware with CapRadioRSSI.
+func RadioGetRSSI() (int16, error) {
+	if err := requireCapability(CapRadioRSSI); err != nil {
+		return 0, errors.Wrap(err, "could not get rssi")
+	}
+
+	answer, err := query("radio get rssi")
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get rssi")
+	}
+
+	value, err := strconv.ParseInt(answer, 10, 16)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get rssi")
+	}
+
+	return int16(value), nil
+}
+
+// radioGet reads back a radio parameter.
+func radioGet(param string) (string, error) {
+	answer, err := query("radio get " + param)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get %v", param)
+	}
+
+	return answer, nil
+}
+
+// radioGetUint reads back an unsigned radio parameter of the given bit size.
+func radioGetUint(param string, bits int) (uint64, error) {
+	answer, err := radioGet(param)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseUint(answer, 10, bits)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not get %v", param)
+	}
+
+	return value, nil
+}
+
+// radioSetParameter sets a radio parameter to the given value.
+func radioSetParameter(param string, value interface{}) error {
+	n, answer, err := exchange(fmt.Sprintf("radio set %v %v", param, value))
+	if err != nil {
+		return errors.Wrapf(err, "could not set %v", param)
+	}
+
+	if n == 0 || string(sanitize(answer)) != "ok" {
+		return errors.Errorf("could not set %v: invalid parameter", param)
+	}
+
+	return nil
+}
+
+// RadioGetBitRate reads back the FSK bit rate in bit/s.
+func RadioGetBitRate() (uint32, error) {
+	value, err := radioGetUint("bitrate", 32)
+	return uint32(value), err
+}
+
+// RadioSetBitRate sets the FSK bit rate in bit/s, between [1, 300000].
+func RadioSetBitRate(rate uint32) error {
+	if rate < 1 || rate > 300000 {
+		return errors.Errorf("could not set bitrate: %v out of range [1, 300000]", rate)
+	}
+
+	return radioSetParameter("bitrate", rate)
+}
+
+// RadioGetFrequencyDeviation reads back the FSK frequency deviation in Hz.
+func RadioGetFrequencyDeviation() (uint32, error) {
+	value, err := radioGetUint("fdev", 32)
+	return uint32(value), err
+}
+
+// RadioSetFrequencyDeviation sets the FSK frequency deviation in Hz,
+// between [0, 200000].
+func RadioSetFrequencyDeviation(fdev uint32) error {
+	if fdev > 200000 {
+		return errors.Errorf("could not set fdev: %v out of range [0, 200000]", fdev)
+	}
+
+	return radioSetParameter("fdev", fdev)
+}
+
+// validFSKBandWidth returns the bandwidth as the module expects it, or false
+// when it isn't one of FSKBandWidths.
+func validFSKBandWidth(bw float64) (string, bool) {
+	for _, b := range FSKBandWidths {
+		if b == bw {
+			return strconv.FormatFloat(bw, 'f', -1, 64), true
+		}
+	}
+
+	return "", false
+}
+
+// radioGetFSKBandWidth reads back an FSK bandwidth parameter in kHz.
+func radioGetFSKBandWidth(param string) (float64, error) {
+	answer, err := radioGet(param)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseFloat(answer, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not get %v", param)
+	}
+
+	return value, nil
+}
+
+// radioSetFSKBandWidth sets an FSK bandwidth parameter in kHz.
+func radioSetFSKBandWidth(param string, bw float64) error {
+	value, ok := validFSKBandWidth(bw)
+	if !ok {
+		return errors.Errorf("could not set %v: invalid bandwidth %v", param, bw)
+	}
+
+	return radioSetParameter(param, value)
+}
+
+// RadioGetRxBandWidth reads back the FSK receive bandwidth in kHz.
+func RadioGetRxBandWidth() (float64, error) {
+	return radioGetFSKBandWidth("rxbw")
+}
+
+// RadioSetRxBandWidth sets the FSK receive bandwidth in kHz, which should be
+// one of FSKBandWidths.
+func RadioSetRxBandWidth(bw float64) error {
+	return radioSetFSKBandWidth("rxbw", bw)
+}
+
+// RadioGetAFCBandWidth reads back the FSK automatic frequency correction
+// bandwidth in kHz.
+func RadioGetAFCBandWidth() (float64, error) {
+	return radioGetFSKBandWidth("afcbw")
+}
+
+// RadioSetAFCBandWidth sets the FSK automatic frequency correction bandwidth
+// in kHz, which should be one of FSKBandWidths.
+func RadioSetAFCBandWidth(bw float64) error {
+	return radioSetFSKBandWidth("afcbw", bw)
+}
+
+// RadioGetPreambleLength reads back the preamble length, in symbols for LoRa
+// and in bytes for FSK.
+func RadioGetPreambleLength() (uint16, error) {
+	value, err := radioGetUint("prlen", 16)
+	return uint16(value), err
+}
+
+// RadioSetPreambleLength sets the preamble length, in symbols for LoRa and
+// in bytes for FSK.
+func RadioSetPreambleLength(length uint16) error {
+	return radioSetParameter("prlen", length)
+}
+
+// RadioGetGaussianShaping reads back the Gaussian baseband data shaping used
+// for FSK, one of [none, 1.0, 0.5, 0.3].
+func RadioGetGaussianShaping() (string, error) {
+	return radioGet("bt")
+}
+
+// RadioSetGaussianShaping sets the Gaussian baseband data shaping used for
+// FSK to GaussianNone, Gaussian10, Gaussian05 or Gaussian03.
+func RadioSetGaussianShaping(bt string) error {
+	if !stringInList(bt, gaussianShapings) {
+		return errors.Errorf("could not set bt: invalid shaping %q", bt)
+	}
+
+	return radioSetParameter("bt", bt)
+}
+
+// RadioGetSyncWordHex reads back the sync word as a hexadecimal string.
+func RadioGetSyncWordHex() (string, error) {
+	answer, err := radioGet("sync")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(answer), nil
+}
+
+// RadioSetSyncWordHex sets the sync word from a hexadecimal string, which is
+// one byte for LoRa and up to eight bytes for FSK.
+func RadioSetSyncWordHex(sync string) error {
+	b, err := hex.DecodeString(sync)
+	if err != nil {
+		return errors.Wrap(err, "could not set sync")
+	}
+
+	if len(b) < 1 || len(b) > 8 {
+		return errors.Errorf("could not set sync: %v bytes out of range [1, 8]", len(b))
+	}
+
+	if len(b) > 1 && RadioGetModulation() != FSK {
+		return errors.Errorf("could not set sync: %v bytes only allowed for fsk", len(b))
+	}
+
+	return radioSetParameter("sync", strings.ToUpper(sync))
+}
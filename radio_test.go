@@ -24,6 +24,7 @@ package rn2483
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -35,7 +36,7 @@ func TestRadioRxBlockingWriteError(t *testing.T) {
 
 	defer resetOriginals()
 
-	if len(RadioRxBlocking(0)) > 0 {
+	if p, err := RadioRxBlocking(0); err == nil || len(p.Data) > 0 {
 		t.Errorf("RadioRxBlocking(%v) returned bytes while the serial write failed", 0)
 	}
 }
@@ -53,7 +54,7 @@ func TestRadioRxBlockingReadError(t *testing.T) {
 
 	defer resetOriginals()
 
-	if len(RadioRxBlocking(0)) > 0 {
+	if p, err := RadioRxBlocking(0); err == nil || len(p.Data) > 0 {
 		t.Errorf("RadioRxBlocking(%v) returned bytes while the serial read returned 0 bytes", 0)
 	}
 }
@@ -71,7 +72,7 @@ func TestRadioRxBlockingReadInvalidParam(t *testing.T) {
 
 	defer resetOriginals()
 
-	if len(RadioRxBlocking(0)) > 0 {
+	if p, err := RadioRxBlocking(0); err == nil || len(p.Data) > 0 {
 		t.Errorf("RadioRxBlocking(%v) returned bytes while the serial read returned invalid_param", 0)
 	}
 }
@@ -89,44 +90,86 @@ func TestRadioRxBlockingReadBusy(t *testing.T) {
 
 	defer resetOriginals()
 
-	if len(RadioRxBlocking(0)) > 0 {
+	if p, err := RadioRxBlocking(0); err == nil || len(p.Data) > 0 {
 		t.Errorf("RadioRxBlocking(%v) returned bytes while the serial read returned busy", 0)
 	}
 }
 
 func TestRadioRxBlockingFailure(t *testing.T) {
-	serialWrite = func(s string) error {
-		t.Logf("String written to serial: %v", s)
-		return nil
-	}
+	newFakeRadio(nil, "radio_err")
 
-	serialRead = func() (int, []byte) {
-		b := []byte("radio_err\r\n")
-		return len(b), b
+	defer resetOriginals()
+
+	if p, err := RadioRxBlocking(0); err != ErrRadioRxWindow || len(p.Data) > 0 {
+		t.Errorf("RadioRxBlocking(%v) returned bytes while the serial read returned radio_err", 0)
 	}
+}
+
+func TestRadioRxBlockingShortAnswer(t *testing.T) {
+	newFakeRadio(nil, "radio_rx")
 
 	defer resetOriginals()
 
-	if len(RadioRxBlocking(0)) > 0 {
-		t.Errorf("RadioRxBlocking(%v) returned bytes while the serial read returned radio_rx ...", 0)
+	if _, err := RadioRxBlocking(0); err == nil {
+		t.Errorf("RadioRxBlocking(%v) succeeded while the serial read returned a short radio_rx", 0)
 	}
 }
 
 func TestRadioRxBlockingSuccess(t *testing.T) {
-	serialWrite = func(s string) error {
-		t.Logf("String written to serial: %v", s)
-		return nil
+	newFakeRadio(map[string]string{
+		"radio get mod":  "lora",
+		"radio get freq": "868100000",
+		"radio get sf":   "sf9",
+		"radio get bw":   "125",
+		"radio get cr":   "4/5",
+		"radio get snr":  "-7",
+		"radio get rssi": "-105",
+	}, "radio_rx  5376656E")
+
+	defer resetOriginals()
+
+	p, err := RadioRxBlocking(0)
+	if err != nil {
+		t.Fatalf("RadioRxBlocking(%v) returned an error while the serial read returned radio_rx ...: %v", 0, err)
 	}
 
-	serialRead = func() (int, []byte) {
-		b := []byte("radio_rx  5376656E\r\n")
-		return len(b), b
+	if string(p.Data) != "Sven" {
+		t.Errorf("RadioRxBlocking(%v) = %q; should be Sven", 0, p.Data)
 	}
 
+	if p.Frequency != 868100000 || p.SpreadingFactor != 9 || p.BandWidth != 125 || p.CodingRate != 5 {
+		t.Errorf("RadioRxBlocking(%v) = %+v; has the wrong radio settings", 0, p)
+	}
+
+	if !p.HasSNR || p.SNR != -7 || !p.HasRSSI || p.RSSI != -105 || p.Time.IsZero() {
+		t.Errorf("RadioRxBlocking(%v) = %+v; has the wrong link metrics", 0, p)
+	}
+}
+
+func TestRadioRxBlockingFSK(t *testing.T) {
+	f := newFakeRadio(map[string]string{
+		"radio get mod":     "fsk",
+		"radio get freq":    "868100000",
+		"radio get bitrate": "50000",
+		"radio get fdev":    "25000",
+		"radio get rxbw":    "62.5",
+	}, "radio_rx  5376656E")
+
 	defer resetOriginals()
 
-	if len(RadioRxBlocking(0)) == 0 {
-		t.Errorf("RadioRxBlocking(%v) returned 0 bytes while the serial read returned radio_rx ...", 0)
+	p, err := RadioRxBlocking(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Modulation != FSK || p.BitRate != 50000 || p.FrequencyDeviation != 25000 || p.RxBandWidth != 62.5 {
+		t.Errorf("RadioRxBlocking(%v) = %+v; has the wrong radio settings", 0, p)
+	}
+
+	for _, cmd := range f.written {
+		if cmd == "radio get sf" || cmd == "radio get bw" || cmd == "radio get cr" {
+			t.Errorf("%v written in FSK mode", cmd)
+		}
 	}
 }
 
@@ -1601,3 +1644,124 @@ func TestRadioGetSNRSuccess(t *testing.T) {
 		t.Error("RadioGetSNR() returned value other than 5 while it should return 5")
 	}
 }
+
+func TestRadioFSKParameters(t *testing.T) {
+	commands := mockSerial(t, map[string]string{
+		"radio get bitrate": "50000",
+		"radio get fdev":    "25000",
+		"radio get rxbw":    "62.5",
+		"radio get afcbw":   "83.3",
+		"radio get prlen":   "5",
+		"radio get bt":      "0.5",
+		"radio get sync":    "c194c1",
+	})
+	defer resetOriginals()
+
+	if rate, err := RadioGetBitRate(); err != nil || rate != 50000 {
+		t.Errorf("RadioGetBitRate() = %v, %v; should be 50000", rate, err)
+	}
+	if fdev, err := RadioGetFrequencyDeviation(); err != nil || fdev != 25000 {
+		t.Errorf("RadioGetFrequencyDeviation() = %v, %v; should be 25000", fdev, err)
+	}
+	if bw, err := RadioGetRxBandWidth(); err != nil || bw != 62.5 {
+		t.Errorf("RadioGetRxBandWidth() = %v, %v; should be 62.5", bw, err)
+	}
+	if bw, err := RadioGetAFCBandWidth(); err != nil || bw != 83.3 {
+		t.Errorf("RadioGetAFCBandWidth() = %v, %v; should be 83.3", bw, err)
+	}
+	if prlen, err := RadioGetPreambleLength(); err != nil || prlen != 5 {
+		t.Errorf("RadioGetPreambleLength() = %v, %v; should be 5", prlen, err)
+	}
+	if bt, err := RadioGetGaussianShaping(); err != nil || bt != Gaussian05 {
+		t.Errorf("RadioGetGaussianShaping() = %v, %v; should be 0.5", bt, err)
+	}
+	if sync, err := RadioGetSyncWordHex(); err != nil || sync != "C194C1" {
+		t.Errorf("RadioGetSyncWordHex() = %v, %v; should be C194C1", sync, err)
+	}
+
+	*commands = nil
+	for _, err := range []error{
+		RadioSetBitRate(50000),
+		RadioSetFrequencyDeviation(25000),
+		RadioSetRxBandWidth(62.5),
+		RadioSetAFCBandWidth(3.1),
+		RadioSetPreambleLength(5),
+		RadioSetGaussianShaping(GaussianNone),
+	} {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	want := []string{
+		"radio set bitrate 50000",
+		"radio set fdev 25000",
+		"radio set rxbw 62.5",
+		"radio set afcbw 3.1",
+		"radio set prlen 5",
+		"radio set bt none",
+	}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q; should be %q", *commands, want)
+	}
+}
+
+func TestRadioFSKParametersInvalid(t *testing.T) {
+	commands := mockSerial(t, nil)
+	defer resetOriginals()
+
+	for name, err := range map[string]error{
+		"bitrate 0":      RadioSetBitRate(0),
+		"bitrate 300001": RadioSetBitRate(300001),
+		"fdev 200001":    RadioSetFrequencyDeviation(200001),
+		"rxbw 60":        RadioSetRxBandWidth(60),
+		"afcbw 500":      RadioSetAFCBandWidth(500),
+		"bt 0.7":         RadioSetGaussianShaping("0.7"),
+		"sync xx":        RadioSetSyncWordHex("xx"),
+		"sync 9 bytes":   RadioSetSyncWordHex("010203040506070809"),
+	} {
+		if err == nil {
+			t.Errorf("%v returned no error", name)
+		}
+	}
+
+	if len(*commands) != 0 {
+		t.Errorf("commands = %q; should be none", *commands)
+	}
+}
+
+func TestRadioSetSyncWordHex(t *testing.T) {
+	commands := mockSerial(t, map[string]string{"radio get mod": "lora"})
+	defer resetOriginals()
+
+	if err := RadioSetSyncWordHex("34"); err != nil {
+		t.Error(err)
+	}
+	if err := RadioSetSyncWordHex("c194c1"); err == nil {
+		t.Error("RadioSetSyncWordHex() allowed 3 bytes in LoRa mode")
+	}
+
+	want := []string{"radio set sync 34", "radio get mod"}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q; should be %q", *commands, want)
+	}
+
+	commands = mockSerial(t, map[string]string{"radio get mod": "fsk"})
+	if err := RadioSetSyncWordHex("c194c1"); err != nil {
+		t.Error(err)
+	}
+
+	want = []string{"radio get mod", "radio set sync C194C1"}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q; should be %q", *commands, want)
+	}
+}
+
+func TestRadioSetParameterRejected(t *testing.T) {
+	mockSerial(t, map[string]string{"radio set prlen": "invalid_param"})
+	defer resetOriginals()
+
+	if err := RadioSetPreambleLength(5); err == nil {
+		t.Error("RadioSetPreambleLength() returned no error while rejected")
+	}
+}
@@ -0,0 +1,331 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The names of the built-in radio presets
+const (
+	PresetLongRange = "long range SF12/125"
+	PresetFast      = "fast SF7/500"
+	PresetFSK       = "fsk 50 kbps"
+)
+
+// RadioConfig is the configuration of the radio transceiver. The LoRa values
+// are only used with LoRa modulation, the bit rate, frequency deviation,
+// bandwidths and shaping only with FSK modulation.
+// A zero preamble length, bit rate, frequency deviation or bandwidth is
+// left unchanged.
+type RadioConfig struct {
+	Modulation         string  `json:"mod"`
+	Frequency          uint32  `json:"freq"`
+	Power              int8    `json:"pwr"`
+	SpreadingFactor    uint8   `json:"sf"`
+	BandWidth          uint16  `json:"bw"`
+	CodingRate         uint8   `json:"cr"`
+	CRC                bool    `json:"crc"`
+	IQInversion        bool    `json:"iqi"`
+	SyncWord           string  `json:"sync"`
+	PreambleLength     uint16  `json:"prlen,omitempty"`
+	WatchDogTimer      uint32  `json:"wdt"`
+	BitRate            uint32  `json:"bitrate,omitempty"`
+	FrequencyDeviation uint32  `json:"fdev,omitempty"`
+	RxBandWidth        float64 `json:"rxbw,omitempty"`
+	AFCBandWidth       float64 `json:"afcbw,omitempty"`
+	GaussianShaping    string  `json:"bt,omitempty"`
+}
+
+// radioField is a radio parameter with the way to write it to the module.
+// The modulation is empty when the parameter is used by both. An optional
+// parameter is left unchanged in a device configuration when it is zero.
+type radioField struct {
+	name       string
+	modulation string
+	optional   bool
+	value      func(c *RadioConfig) interface{}
+	apply      func(c *RadioConfig) error
+}
+
+// radioFields are the radio parameters in the order they are applied.
+// The modulation goes first, as it decides which parameters are used, and
+// the frequency before the power, as the allowed power depends on the band.
+var radioFields = []radioField{
+	{name: "mod", value: func(c *RadioConfig) interface{} { return &c.Modulation },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetModulation(c.Modulation)) }},
+	{name: "freq", value: func(c *RadioConfig) interface{} { return &c.Frequency },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetFrequency(c.Frequency)) }},
+	{name: "pwr", value: func(c *RadioConfig) interface{} { return &c.Power },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetPower(c.Power)) }},
+	{name: "sf", modulation: LoRa, value: func(c *RadioConfig) interface{} { return &c.SpreadingFactor },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetSpreadingFactor(c.SpreadingFactor)) }},
+	{name: "bw", modulation: LoRa, value: func(c *RadioConfig) interface{} { return &c.BandWidth },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetBandWidth(c.BandWidth)) }},
+	{name: "cr", modulation: LoRa, value: func(c *RadioConfig) interface{} { return &c.CodingRate },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetCodingRate(c.CodingRate)) }},
+	{name: "iqi", modulation: LoRa, value: func(c *RadioConfig) interface{} { return &c.IQInversion },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetIqi(c.IQInversion)) }},
+	{name: "bitrate", modulation: FSK, optional: true, value: func(c *RadioConfig) interface{} { return &c.BitRate },
+		apply: func(c *RadioConfig) error { return RadioSetBitRate(c.BitRate) }},
+	{name: "fdev", modulation: FSK, optional: true, value: func(c *RadioConfig) interface{} { return &c.FrequencyDeviation },
+		apply: func(c *RadioConfig) error { return RadioSetFrequencyDeviation(c.FrequencyDeviation) }},
+	{name: "rxbw", modulation: FSK, optional: true, value: func(c *RadioConfig) interface{} { return &c.RxBandWidth },
+		apply: func(c *RadioConfig) error { return RadioSetRxBandWidth(c.RxBandWidth) }},
+	{name: "afcbw", modulation: FSK, optional: true, value: func(c *RadioConfig) interface{} { return &c.AFCBandWidth },
+		apply: func(c *RadioConfig) error { return RadioSetAFCBandWidth(c.AFCBandWidth) }},
+	{name: "bt", modulation: FSK, value: func(c *RadioConfig) interface{} { return &c.GaussianShaping },
+		apply: func(c *RadioConfig) error { return RadioSetGaussianShaping(c.GaussianShaping) }},
+	{name: "crc", value: func(c *RadioConfig) interface{} { return &c.CRC },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetCrc(c.CRC)) }},
+	{name: "sync", value: func(c *RadioConfig) interface{} { return &c.SyncWord },
+		apply: func(c *RadioConfig) error { return RadioSetSyncWordHex(c.SyncWord) }},
+	{name: "prlen", optional: true, value: func(c *RadioConfig) interface{} { return &c.PreambleLength },
+		apply: func(c *RadioConfig) error { return RadioSetPreambleLength(c.PreambleLength) }},
+	{name: "wdt", value: func(c *RadioConfig) interface{} { return &c.WatchDogTimer },
+		apply: func(c *RadioConfig) error { return radioSet(RadioSetWatchDogTimer(c.WatchDogTimer)) }},
+}
+
+// get returns the value of the parameter in the configuration.
+func (f radioField) get(c *RadioConfig) interface{} {
+	return reflect.ValueOf(f.value(c)).Elem().Interface()
+}
+
+// unset returns whether the parameter is optional and zero in the
+// configuration, so it is left unchanged.
+func (f radioField) unset(c *RadioConfig) bool {
+	v := f.get(c)
+	return f.optional && v == reflect.Zero(reflect.TypeOf(v)).Interface()
+}
+
+// set returns whether the parameter has a value in the configuration, as
+// optional parameters that are zero are left unchanged.
+func (c *RadioConfig) set(name string) bool {
+	for _, f := range radioFields {
+		if f.name == name {
+			return !f.unset(c)
+		}
+	}
+
+	return true
+}
+
+// usedBy returns whether the parameter is used with the modulation.
+func (f radioField) usedBy(modulation string) bool {
+	return f.modulation == "" || f.modulation == modulation
+}
+
+// radioConfigFields returns the radio parameters as device configuration
+// values. Optional parameters that are zero and parameters the modulation
+// doesn't use have no value, so they are left unchanged.
+func radioConfigFields() []configField {
+	var fields []configField
+
+	for _, f := range radioFields {
+		f := f
+		fields = append(fields, configField{
+			name: "radio." + f.name,
+			value: func(c *DeviceConfig) interface{} {
+				if c.Radio.Modulation != "" && !f.usedBy(c.Radio.Modulation) {
+					return nil
+				}
+
+				if f.unset(&c.Radio) {
+					return nil
+				}
+				return f.get(&c.Radio)
+			},
+			apply: func(c *DeviceConfig) error { return f.apply(&c.Radio) },
+			same:  sameRadioValue,
+		})
+	}
+
+	return fields
+}
+
+// RadioPreset returns the built-in radio configuration with the name on the
+// given frequency.
+func RadioPreset(name string, frequency uint32) (RadioConfig, error) {
+	c := RadioConfig{
+		Modulation:     LoRa,
+		Frequency:      frequency,
+		Power:          14,
+		CodingRate:     5,
+		CRC:            true,
+		SyncWord:       "12",
+		PreambleLength: 8,
+	}
+
+	switch name {
+	case PresetLongRange:
+		c.SpreadingFactor, c.BandWidth = 12, 125
+		c.WatchDogTimer = 15000
+	case PresetFast:
+		c.SpreadingFactor, c.BandWidth = 7, 500
+		c.WatchDogTimer = 2000
+	case PresetFSK:
+		c.Modulation = FSK
+		c.SyncWord = "C194C1"
+		c.PreambleLength = 5
+		c.WatchDogTimer = 2000
+		c.BitRate, c.FrequencyDeviation = 50000, 25000
+		c.RxBandWidth, c.AFCBandWidth = 62.5, 83.3
+		c.GaussianShaping = Gaussian05
+	default:
+		return RadioConfig{}, errors.Errorf("unknown radio preset %q", name)
+	}
+
+	return c, nil
+}
+
+// ReadRadioConfig reads the radio configuration from the module, including
+// the values that aren't used with the current modulation.
+func ReadRadioConfig() (*RadioConfig, error) {
+	c := &RadioConfig{}
+
+	for _, f := range radioFields {
+		answer, err := query("radio get " + f.name)
+		if err != nil {
+			return nil, errors.Wrap(err, "radio get "+f.name)
+		}
+
+		if err := parseConfigValue(answer, f.value(c)); err != nil {
+			return nil, errors.Wrap(err, "radio get "+f.name)
+		}
+	}
+
+	return c, nil
+}
+
+// sameRadioValue compares values read back from the module, ignoring the
+// case of hexadecimal strings.
+func sameRadioValue(a, b interface{}) bool {
+	if s, ok := a.(string); ok {
+		if t, ok := b.(string); ok {
+			return strings.EqualFold(s, t)
+		}
+	}
+
+	return a == b
+}
+
+// Validate checks the values used with the modulation of the configuration.
+func (c *RadioConfig) Validate() error {
+	if !stringInList(c.Modulation, modulations) {
+		return errors.Errorf("invalid modulation %q", c.Modulation)
+	}
+
+	if !validRadioFrequency(c.Frequency) {
+		return errors.Errorf("invalid frequency %v", c.Frequency)
+	}
+
+	if !validRadioPower(c.Power) {
+		return errors.Errorf("invalid power %v", c.Power)
+	}
+
+	sync, err := hex.DecodeString(c.SyncWord)
+	if err != nil || len(sync) < 1 || len(sync) > 8 {
+		return errors.Errorf("invalid sync word %q", c.SyncWord)
+	}
+
+	if c.Modulation == LoRa {
+		if _, ok := SFs[c.SpreadingFactor]; !ok {
+			return errors.Errorf("invalid spreading factor %v", c.SpreadingFactor)
+		}
+		if _, ok := BWs[c.BandWidth]; !ok {
+			return errors.Errorf("invalid bandwidth %v", c.BandWidth)
+		}
+		if _, ok := CodingRates[c.CodingRate]; !ok {
+			return errors.Errorf("invalid coding rate %v", c.CodingRate)
+		}
+		if len(sync) != 1 {
+			return errors.Errorf("invalid sync word %q: lora uses one byte", c.SyncWord)
+		}
+
+		return nil
+	}
+
+	if c.set("bitrate") && (c.BitRate < 1 || c.BitRate > 300000) {
+		return errors.Errorf("invalid bit rate %v", c.BitRate)
+	}
+	if c.FrequencyDeviation > 200000 {
+		return errors.Errorf("invalid frequency deviation %v", c.FrequencyDeviation)
+	}
+	if _, ok := validFSKBandWidth(c.RxBandWidth); c.set("rxbw") && !ok {
+		return errors.Errorf("invalid rx bandwidth %v", c.RxBandWidth)
+	}
+	if _, ok := validFSKBandWidth(c.AFCBandWidth); c.set("afcbw") && !ok {
+		return errors.Errorf("invalid afc bandwidth %v", c.AFCBandWidth)
+	}
+	if !stringInList(c.GaussianShaping, gaussianShapings) {
+		return errors.Errorf("invalid gaussian shaping %q", c.GaussianShaping)
+	}
+
+	return nil
+}
+
+// Apply validates the configuration, writes every value used with its
+// modulation that is set to the module in order and reads them back to
+// confirm.
+// The differences that remain are returned with the error.
+func (c *RadioConfig) Apply() ([]ConfigDifference, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, f := range radioFields {
+		if !f.usedBy(c.Modulation) || f.unset(c) {
+			continue
+		}
+
+		if err := f.apply(c); err != nil {
+			return nil, errors.Wrapf(err, "could not apply radio.%s", f.name)
+		}
+	}
+
+	current, err := ReadRadioConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var diff []ConfigDifference
+	for _, f := range radioFields {
+		if !f.usedBy(c.Modulation) || f.unset(c) {
+			continue
+		}
+
+		if have, want := f.get(current), f.get(c); !sameRadioValue(have, want) {
+			diff = append(diff, ConfigDifference{Field: "radio." + f.name, Current: have, Desired: want})
+		}
+	}
+
+	if len(diff) > 0 {
+		return diff, errors.Errorf("radio configuration not applied: %v", diff)
+	}
+
+	return nil, nil
+}
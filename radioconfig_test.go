@@ -0,0 +1,275 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeRadioSettings emulates the radio settings of the module, starting from
+// its defaults and answering every get with the last value set. Rejected parameters are answered with
+// invalid_param, ignored ones are accepted but not stored.
+func fakeRadioSettings(t *testing.T, rejected, ignored string) (*[]string, map[string]string) {
+	settings := map[string]string{
+		"mod": "lora", "freq": "868100000", "pwr": "1", "sf": "sf12", "bw": "125",
+		"cr": "4/5", "iqi": "off", "bitrate": "50000", "fdev": "25000", "rxbw": "25",
+		"afcbw": "41.7", "bt": "0.5", "crc": "on", "sync": "34", "prlen": "8", "wdt": "15000",
+	}
+	var written []string
+	var answer string
+
+	serialWrite = func(s string) error {
+		t.Logf("String written to serial: %v", s)
+		written = append(written, s)
+
+		fields := strings.Fields(s)
+		switch {
+		case len(fields) == 3 && fields[1] == "get":
+			answer = settings[fields[2]]
+		case len(fields) == 4 && fields[1] == "set" && fields[2] == rejected:
+			answer = invalidParameter
+		case len(fields) == 4 && fields[1] == "set":
+			if fields[2] != ignored {
+				settings[fields[2]] = fields[3]
+			}
+			answer = "ok"
+		default:
+			answer = "ok"
+		}
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		b := []byte(answer + "\r\n")
+		return len(b), b
+	}
+
+	return &written, settings
+}
+
+func TestRadioPresetsValid(t *testing.T) {
+	for _, name := range []string{PresetLongRange, PresetFast, PresetFSK} {
+		c, err := RadioPreset(name, 868100000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Validate(); err != nil {
+			t.Errorf("preset %q is invalid: %v", name, err)
+		}
+	}
+
+	if _, err := RadioPreset("unknown", 868100000); err == nil {
+		t.Error("RadioPreset() returned an unknown preset")
+	}
+}
+
+func TestRadioConfigValidate(t *testing.T) {
+	defer restoreModule()
+
+	lora, _ := RadioPreset(PresetLongRange, 868100000)
+	fsk, _ := RadioPreset(PresetFSK, 868100000)
+
+	tests := map[string]func(c *RadioConfig){
+		"modulation":    func(c *RadioConfig) { c.Modulation = "ook" },
+		"frequency":     func(c *RadioConfig) { c.Frequency = 915000000 },
+		"power":         func(c *RadioConfig) { c.Power = 20 },
+		"sync":          func(c *RadioConfig) { c.SyncWord = "" },
+		"lora sync":     func(c *RadioConfig) { c.SyncWord = "C194C1" },
+		"sf":            func(c *RadioConfig) { c.SpreadingFactor = 6 },
+		"bw":            func(c *RadioConfig) { c.BandWidth = 62 },
+		"cr":            func(c *RadioConfig) { c.CodingRate = 9 },
+		"fsk bitrate":   func(c *RadioConfig) { *c = fsk; c.BitRate = 400000 },
+		"fsk rxbw":      func(c *RadioConfig) { *c = fsk; c.RxBandWidth = 60 },
+		"fsk afcbw":     func(c *RadioConfig) { *c = fsk; c.AFCBandWidth = 300 },
+		"fsk fdev":      func(c *RadioConfig) { *c = fsk; c.FrequencyDeviation = 250000 },
+		"fsk shaping":   func(c *RadioConfig) { *c = fsk; c.GaussianShaping = "" },
+		"fsk long sync": func(c *RadioConfig) { *c = fsk; c.SyncWord = "010203040506070809" },
+	}
+
+	for name, change := range tests {
+		c := lora
+		change(&c)
+
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate() accepted an invalid %v", name)
+		}
+	}
+
+	// the fsk values aren't used with lora modulation
+	c := lora
+	c.RxBandWidth = 60
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRadioConfigApply(t *testing.T) {
+	defer resetOriginals()
+	written, settings := fakeRadioSettings(t, "", "")
+
+	c, _ := RadioPreset(PresetFast, 868100000)
+	if _, err := c.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	var set []string
+	for _, cmd := range *written {
+		if strings.HasPrefix(cmd, "radio set") {
+			set = append(set, cmd)
+		}
+	}
+
+	expected := []string{
+		"radio set mod lora",
+		"radio set freq 868100000",
+		"radio set pwr 14",
+		"radio set sf sf7",
+		"radio set bw 500",
+		"radio set cr 4/5",
+		"radio set iqi off",
+		"radio set crc on",
+		"radio set sync 12",
+		"radio set prlen 8",
+		"radio set wdt 2000",
+	}
+	if strings.Join(set, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("commands = %q; should be %q", set, expected)
+	}
+
+	if settings["sf"] != "sf7" || settings["bw"] != "500" {
+		t.Errorf("settings = %v", settings)
+	}
+}
+
+func TestRadioConfigApplyWithoutPreambleLength(t *testing.T) {
+	defer resetOriginals()
+	written, settings := fakeRadioSettings(t, "", "")
+
+	c, _ := RadioPreset(PresetLongRange, 868100000)
+	c.PreambleLength = 0
+	if _, err := c.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range *written {
+		if strings.HasPrefix(cmd, "radio set prlen") {
+			t.Errorf("%v written without a preamble length", cmd)
+		}
+	}
+
+	if settings["prlen"] != "8" {
+		t.Errorf("prlen = %v; should be left at 8", settings["prlen"])
+	}
+}
+
+func TestRadioConfigApplyFSK(t *testing.T) {
+	defer resetOriginals()
+	_, settings := fakeRadioSettings(t, "", "")
+
+	c, _ := RadioPreset(PresetFSK, 868100000)
+	c.SyncWord = "c194c1"
+	if _, err := c.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	if settings["sync"] != "C194C1" || settings["rxbw"] != "62.5" || settings["bt"] != "0.5" {
+		t.Errorf("settings = %v", settings)
+	}
+	if settings["sf"] != "sf12" {
+		t.Error("Apply() set the spreading factor in fsk mode")
+	}
+}
+
+func TestRadioConfigApplyFSKUnset(t *testing.T) {
+	defer resetOriginals()
+	written, settings := fakeRadioSettings(t, "", "")
+
+	c, _ := RadioPreset(PresetFSK, 868100000)
+	c.BitRate, c.RxBandWidth, c.AFCBandWidth = 0, 0, 0
+	if _, err := c.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range *written {
+		if strings.HasPrefix(cmd, "radio set bitrate") || strings.HasPrefix(cmd, "radio set rxbw") || strings.HasPrefix(cmd, "radio set afcbw") {
+			t.Errorf("%v written without a value", cmd)
+		}
+	}
+
+	if settings["mod"] != "fsk" || settings["bitrate"] != "50000" || settings["rxbw"] != "25" {
+		t.Errorf("settings = %v", settings)
+	}
+}
+
+func TestRadioConfigApplyVerify(t *testing.T) {
+	defer resetOriginals()
+	fakeRadioSettings(t, "", "wdt")
+
+	c, _ := RadioPreset(PresetFast, 868100000)
+	diff, err := c.Apply()
+	if err == nil {
+		t.Fatal("Apply() succeeded while the watchdog timer wasn't stored")
+	}
+
+	if len(diff) != 1 || diff[0].Field != "radio.wdt" {
+		t.Errorf("diff = %v; should only have radio.wdt", diff)
+	}
+}
+
+func TestRadioConfigApplyRejected(t *testing.T) {
+	defer resetOriginals()
+	written, _ := fakeRadioSettings(t, "bw", "")
+
+	c, _ := RadioPreset(PresetLongRange, 868100000)
+	if _, err := c.Apply(); err == nil || !strings.Contains(err.Error(), "radio.bw") {
+		t.Errorf("Apply() = %v; should fail on radio.bw", err)
+	}
+
+	if last := (*written)[len(*written)-1]; last != "radio set bw 125" {
+		t.Errorf("Apply() continued after the rejected bandwidth with %q", last)
+	}
+}
+
+func TestDeviceConfigVersion1(t *testing.T) {
+	defer resetOriginals()
+	mockSerial(t, configAnswers)
+
+	desired, err := LoadDeviceConfig(strings.NewReader(`{"version": 1, "radio": {"mod": "lora", "sf": 12}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := ReadDeviceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range current.Diff(desired) {
+		switch d.Field {
+		case "radio.prlen", "radio.bitrate", "radio.fdev", "radio.rxbw", "radio.afcbw", "radio.bt":
+			t.Errorf("version 1 configuration changes %v", d)
+		}
+	}
+}
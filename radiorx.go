@@ -0,0 +1,293 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRadioRxWindow is the error of a receiver whose window ended without packet.
+var ErrRadioRxWindow = errors.New("radio rx window ended without packet")
+
+// Packet is a packet received by the radio, with the radio settings it was
+// received with and its link metrics. Only the settings of the modulation
+// are set: the spreading factor, bandwidth and coding rate with LoRa, the
+// bit rate, frequency deviation and receive bandwidth with FSK. The SNR is
+// in dB and the RSSI in dBm.
+type Packet struct {
+	Data               []byte
+	Time               time.Time
+	Modulation         string
+	Frequency          uint32
+	SpreadingFactor    uint8
+	BandWidth          uint16
+	CodingRate         uint8
+	BitRate            uint32
+	FrequencyDeviation uint32
+	RxBandWidth        float64
+	SNR                int8
+	HasSNR             bool
+	RSSI               int16
+	HasRSSI            bool
+}
+
+// newPacket returns a packet with the current radio settings.
+func newPacket() Packet {
+	p := Packet{
+		Modulation: RadioGetModulation(),
+		Frequency:  RadioGetFrequency(),
+	}
+
+	switch p.Modulation {
+	case LoRa:
+		p.SpreadingFactor = RadioGetSpreadingFactor()
+		p.BandWidth = RadioGetBandWidth()
+		p.CodingRate = RadioGetCodingRate()
+	case FSK:
+		p.BitRate, _ = RadioGetBitRate()
+		p.FrequencyDeviation, _ = RadioGetFrequencyDeviation()
+		p.RxBandWidth, _ = RadioGetRxBandWidth()
+	}
+
+	return p
+}
+
+// received sets the data of the packet and fetches its link metrics,
+// right after the reception.
+func (p *Packet) received(data []byte) {
+	p.Data = data
+	p.Time = time.Now()
+
+	if snr := RadioGetSNR(); snr != -128 {
+		p.SNR = snr
+		p.HasSNR = true
+	}
+
+	if rssi, err := RadioGetRSSI(); err == nil {
+		p.RSSI = rssi
+		p.HasRSSI = true
+	}
+}
+
+// parseRadioRx decodes the payload of a radio_rx answer.
+func parseRadioRx(s string) ([]byte, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 || fields[0] != "radio_rx" {
+		return nil, errors.Errorf("invalid radio_rx answer %q", s)
+	}
+
+	data, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid radio_rx payload")
+	}
+
+	return data, nil
+}
+
+// RadioReceiver receives packets in the background, see RadioReceive.
+type RadioReceiver struct {
+	window  uint16
+	lease   *macPauseLease
+	packet  Packet
+	handler func(p Packet)
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// RadioReceive opens the receiver with the window like RadioRxBlocking, but
+// returns immediately and passes the received packets to the handler.
+// With window 0 the reception is continuous and the receiver is opened
+// again after every packet and watchdog time-out, until Stop is called.
+// Otherwise the receiver is done after the first packet or the end of
+// the window. When the LoRaWAN stack is active, it is paused while the
+// receiver is open, and resumed when it is done or stopped. Continuous
+// reception then needs a watchdog time-out, see RadioSetWatchDogTimer.
+func RadioReceive(window uint16, handler func(p Packet)) (*RadioReceiver, error) {
+	lease, err := pauseMac(func() (time.Duration, error) { return radioRxDuration(window) })
+	if err != nil {
+		return nil, err
+	}
+
+	packet := newPacket()
+
+	lockSerial()
+	err = radioRx(window)
+	unlockSerial()
+
+	if err != nil {
+		lease.release()
+		return nil, err
+	}
+
+	r := &RadioReceiver{
+		window:  window,
+		lease:   lease,
+		packet:  packet,
+		handler: handler,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+// radioRx opens the receiver. The caller holds the serial port.
+func radioRx(window uint16) error {
+	err := serialWrite(fmt.Sprintf("radio rx %v", window))
+	if err != nil {
+		return errors.Wrap(err, "could not open receiver")
+	}
+
+	n, answer := serialRead()
+	if n == 0 || string(sanitize(answer)) != "ok" {
+		return errors.Errorf("could not open receiver: %s", string(sanitize(answer)))
+	}
+
+	return nil
+}
+
+func (r *RadioReceiver) run() {
+	stopped := false
+	defer func() {
+		// after Stop, the radio is still receiving until radio rxstop
+		if !stopped {
+			r.lease.release()
+		}
+		close(r.done)
+	}()
+
+	for {
+		select {
+		case <-r.stop:
+			stopped = true
+			return
+		default:
+		}
+
+		n, answer := receive()
+		if n == 0 {
+			continue
+		}
+
+		for _, line := range strings.Split(string(answer), "\r\n") {
+			switch {
+			case strings.HasPrefix(line, "radio_rx"):
+				data, err := parseRadioRx(line)
+				if err != nil {
+					WARN.Println("radio receive error:", err)
+				} else {
+					p := r.packet
+					p.received(data)
+
+					if r.handler != nil {
+						r.handler(p)
+					}
+				}
+			case line == "radio_err":
+			default:
+				continue
+			}
+
+			if r.window != 0 {
+				if line == "radio_err" {
+					r.err = ErrRadioRxWindow
+				}
+				return
+			}
+
+			if err := r.lease.extend(); err != nil {
+				r.err = err
+				return
+			}
+
+			lockSerial()
+			err := radioRx(0)
+			unlockSerial()
+
+			if err != nil {
+				r.err = err
+				return
+			}
+		}
+	}
+}
+
+// Done returns a channel that is closed when the receiver is done.
+func (r *RadioReceiver) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns why the receiver is done: nil after a packet or Stop,
+// ErrRadioRxWindow when the window ended, or the error of reopening the
+// continuous receiver.
+func (r *RadioReceiver) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Stop stops the receiver and the reception of the radio with radio rxstop.
+// On firmware without radio rxstop, the radio keeps receiving until its
+// watchdog time-out and an error with ErrUnsupported as cause is returned.
+// The LoRaWAN stack is resumed in any case.
+func (r *RadioReceiver) Stop() error {
+	select {
+	case <-r.done:
+		// the radio is no longer receiving
+		return nil
+	default:
+	}
+
+	r.once.Do(func() { close(r.stop) })
+	<-r.done
+
+	defer r.lease.release()
+
+	if err := requireCapability(CapRadioRxStop); err != nil {
+		return errors.Wrap(err, "could not stop receiver")
+	}
+
+	answer, err := query("radio rxstop")
+	if err != nil {
+		return errors.Wrap(err, "could not stop receiver")
+	}
+
+	if answer != "ok" {
+		return errors.Errorf("could not stop receiver: %s", answer)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakeRadio answers every command with its answer or ok, and then returns
+// the queued lines, one per read.
+type fakeRadio struct {
+	mu      sync.Mutex
+	answers map[string]string
+	answer  string
+	lines   []string
+	written []string
+}
+
+func newFakeRadio(answers map[string]string, lines ...string) *fakeRadio {
+	f := &fakeRadio{answers: answers, lines: lines}
+
+	serialWrite = func(s string) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.written = append(f.written, s)
+		f.answer = "ok"
+		if answer, ok := f.answers[s]; ok {
+			f.answer = answer
+		}
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		var line string
+		switch {
+		case f.answer != "":
+			line, f.answer = f.answer, ""
+		case len(f.lines) > 0:
+			line, f.lines = f.lines[0], f.lines[1:]
+		default:
+			time.Sleep(time.Millisecond)
+			return 0, nil
+		}
+
+		b := []byte(line + "\r\n")
+		return len(b), b
+	}
+
+	return f
+}
+
+// commands returns the written commands, except the radio get commands.
+func (f *fakeRadio) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var commands []string
+	for _, cmd := range f.written {
+		if !strings.HasPrefix(cmd, "radio get") {
+			commands = append(commands, cmd)
+		}
+	}
+
+	return commands
+}
+
+func TestRadioReceiveWindow(t *testing.T) {
+	defer resetOriginals()
+	newFakeRadio(nil, "radio_rx  48656C6C6F")
+
+	var packets []Packet
+	r, err := RadioReceive(100, func(p Packet) { packets = append(packets, p) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Fatal("receiver not done after the packet")
+	}
+
+	if r.Err() != nil {
+		t.Errorf("Err() = %v; should be nil", r.Err())
+	}
+	if len(packets) != 1 || string(packets[0].Data) != "Hello" {
+		t.Errorf("packets = %v; should be Hello", packets)
+	}
+}
+
+func TestRadioReceiveWindowEnded(t *testing.T) {
+	defer resetOriginals()
+	newFakeRadio(nil, "radio_err")
+
+	r, err := RadioReceive(100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-r.Done()
+	if r.Err() != ErrRadioRxWindow {
+		t.Errorf("Err() = %v; should be %v", r.Err(), ErrRadioRxWindow)
+	}
+}
+
+func TestRadioReceiveContinuous(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	knownFirmware()
+	f := newFakeRadio(nil, "radio_rx  01", "radio_err", "radio_rx  0203")
+
+	received := make(chan Packet, 2)
+	r, err := RadioReceive(0, func(p Packet) { received <- p })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"[1]", "[2 3]"} {
+		select {
+		case p := <-received:
+			if fmt.Sprint(p.Data) != expected {
+				t.Errorf("packet = %v; should be %v", p.Data, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("packet not received")
+		}
+	}
+
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"radio rx 0", "radio rx 0", "radio rx 0", "radio rx 0", "radio rxstop"}
+	if fmt.Sprint(f.commands()) != fmt.Sprint(expected) {
+		t.Errorf("commands = %v; should be %v", f.commands(), expected)
+	}
+}
+
+func TestRadioReceiveStopUnsupported(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	f := newFakeRadio(nil)
+
+	firmware = &FirmwareVersion{Model: ModuleRN2483, Major: 1, Minor: 0, Patch: 1}
+
+	r, err := RadioReceive(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Stop(); errors.Cause(err) != ErrUnsupported {
+		t.Errorf("Stop() = %v; should be unsupported", err)
+	}
+
+	select {
+	case <-r.Done():
+	default:
+		t.Error("receiver still running after Stop")
+	}
+
+	if len(f.commands()) != 1 {
+		t.Errorf("commands = %v; should only open the receiver", f.commands())
+	}
+}
+
+func TestRadioReceiveBusy(t *testing.T) {
+	defer resetOriginals()
+	mockSerial(t, map[string]string{"radio rx": "busy"})
+
+	if _, err := RadioReceive(0, nil); err == nil {
+		t.Error("RadioReceive() succeeded while the radio was busy")
+	}
+}
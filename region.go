@@ -0,0 +1,264 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+// FrequencyRange is a range of frequencies in Hz.
+type FrequencyRange struct {
+	Min uint32
+	Max uint32
+}
+
+// DataRate describes a LoRaWAN data rate: the modulation with its spreading
+// factor and bandwidth (in kHz) for LoRa or its bit rate for FSK, and the
+// maximum application payload in bytes.
+type DataRate struct {
+	Modulation      string
+	SpreadingFactor uint8
+	BandWidth       uint16
+	BitRate         uint32
+	MaxPayload      int
+}
+
+// Region describes the regional parameters of a LoRaWAN band.
+type Region struct {
+	Name string
+	// Band is the band passed to mac reset.
+	Band uint16
+	// Frequencies are the frequency ranges that may be used.
+	Frequencies []FrequencyRange
+	// Channels is the number of channels, of which the first ones are
+	// the default channels, if the region has any.
+	Channels        uint8
+	DefaultChannels []uint32
+	DataRates       map[uint8]DataRate
+	// TXPower maps the power indexes to the output power in dBm.
+	TXPower      map[uint8]int8
+	RX2DataRate  uint8
+	RX2Frequency uint32
+	// SubBands are the duty cycle sub-bands.
+	SubBands []SubBand
+}
+
+// EU868 is the 863-870 MHz band of the RN2483.
+var EU868 = &Region{
+	Name:            "EU868",
+	Band:            868,
+	Frequencies:     []FrequencyRange{{863000000, 870000000}},
+	Channels:        16,
+	DefaultChannels: []uint32{868100000, 868300000, 868500000},
+	DataRates: map[uint8]DataRate{
+		0: {LoRa, 12, 125, 0, 51},
+		1: {LoRa, 11, 125, 0, 51},
+		2: {LoRa, 10, 125, 0, 51},
+		3: {LoRa, 9, 125, 0, 115},
+		4: {LoRa, 8, 125, 0, 222},
+		5: {LoRa, 7, 125, 0, 222},
+		6: {LoRa, 7, 250, 0, 222},
+		7: {FSK, 0, 0, 50000, 222},
+	},
+	TXPower: map[uint8]int8{
+		1: 14,
+		2: 11,
+		3: 8,
+		4: 5,
+		5: 2,
+	},
+	RX2DataRate:  0,
+	RX2Frequency: 869525000,
+	SubBands: []SubBand{
+		{"g863", 863000000, 865000000, 0.001},
+		{"g865", 865000000, 868000000, 0.01},
+		{"g1", 868000000, 868600000, 0.01},
+		{"g868.6", 868600000, 868700000, 0.001},
+		{"g2", 868700000, 869200000, 0.001},
+		{"g869.2", 869200000, 869400000, 0.001},
+		{"g3", 869400000, 869650000, 0.1},
+		{"g869.65", 869650000, 869700000, 0.001},
+		{"g4", 869700000, 870000000, 0.01},
+	},
+}
+
+// EU433 is the 433.05-434.79 MHz band of the RN2483.
+var EU433 = &Region{
+	Name:            "EU433",
+	Band:            433,
+	Frequencies:     []FrequencyRange{{433050000, 434790000}},
+	Channels:        16,
+	DefaultChannels: []uint32{433175000, 433375000, 433575000},
+	DataRates: map[uint8]DataRate{
+		0: {LoRa, 12, 125, 0, 51},
+		1: {LoRa, 11, 125, 0, 51},
+		2: {LoRa, 10, 125, 0, 51},
+		3: {LoRa, 9, 125, 0, 115},
+		4: {LoRa, 8, 125, 0, 222},
+		5: {LoRa, 7, 125, 0, 222},
+		6: {LoRa, 7, 250, 0, 222},
+		7: {FSK, 0, 0, 50000, 222},
+	},
+	TXPower: map[uint8]int8{
+		0: 10,
+		1: 7,
+		2: 4,
+		3: 1,
+		4: -2,
+		5: -5,
+	},
+	RX2DataRate:  0,
+	RX2Frequency: 434665000,
+	SubBands: []SubBand{
+		{"h1.4", 433050000, 434790000, 0.1},
+	},
+}
+
+// US915 is the 902-928 MHz band of the RN2903. Data rates 8 to 13 are only
+// used for downlinks. Its 64 125 kHz and 8 500 kHz channels follow a fixed
+// plan selected with the channel status, so there are no default channels
+// to protect: the module itself decides which frequencies it accepts.
+var US915 = &Region{
+	Name:        "US915",
+	Band:        915,
+	Frequencies: []FrequencyRange{{902000000, 928000000}},
+	Channels:    72,
+	DataRates: map[uint8]DataRate{
+		0:  {LoRa, 10, 125, 0, 11},
+		1:  {LoRa, 9, 125, 0, 53},
+		2:  {LoRa, 8, 125, 0, 125},
+		3:  {LoRa, 7, 125, 0, 242},
+		4:  {LoRa, 8, 500, 0, 242},
+		8:  {LoRa, 12, 500, 0, 53},
+		9:  {LoRa, 11, 500, 0, 129},
+		10: {LoRa, 10, 500, 0, 242},
+		11: {LoRa, 9, 500, 0, 242},
+		12: {LoRa, 8, 500, 0, 242},
+		13: {LoRa, 7, 500, 0, 242},
+	},
+	TXPower: map[uint8]int8{
+		5:  20,
+		7:  16,
+		8:  14,
+		9:  12,
+		10: 10,
+	},
+	RX2DataRate:  8,
+	RX2Frequency: 923300000,
+}
+
+var (
+	// region is the region the LoRaWAN stack is configured for
+	region = EU868
+	// moduleRegions are the regions supported by the module
+	moduleRegions = []*Region{EU868, EU433}
+)
+
+// CurrentRegion returns the region the LoRaWAN stack is configured for.
+// It is set by MacReset.
+func CurrentRegion() *Region {
+	return region
+}
+
+// SetRegion sets the region used to validate the LoRaWAN parameters,
+// without resetting the LoRaWAN stack.
+func SetRegion(r *Region) {
+	if r != nil {
+		region = r
+	}
+}
+
+// regionForBand returns the region of the band, if the module supports it.
+func regionForBand(band uint16) *Region {
+	for _, r := range moduleRegions {
+		if r.Band == band {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// validRadioFrequency returns whether the frequency can be used by the radio
+// of the module, in any of its regions.
+func validRadioFrequency(frequency uint32) bool {
+	for _, r := range moduleRegions {
+		if r.ValidFrequency(frequency) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// moduleSubBands returns the duty cycle sub-bands of all regions of the module.
+func moduleSubBands() []SubBand {
+	var subBands []SubBand
+
+	for _, r := range moduleRegions {
+		subBands = append(subBands, r.SubBands...)
+	}
+
+	return subBands
+}
+
+// ValidFrequency returns whether the frequency is in the region and, when
+// the region has duty cycle sub-bands, in one of them.
+func (r *Region) ValidFrequency(frequency uint32) bool {
+	if len(r.SubBands) > 0 && subBandIndex(r.SubBands, frequency) < 0 {
+		return false
+	}
+
+	for _, f := range r.Frequencies {
+		if frequency >= f.Min && frequency <= f.Max {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidDataRate returns whether the data rate exists in the region.
+func (r *Region) ValidDataRate(dr uint8) bool {
+	_, ok := r.DataRates[dr]
+	return ok
+}
+
+// MaxPayload returns the maximum application payload for the data rate.
+func (r *Region) MaxPayload(dr uint8) (int, bool) {
+	d, ok := r.DataRates[dr]
+	return d.MaxPayload, ok
+}
+
+// ValidPowerIndex returns whether the power index exists in the region.
+func (r *Region) ValidPowerIndex(index uint8) bool {
+	_, ok := r.TXPower[index]
+	return ok
+}
+
+// ValidChannel returns whether the channel ID exists in the region.
+func (r *Region) ValidChannel(channelID uint8) bool {
+	return channelID < r.Channels
+}
+
+// IsDefaultChannel returns whether the channel is one of the default
+// channels, which can't be modified.
+func (r *Region) IsDefaultChannel(channelID uint8) bool {
+	return int(channelID) < len(r.DefaultChannels)
+}
@@ -0,0 +1,157 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegionForBand(t *testing.T) {
+	tests := map[uint16]*Region{
+		868: EU868,
+		433: EU433,
+		915: nil,
+		0:   nil,
+	}
+
+	for band, r := range tests {
+		if got := regionForBand(band); got != r {
+			t.Errorf("regionForBand(%v) = %v; should be %v", band, got, r)
+		}
+	}
+}
+
+func TestRegionValidation(t *testing.T) {
+	tests := []struct {
+		r          *Region
+		frequency  uint32
+		dr         uint8
+		power      uint8
+		channel    uint8
+		maxPayload int
+	}{
+		{EU868, 868100000, 7, 1, 15, 222},
+		{EU433, 433175000, 0, 0, 15, 51},
+		{US915, 902300000, 13, 5, 71, 242},
+	}
+
+	for _, test := range tests {
+		if !test.r.ValidFrequency(test.frequency) {
+			t.Errorf("%v: ValidFrequency(%v) = false; should be true", test.r.Name, test.frequency)
+		}
+		if !test.r.ValidDataRate(test.dr) {
+			t.Errorf("%v: ValidDataRate(%v) = false; should be true", test.r.Name, test.dr)
+		}
+		if !test.r.ValidPowerIndex(test.power) {
+			t.Errorf("%v: ValidPowerIndex(%v) = false; should be true", test.r.Name, test.power)
+		}
+		if !test.r.ValidChannel(test.channel) || test.r.ValidChannel(test.channel+1) {
+			t.Errorf("%v: %v should be the last valid channel", test.r.Name, test.channel)
+		}
+		if max, ok := test.r.MaxPayload(test.dr); !ok || max != test.maxPayload {
+			t.Errorf("%v: MaxPayload(%v) = %v, %v; should be %v", test.r.Name, test.dr, max, ok, test.maxPayload)
+		}
+	}
+
+	if EU868.ValidPowerIndex(0) {
+		t.Error("EU868: power index 0 should be invalid")
+	}
+	if US915.ValidDataRate(5) {
+		t.Error("US915: data rate 5 should be invalid")
+	}
+	if EU868.ValidFrequency(433175000) {
+		t.Error("EU868: 433175000 should be invalid")
+	}
+
+	// the valid frequencies are the ones the duty cycle tracker accepts
+	d := NewDutyCycleTracker(DutyCycleRefuse)
+	for _, frequency := range []uint32{863000000, 868650000, 869300000, 869675000, 869999999, 870000000, 434789999, 434790000} {
+		_, ok := d.SubBand(frequency)
+		if valid := EU868.ValidFrequency(frequency) || EU433.ValidFrequency(frequency); valid != ok {
+			t.Errorf("ValidFrequency(%v) = %v; the frequency has a sub-band: %v", frequency, valid, ok)
+		}
+	}
+	if EU868.ValidFrequency(870000000) {
+		t.Error("EU868: 870000000 should be invalid")
+	}
+}
+
+func TestMacResetSetsRegion(t *testing.T) {
+	defer resetOriginals()
+	defer SetRegion(EU868)
+	mockSerial(t, nil)
+
+	if !MacReset(433) {
+		t.Fatal("MacReset(433) = false; should be true")
+	}
+	if CurrentRegion() != EU433 {
+		t.Errorf("CurrentRegion() = %v; should be EU433", CurrentRegion().Name)
+	}
+
+	if err := MacSetChannelFrequency(3, 868100000); err == nil {
+		t.Error("MacSetChannelFrequency accepted a 868 MHz frequency in EU433")
+	}
+	if err := MacSetChannelFrequency(3, 433375000); err != nil {
+		t.Errorf("MacSetChannelFrequency(3, 433375000) = %v; should be nil", err)
+	}
+}
+
+func TestMacSetChannelFrequencyDefaultChannel(t *testing.T) {
+	defer resetOriginals()
+	written := mockSerial(t, nil)
+
+	if err := MacSetChannelFrequency(2, 868500000); err == nil {
+		t.Error("MacSetChannelFrequency modified a default channel")
+	}
+	if len(*written) != 0 {
+		t.Errorf("commands written = %v; should be none", *written)
+	}
+}
+
+func TestMacSetChannelFrequencyUS915(t *testing.T) {
+	defer resetOriginals()
+	defer SetRegion(EU868)
+	written := mockSerial(t, nil)
+
+	SetRegion(US915)
+
+	if US915.IsDefaultChannel(0) {
+		t.Error("US915: channel 0 should not be a default channel")
+	}
+
+	if err := MacSetChannelFrequency(0, 902300000); err != nil {
+		t.Errorf("MacSetChannelFrequency(0, 902300000) = %v; should be nil", err)
+	}
+	if err := MacSetChannelFrequency(71, 927500000); err != nil {
+		t.Errorf("MacSetChannelFrequency(71, 927500000) = %v; should be nil", err)
+	}
+	if err := MacSetChannelFrequency(8, 868100000); err == nil {
+		t.Error("MacSetChannelFrequency accepted a 868 MHz frequency in US915")
+	}
+
+	want := []string{"mac set ch freq 0 902300000", "mac set ch freq 71 927500000"}
+	if !reflect.DeepEqual(*written, want) {
+		t.Errorf("commands written = %v; should be %v", *written, want)
+	}
+}
@@ -0,0 +1,202 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// bannerPattern matches the firmware banner printed by the module on boot,
+// like "RN2483 1.0.1 Dec 15 2015 09:38:09".
+var bannerPattern = regexp.MustCompile(`^RN\d{4}\w* \d+\.\d+\.\d+ `)
+
+// ResetEvent reports a boot of the module.
+// An unexpected reset was not caused by Reset, like a brownout or watchdog reset.
+type ResetEvent struct {
+	Time     time.Time
+	Banner   string
+	Expected bool
+	// SessionLost is set when a joined LoRaWAN session was lost
+	SessionLost bool
+	// Recovered is set when the recovery profile was applied, see SetRecoveryProfile
+	Recovered bool
+	Err       error
+}
+
+// RecoveryProfile is the configuration that is restored after an
+// unexpected reset of the module.
+type RecoveryProfile struct {
+	// Config is applied with ApplyDeviceConfig, when set
+	Config *DeviceConfig
+	// Setup is called after the configuration is applied, when set,
+	// for what is not part of the configuration, like the keys
+	Setup func() error
+	// JoinMode is used to rejoin when a joined session was lost,
+	// an empty mode does not rejoin
+	JoinMode string
+}
+
+var (
+	resetHandler    func(e ResetEvent)
+	recoveryProfile *RecoveryProfile
+	recovering      bool
+	// pendingResets are the resets read but not handled yet, guarded by
+	// serialMu
+	pendingResets []ResetEvent
+	// resetHook is set in init, as the recovery writes to the serial
+	// port and read can't refer to it during the initialization
+	resetHook func(e ResetEvent)
+)
+
+func init() {
+	resetHook = handleReset
+}
+
+// SetResetHandler registers the function that is called on every boot of the
+// module. It is called from the goroutine that reads the banner, after the
+// recovery. Passing nil removes the handler.
+func SetResetHandler(h func(e ResetEvent)) {
+	resetHandler = h
+}
+
+// SetRecoveryProfile registers the profile restored after an unexpected
+// reset. The recovery runs when the command that reads the banner releases
+// the serial port, before it returns. Passing nil disables the recovery.
+func SetRecoveryProfile(p *RecoveryProfile) {
+	recoveryProfile = p
+}
+
+// expectReset marks the next banner as the result of a requested reset,
+// until the banner timeout passes.
+func expectReset() {
+	state.resetExpected = time.Now().Add(bannerTimeout)
+}
+
+// filterBanner removes the boot banner from the bytes read from the module
+// and queues the reset it reports for unlockSerial, see queueReset. The answer to
+// sys get ver looks like the banner and is left alone, as is a banner a
+// command waits for.
+func filterBanner(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+
+	var kept [][]byte
+	version := state.lastCommand == "sys get ver"
+
+	for _, line := range bytes.SplitAfter(b, []byte("\r\n")) {
+		text := string(bytes.TrimRight(line, "\r\n"))
+
+		if bannerPattern.MatchString(text) {
+			switch {
+			case version:
+				version = false
+			case state.bannerWanted:
+				state.bannerWanted = false
+				queueReset(text)
+			default:
+				queueReset(text)
+				continue
+			}
+		}
+
+		kept = append(kept, line)
+	}
+
+	return bytes.Join(kept, nil)
+}
+
+// queueReset marks the session as lost and queues the reset for
+// unlockSerial. It runs while the serial port is locked, so the state is
+// updated before the next command.
+func queueReset(banner string) {
+	e := ResetEvent{
+		Time:        time.Now(),
+		Banner:      banner,
+		Expected:    time.Now().Before(state.resetExpected),
+		SessionLost: state.joined,
+	}
+
+	state.resetExpected = time.Time{}
+	state.joined = false
+	state.macPaused = false
+	state.macIdle = true
+
+	if v, err := ParseFirmwareVersion(banner); err == nil {
+		firmware = &v
+	}
+
+	pendingResets = append(pendingResets, e)
+}
+
+// handleReset applies the recovery profile after an unexpected reset and
+// notifies the reset handler.
+func handleReset(e ResetEvent) {
+	if e.Expected {
+		DEBUG.Println("module reset:", e.Banner)
+	} else {
+		WARN.Println("unexpected module reset:", e.Banner)
+
+		if recoveryProfile != nil && !recovering {
+			recovering = true
+			e.Err = recoveryProfile.recover(e.SessionLost)
+			e.Recovered = e.Err == nil
+			recovering = false
+
+			if e.Err != nil {
+				WARN.Println("module recovery error:", e.Err)
+			}
+		}
+	}
+
+	if resetHandler != nil {
+		resetHandler(e)
+	}
+}
+
+// recover restores the profile and rejoins when the session was lost.
+func (p *RecoveryProfile) recover(sessionLost bool) error {
+	if p.Config != nil {
+		if _, err := ApplyDeviceConfig(p.Config); err != nil {
+			return errors.Wrap(err, "could not apply configuration")
+		}
+	}
+
+	if p.Setup != nil {
+		if err := p.Setup(); err != nil {
+			return errors.Wrap(err, "could not set up module")
+		}
+	}
+
+	if sessionLost && p.JoinMode != "" {
+		if !MacJoin(p.JoinMode) {
+			return errors.New("could not rejoin")
+		}
+	}
+
+	return nil
+}
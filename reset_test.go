@@ -0,0 +1,238 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testBanner = "RN2483 1.0.1 Dec 15 2015 09:38:09"
+
+func resetRecovery() {
+	SetResetHandler(nil)
+	SetRecoveryProfile(nil)
+	*state = myState{}
+	firmware = nil
+	pendingResets = nil
+}
+
+// handlePendingResets handles the resets filtered from the reads, see
+// unlockSerial.
+func handlePendingResets() {
+	lockSerial()
+	unlockSerial()
+}
+
+func TestFilterBannerVersion(t *testing.T) {
+	defer resetRecovery()
+
+	var events []ResetEvent
+	SetResetHandler(func(e ResetEvent) { events = append(events, e) })
+
+	state.lastCommand = "sys get ver"
+	b := []byte(testBanner + "\r\n")
+
+	if got := filterBanner(b); string(got) != string(b) {
+		t.Errorf("filterBanner() = %q; should keep the answer to sys get ver", got)
+	}
+	handlePendingResets()
+	if len(events) != 0 {
+		t.Errorf("events = %v; should be none", events)
+	}
+}
+
+func TestFilterBannerExpected(t *testing.T) {
+	defer resetOriginals()
+	defer resetRecovery()
+	mockSerial(t, map[string]string{"sys reset": testBanner})
+
+	var events []ResetEvent
+	SetResetHandler(func(e ResetEvent) { events = append(events, e) })
+	SetRecoveryProfile(&RecoveryProfile{Setup: func() error {
+		t.Error("recovery profile applied after an expected reset")
+		return nil
+	}})
+
+	state.joined = true
+	Reset()
+
+	if got := filterBanner([]byte(testBanner + "\r\n")); len(got) != 0 {
+		t.Errorf("filterBanner() = %q; should be empty", got)
+	}
+	handlePendingResets()
+	if len(events) != 1 || !events[0].Expected || events[0].Banner != testBanner {
+		t.Errorf("events = %+v; should be one expected reset", events)
+	}
+	if Joined() {
+		t.Error("Joined() = true after a reset")
+	}
+}
+
+func TestFilterBannerRecovery(t *testing.T) {
+	defer resetOriginals()
+	defer resetRecovery()
+	var written []string
+	answers := []string{"ok", "ok", "accepted"}
+
+	serialWrite = func(s string) error {
+		written = append(written, s)
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		if len(answers) == 0 {
+			return 0, nil
+		}
+		b := []byte(answers[0] + "\r\n")
+		answers = answers[1:]
+		return len(b), b
+	}
+
+	var events []ResetEvent
+	SetResetHandler(func(e ResetEvent) { events = append(events, e) })
+
+	setup := 0
+	SetRecoveryProfile(&RecoveryProfile{
+		Setup:    func() error { setup++; return MacSetADR(true) },
+		JoinMode: OTAA,
+	})
+
+	state.joined = true
+	state.lastCommand = "mac get dr"
+
+	got := filterBanner([]byte(testBanner + "\r\n5\r\n"))
+	if string(got) != "5\r\n" {
+		t.Errorf("filterBanner() = %q; should be the answer after the banner", got)
+	}
+
+	// the recovery waits until the read is done
+	if setup != 0 {
+		t.Error("recovery started while filtering the read")
+	}
+	// but the state is updated before the serial port is released
+	if Joined() || firmware == nil {
+		t.Error("state not updated while filtering the read")
+	}
+	handlePendingResets()
+
+	if setup != 1 {
+		t.Errorf("setup called %v times; should be 1", setup)
+	}
+	if fmt.Sprint(written) != fmt.Sprint([]string{"mac set adr on", "mac join otaa"}) {
+		t.Errorf("commands = %v", written)
+	}
+	if len(events) != 1 || events[0].Expected || !events[0].SessionLost || !events[0].Recovered {
+		t.Errorf("events = %+v; should be one recovered unexpected reset", events)
+	}
+	if !Joined() {
+		t.Error("Joined() = false after the rejoin")
+	}
+}
+
+func TestFilterBannerWanted(t *testing.T) {
+	defer resetRecovery()
+
+	var events []ResetEvent
+	SetResetHandler(func(e ResetEvent) { events = append(events, e) })
+
+	expectReset()
+	state.bannerWanted = true
+	b := []byte(testBanner + "\r\n")
+
+	if got := filterBanner(b); string(got) != string(b) {
+		t.Errorf("filterBanner() = %q; should keep the wanted banner", got)
+	}
+	handlePendingResets()
+	if len(events) != 1 || !events[0].Expected || state.bannerWanted {
+		t.Errorf("events = %+v; should be one expected reset", events)
+	}
+}
+
+func TestResetWriteErrorNotExpected(t *testing.T) {
+	defer resetOriginals()
+	defer resetRecovery()
+	mockSerial(t, nil)
+
+	var events []ResetEvent
+	SetResetHandler(func(e ResetEvent) { events = append(events, e) })
+
+	serialWrite = func(s string) error { return errors.New("write failed") }
+	if Reset() {
+		t.Fatal("Reset() = true; should be false when the write fails")
+	}
+
+	filterBanner([]byte(testBanner + "\r\n"))
+	handlePendingResets()
+
+	if len(events) != 1 || events[0].Expected {
+		t.Errorf("events = %+v; should be one unexpected reset", events)
+	}
+}
+
+func TestResetExpectedDeadline(t *testing.T) {
+	defer resetOriginals()
+	defer resetRecovery()
+	mockSerial(t, nil)
+
+	var events []ResetEvent
+	SetResetHandler(func(e ResetEvent) { events = append(events, e) })
+
+	// the banner of the reset was flushed, a later one is unexpected
+	Reset()
+	state.resetExpected = time.Now().Add(-time.Millisecond)
+
+	filterBanner([]byte(testBanner + "\r\n"))
+	handlePendingResets()
+
+	if len(events) != 1 || events[0].Expected {
+		t.Errorf("events = %+v; should be one unexpected reset", events)
+	}
+}
+
+func TestRecoveryAfterCommand(t *testing.T) {
+	defer resetOriginals()
+	defer resetRecovery()
+	written := mockSerial(t, map[string]string{"mac get dr": testBanner + "\r\n5"})
+
+	read := serialRead
+	serialRead = func() (int, []byte) {
+		_, b := read()
+		b = filterBanner(b)
+		return len(b), b
+	}
+
+	// the recovery sends its commands once the interrupted one is done
+	SetRecoveryProfile(&RecoveryProfile{Setup: func() error { return MacSetADR(true) }})
+
+	answer, err := query("mac get dr")
+	if err != nil || answer != "5" {
+		t.Errorf("query() = %v, %v; should be 5", answer, err)
+	}
+
+	if fmt.Sprint(*written) != fmt.Sprint([]string{"mac get dr", "mac set adr on"}) {
+		t.Errorf("commands = %v", *written)
+	}
+}
@@ -23,6 +23,8 @@
 package rn2483
 
 import (
+	"errors"
+	"io"
 	"os"
 	"time"
 
@@ -63,6 +65,8 @@ func read() (int, []byte) {
 
 	DEBUG.Printf("%v bytes read: %s", len(b), string(b))
 
+	b = filterBanner(b)
+
 	return len(b), b
 }
 
@@ -75,6 +79,8 @@ func write(s string) error {
 		}
 	}()
 
+	state.lastCommand = s
+
 	b := append([]byte(s), []byte("\r\n")...)
 	n, err = rn2483.Write(b)
 	if err != nil {
@@ -92,6 +98,15 @@ func flush() {
 	}
 }
 
+// port returns the connected serial port, for the bootloader.
+func port() (io.ReadWriter, error) {
+	if rn2483 == nil {
+		return nil, errors.New("not connected")
+	}
+
+	return rn2483, nil
+}
+
 // Connect will connect to the serial device currently configured.
 func Connect() {
 	rn2483, err = serial.OpenPort(config)
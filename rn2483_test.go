@@ -23,6 +23,9 @@
 package rn2483
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -56,3 +59,67 @@ func TestSetTimeout(t *testing.T) {
 		t.Errorf("config.ReadTimeout = %v; should be %v", config.ReadTimeout, after)
 	}
 }
+
+// mockSerial replaces the serial connection with a fake module that answers
+// every command with the answer registered for the longest matching prefix,
+// or "ok". It returns the list of commands that will be written.
+func mockSerial(t *testing.T, answers map[string]string) *[]string {
+	var written []string
+	var last string
+
+	serialWrite = func(s string) error {
+		t.Logf("String written to serial: %v", s)
+		written = append(written, s)
+		last = s
+		return nil
+	}
+
+	serialFlush = func() {}
+
+	serialRead = func() (int, []byte) {
+		answer, match := "ok", ""
+		for prefix, a := range answers {
+			if strings.HasPrefix(last, prefix) && len(prefix) >= len(match) {
+				answer, match = a, prefix
+			}
+		}
+
+		b := []byte(answer + "\r\n")
+		return len(b), b
+	}
+
+	return &written
+}
+
+func TestExchangeSerialized(t *testing.T) {
+	defer resetOriginals()
+
+	var last string
+	serialWrite = func(s string) error {
+		last = s
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	serialRead = func() (int, []byte) {
+		b := []byte(strings.TrimPrefix(last, "sys get nvm ") + "\r\n")
+		return len(b), b
+	}
+
+	errs := make(chan string)
+	for i := 0; i < 10; i++ {
+		go func(address string) {
+			answer, err := query("sys get nvm " + address)
+			if err != nil || answer != address {
+				errs <- fmt.Sprintf("query(%v) = %v, %v", address, answer, err)
+				return
+			}
+			errs <- ""
+		}(strconv.Itoa(300 + i))
+	}
+
+	for i := 0; i < 10; i++ {
+		if e := <-errs; e != "" {
+			t.Error(e)
+		}
+	}
+}
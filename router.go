@@ -0,0 +1,227 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uplink describes the uplink transmission after which a downlink was received.
+type Uplink struct {
+	Port      uint8
+	Confirmed bool
+	Size      int
+	Time      time.Time
+}
+
+// Downlink is a message received from the network server, together with the
+// metadata that is known about its reception. Uplink is nil for unsolicited
+// (Class C) downlinks. SNR and RSSI are only valid when HasSNR and HasRSSI
+// are set.
+type Downlink struct {
+	Port    uint8
+	Data    []byte
+	Time    time.Time
+	Uplink  *Uplink
+	SNR     int8
+	HasSNR  bool
+	RSSI    int16
+	HasRSSI bool
+}
+
+// DownlinkHandler handles a downlink that has been routed to it.
+type DownlinkHandler func(d Downlink)
+
+type route struct {
+	from    uint8
+	to      uint8
+	handler DownlinkHandler
+}
+
+// Router dispatches downlinks to the handler registered for their FPort.
+// When no handler matches, the fallback handler is called (if any).
+type Router struct {
+	mu       sync.RWMutex
+	routes   []route
+	fallback DownlinkHandler
+	pollers  []func()
+}
+
+// NewRouter returns an empty downlink router.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers the handler for downlinks on the given port.
+func (r *Router) Handle(port uint8, handler DownlinkHandler) {
+	r.HandleRange(port, port, handler)
+}
+
+// HandleRange registers the handler for downlinks on the ports in the
+// range [from, to]. Handlers that are registered earlier take precedence.
+func (r *Router) HandleRange(from, to uint8, handler DownlinkHandler) {
+	if from > to {
+		from, to = to, from
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = append(r.routes, route{from: from, to: to, handler: handler})
+}
+
+// HandleFallback registers the handler for downlinks on ports without
+// a registered handler.
+func (r *Router) HandleFallback(handler DownlinkHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fallback = handler
+}
+
+// HandlePoll registers a function that is called by Poll, for handlers with
+// delayed work like an uplink that has to wait for a random delay.
+func (r *Router) HandlePoll(poller func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pollers = append(r.pollers, poller)
+}
+
+// Poll calls the functions registered with HandlePoll on the goroutine of
+// the caller. Listen polls between reads, applications that don't listen
+// should call it in their loop.
+func (r *Router) Poll() {
+	r.mu.RLock()
+	pollers := r.pollers
+	r.mu.RUnlock()
+
+	for _, poller := range pollers {
+		poller()
+	}
+}
+
+// Dispatch passes the downlink to the matching handler.
+// It returns false if no handler was found.
+func (r *Router) Dispatch(d Downlink) bool {
+	r.mu.RLock()
+	handler := r.fallback
+	for _, rt := range r.routes {
+		if d.Port >= rt.from && d.Port <= rt.to {
+			handler = rt.handler
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if handler == nil {
+		WARN.Printf("router: no handler for downlink on port %v", d.Port)
+		return false
+	}
+
+	handler(d)
+
+	return true
+}
+
+// callback returns a receive callback for MacTx that routes the received
+// downlink, linked to the given uplink.
+func (r *Router) callback(uplink *Uplink) receiveCallback {
+	return func(port uint8, data []byte) {
+		r.Dispatch(newDownlink(port, data, uplink))
+	}
+}
+
+// Listen reads unsolicited downlinks (Class C) from the module and routes
+// them until the stop channel is closed.
+func (r *Router) Listen(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+			r.Poll()
+
+			n, answer := receive()
+			if n == 0 {
+				continue
+			}
+
+			for _, line := range strings.Split(string(answer), "\r\n") {
+				if !strings.HasPrefix(line, "mac_rx") {
+					continue
+				}
+
+				port, data, err := parseMacRx(line)
+				if err != nil {
+					WARN.Println("router listen error:", err)
+					continue
+				}
+
+				r.Dispatch(newDownlink(port, data, nil))
+			}
+		}
+	}
+}
+
+// MacTxRouted transmits the given data like MacTx, but passes a received
+// downlink to the router together with its reception metadata.
+func MacTxRouted(confirmed bool, port uint8, data []byte, router *Router) bool {
+	uplink := &Uplink{
+		Port:      port,
+		Confirmed: confirmed,
+		Size:      len(data),
+		Time:      time.Now(),
+	}
+
+	var callback receiveCallback
+	if router != nil {
+		callback = router.callback(uplink)
+	}
+
+	return MacTx(confirmed, port, data, callback)
+}
+
+// newDownlink collects the metadata of a downlink that was just received.
+func newDownlink(port uint8, data []byte, uplink *Uplink) Downlink {
+	d := Downlink{
+		Port:   port,
+		Data:   data,
+		Time:   time.Now(),
+		Uplink: uplink,
+	}
+
+	if snr := RadioGetSNR(); snr != -128 {
+		d.SNR = snr
+		d.HasSNR = true
+	}
+
+	if rssi, err := RadioGetRSSI(); err == nil {
+		d.RSSI = rssi
+		d.HasRSSI = true
+	}
+
+	return d
+}
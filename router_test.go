@@ -0,0 +1,149 @@
+// The MIT License (MIT)
+//
+// Copyright © 2017 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rn2483
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRouterDispatch(t *testing.T) {
+	var got []string
+
+	r := NewRouter()
+	r.Handle(10, func(d Downlink) { got = append(got, "port") })
+	r.HandleRange(1, 20, func(d Downlink) { got = append(got, "range") })
+	r.HandleFallback(func(d Downlink) { got = append(got, "fallback") })
+
+	for _, port := range []uint8{10, 11, 100} {
+		if r.Dispatch(Downlink{Port: port}) == false {
+			t.Errorf("Dispatch() on port %v returned false while a fallback is registered", port)
+		}
+	}
+
+	expected := []string{"port", "range", "fallback"}
+	for i := range expected {
+		if i >= len(got) || got[i] != expected[i] {
+			t.Fatalf("Dispatch() called %v; should be %v", got, expected)
+		}
+	}
+}
+
+func TestRouterDispatchNoHandler(t *testing.T) {
+	r := NewRouter()
+	r.Handle(1, func(d Downlink) {})
+
+	if r.Dispatch(Downlink{Port: 2}) == true {
+		t.Error("Dispatch() returned true while no handler is registered for the port")
+	}
+}
+
+func TestMacTxRouted(t *testing.T) {
+	counter := 0
+
+	serialWrite = func(s string) error {
+		t.Logf("String written to serial: %v", s)
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		var b = [][]byte{
+			[]byte("ok\r\n"),
+			[]byte("mac_rx 200 AABB\r\n"),
+			[]byte("-7\r\n"),
+		}
+
+		data := b[counter]
+
+		counter = (counter + 1) % len(b)
+
+		return len(data), data
+	}
+
+	defer resetOriginals()
+
+	var received *Downlink
+
+	r := NewRouter()
+	r.Handle(200, func(d Downlink) { received = &d })
+
+	if MacTxRouted(false, 2, []byte("test"), r) == false {
+		t.Fatal("MacTxRouted() returned false")
+	}
+
+	if received == nil {
+		t.Fatal("MacTxRouted() did not route the downlink")
+	}
+
+	if !bytes.Equal(received.Data, []byte{0xAA, 0xBB}) {
+		t.Errorf("Downlink data = %X; should be AABB", received.Data)
+	}
+
+	if received.Uplink == nil || received.Uplink.Port != 2 || received.Uplink.Size != 4 {
+		t.Errorf("Downlink uplink = %+v; should reference the uplink on port 2", received.Uplink)
+	}
+
+	if !received.HasSNR || received.SNR != -7 {
+		t.Errorf("Downlink metadata = %+v; should have SNR -7", received)
+	}
+}
+
+func TestRouterListen(t *testing.T) {
+	stop := make(chan struct{})
+	counter := 0
+
+	serialWrite = func(s string) error {
+		t.Logf("String written to serial: %v", s)
+		return nil
+	}
+
+	serialRead = func() (int, []byte) {
+		var b = [][]byte{
+			[]byte("mac_rx 5 01\r\n"),
+			[]byte("-128\r\n"),
+		}
+
+		data := b[counter]
+
+		counter = (counter + 1) % len(b)
+
+		return len(data), data
+	}
+
+	defer resetOriginals()
+
+	r := NewRouter()
+	r.HandleFallback(func(d Downlink) {
+		if d.Uplink != nil {
+			t.Error("Unsolicited downlink should not reference an uplink")
+		}
+
+		if d.HasSNR {
+			t.Error("Downlink should not have an SNR when the module reports -128")
+		}
+
+		close(stop)
+	})
+
+	r.Listen(stop)
+}
@@ -26,6 +26,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 )
 
 // Sleep puts the RN2483 chip to sleep for the specified number of milliseconds.
@@ -35,13 +36,12 @@ func Sleep(length uint32) bool {
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("sys sleep %v", length))
+	n, answer, err := exchange(fmt.Sprintf("sys sleep %v", length))
 	if err != nil {
 		WARN.Println("sys sleep error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("sys sleep error: invalid parameter")
 		return false
@@ -51,18 +51,123 @@ func Sleep(length uint32) bool {
 }
 
 // Reset will reset and restart the RN2483 module.
+// The boot banner it prints is not reported as an unexpected reset.
 func Reset() bool {
+	lockSerial()
+	defer unlockSerial()
+
+	expectReset()
+	state.joined = false
+	state.macPaused = false
+
 	err := serialWrite("sys reset")
 	if err != nil {
+		state.resetExpected = time.Time{}
 		WARN.Println("reset error:", err)
 		return false
 	}
 
+	// the banner may be flushed, the stack isn't joined after a reset
+	state.macIdle = true
 	serialFlush()
 
 	return true
 }
 
+// Confirmation confirms a destructive command, see FactoryReset and EraseFirmware.
+type Confirmation string
+
+// The confirmations of the destructive commands
+const (
+	ConfirmFactoryReset  Confirmation = "erase all settings"
+	ConfirmEraseFirmware Confirmation = "erase firmware and enter bootloader"
+)
+
+// bannerTimeout is how long FactoryReset waits for the module to reboot,
+// and how long a banner is expected after a reset
+var bannerTimeout = 5 * time.Second
+
+// FactoryReset restores the factory settings of the module, including the
+// EEPROM, and waits for it to reboot. It only runs with ConfirmFactoryReset
+// and returns the firmware version of the boot banner.
+func FactoryReset(confirm Confirmation) (FirmwareVersion, error) {
+	if confirm != ConfirmFactoryReset {
+		return FirmwareVersion{}, errors.New("factory reset not confirmed")
+	}
+
+	lockSerial()
+	defer unlockSerial()
+
+	expectReset()
+	state.bannerWanted = true
+	state.joined = false
+	state.macPaused = false
+
+	err := serialWrite("sys factoryRESET")
+	if err != nil {
+		state.resetExpected = time.Time{}
+		state.bannerWanted = false
+		WARN.Println("sys factoryRESET error:", err)
+		return FirmwareVersion{}, err
+	}
+
+	deadline := time.Now().Add(bannerTimeout)
+	for time.Now().Before(deadline) {
+		n, answer := serialRead()
+		if n == 0 {
+			continue
+		}
+
+		v, err := ParseFirmwareVersion(string(sanitize(answer)))
+		if err != nil {
+			state.bannerWanted = false
+			return v, fmt.Errorf("factory reset: unexpected answer %q", string(sanitize(answer)))
+		}
+
+		firmware = &v
+
+		return v, nil
+	}
+
+	state.bannerWanted = false
+
+	return FirmwareVersion{}, errors.New("factory reset: no boot banner")
+}
+
+// EraseFirmware erases the firmware of the module, which drops into its
+// bootloader and only accepts a firmware update afterwards. It only runs
+// with ConfirmEraseFirmware and returns the bootloader on the serial port
+// to continue with Bootloader.Update. The bootloader holds the serial port
+// until it is closed, so other commands wait for the update.
+func EraseFirmware(confirm Confirmation) (*Bootloader, error) {
+	if confirm != ConfirmEraseFirmware {
+		return nil, errors.New("firmware erase not confirmed")
+	}
+
+	port, err := serialPort()
+	if err != nil {
+		return nil, err
+	}
+
+	lockSerial()
+
+	err = serialWrite("sys eraseFW")
+	if err != nil {
+		unlockSerial()
+		WARN.Println("sys eraseFW error:", err)
+		return nil, err
+	}
+
+	state.joined = false
+	state.macPaused = false
+	firmware = nil
+	serialFlush()
+
+	b := NewBootloader(port)
+	b.release = unlockSerial
+	return b, nil
+}
+
 // SaveByte allows the user to modify the EEPROM at the specified address
 // with the specified data (one byte).
 func SaveByte(address uint16, data uint8) bool {
@@ -71,13 +176,12 @@ func SaveByte(address uint16, data uint8) bool {
 		return false
 	}
 
-	err := serialWrite(fmt.Sprintf("sys set nvm %X %X", address, data))
+	n, answer, err := exchange(fmt.Sprintf("sys set nvm %X %X", address, data))
 	if err != nil {
 		WARN.Println("sys set nvm error:", err)
 		return false
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("sys set nvm error: invalid parameter")
 		return false
@@ -93,13 +197,12 @@ func ReadByte(address uint16) (byte, error) {
 		return 0, errors.New("address out of range [768-1023]")
 	}
 
-	err := serialWrite(fmt.Sprintf("sys get nvm %X", address))
+	n, answer, err := exchange(fmt.Sprintf("sys get nvm %X", address))
 	if err != nil {
 		WARN.Println("sys get nvm error:", err)
 		return 0, err
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("sys get nvm error: invalid parameter")
 		return 0, errors.New("invalid parameter")
@@ -117,13 +220,12 @@ func ReadByte(address uint16) (byte, error) {
 // Version returns the information related to the hardware platform,
 // firmware version, release date and time stamp on firmware creation.
 func Version() string {
-	err := serialWrite("sys get ver")
+	n, answer, err := exchange("sys get ver")
 	if err != nil {
 		WARN.Println("sys get ver error:", err)
 		return ""
 	}
 
-	n, answer := serialRead()
 	if n == 0 {
 		WARN.Println("sys get ver error: no answer")
 		return ""
@@ -134,13 +236,12 @@ func Version() string {
 
 // Voltage will return the voltage measured on Vdd in millivolts
 func Voltage() (uint16, error) {
-	err := serialWrite("sys get vdd")
+	n, answer, err := exchange("sys get vdd")
 	if err != nil {
 		WARN.Println("sys get vdd error:", err)
 		return 0, err
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("sys get vdd error: invalid parameter")
 		return 0, errors.New("invalid parameter")
@@ -158,13 +259,12 @@ func Voltage() (uint16, error) {
 // HardwareID will return the HWEUI of the RN2483 module as a string.
 // The HWEUI is actually an 8 bit hex string.
 func HardwareID() string {
-	err := serialWrite("sys get hweui")
+	n, answer, err := exchange("sys get hweui")
 	if err != nil {
 		WARN.Println("sys get hweui error:", err)
 		return ""
 	}
 
-	n, answer := serialRead()
 	if n == 0 || string(sanitize(answer)) == invalidParameter {
 		WARN.Println("sys get hweui error: invalid parameter")
 		return ""
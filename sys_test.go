@@ -23,8 +23,11 @@
 package rn2483
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"testing"
+	"time"
 )
 
 func TestSleepWrongArgument(t *testing.T) {
@@ -586,3 +589,101 @@ func TestHardwareIDSuccess(t *testing.T) {
 		t.Errorf("HardwareID() returned empty string while the serial write and read succeeded")
 	}
 }
+
+func TestFactoryResetNotConfirmed(t *testing.T) {
+	defer resetOriginals()
+	written := mockSerial(t, nil)
+
+	if _, err := FactoryReset(ConfirmEraseFirmware); err == nil {
+		t.Error("FactoryReset() succeeded without confirmation")
+	}
+
+	if len(*written) != 0 {
+		t.Errorf("commands = %v; should be none", *written)
+	}
+}
+
+func TestFactoryResetSuccess(t *testing.T) {
+	defer resetOriginals()
+	defer restoreModule()
+	written := mockSerial(t, map[string]string{"sys factoryRESET": "RN2483 1.0.5 Oct 31 2018 15:06:52"})
+
+	v, err := FactoryReset(ConfirmFactoryReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (*written)[0] != "sys factoryRESET" {
+		t.Errorf("command = %v; should be sys factoryRESET", (*written)[0])
+	}
+	if v.String() != "RN2483 1.0.5" || firmware == nil || *firmware != v {
+		t.Errorf("FactoryReset() = %v; should be RN2483 1.0.5", v)
+	}
+}
+
+func TestFactoryResetNoBanner(t *testing.T) {
+	defer resetOriginals()
+	defer func(timeout time.Duration) { bannerTimeout = timeout }(bannerTimeout)
+	mockSerial(t, nil)
+
+	serialRead = func() (int, []byte) {
+		return 0, nil
+	}
+	bannerTimeout = 10 * time.Millisecond
+
+	if _, err := FactoryReset(ConfirmFactoryReset); err == nil {
+		t.Error("FactoryReset() succeeded without boot banner")
+	}
+	if state.bannerWanted {
+		t.Error("FactoryReset() still waits for a banner after the timeout")
+	}
+}
+
+func TestEraseFirmware(t *testing.T) {
+	defer resetOriginals()
+	written := mockSerial(t, nil)
+
+	var port bytes.Buffer
+	serialPort = func() (io.ReadWriter, error) {
+		return &port, nil
+	}
+
+	if _, err := EraseFirmware(ConfirmFactoryReset); err == nil {
+		t.Error("EraseFirmware() succeeded without confirmation")
+	}
+	if len(*written) != 0 {
+		t.Errorf("commands = %v; should be none", *written)
+	}
+
+	b, err := EraseFirmware(ConfirmEraseFirmware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*written)[0] != "sys eraseFW" {
+		t.Errorf("command = %v; should be sys eraseFW", (*written)[0])
+	}
+	if b == nil || b.port != &port {
+		t.Error("EraseFirmware() didn't hand over the serial port to the bootloader")
+	}
+
+	// commands wait until the bootloader is closed
+	locked := make(chan struct{})
+	go func() {
+		lockSerial()
+		close(locked)
+		unlockSerial()
+	}()
+
+	select {
+	case <-locked:
+		t.Error("serial port released before the bootloader was closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.Close()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("serial port not released by Close()")
+	}
+}